@@ -0,0 +1,27 @@
+package fips
+
+import (
+	"crypto/tls"
+)
+
+// approvedCipherSuites are TLS 1.2 cipher suites approved for FIPS 140-3,
+// TLS 1.3 suites can't be configured and are restricted by the Go FIPS module itself
+var approvedCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// NewTLSConfig returns a client TLS configuration which in FIPS 140 exclusive mode
+// allows only approved protocol versions, cipher suites and curves.
+func NewTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if IsFIPS140Only() {
+		cfg.CipherSuites = approvedCipherSuites
+		cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	}
+	return cfg
+}
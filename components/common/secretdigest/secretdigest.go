@@ -0,0 +1,21 @@
+package secretdigest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+)
+
+// Sum returns the digest of the content of a Secret, the same for the Secret read by the controller
+// and the files of its volume loaded by the connection
+func Sum(data map[string]string) string {
+	h := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(data[key]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
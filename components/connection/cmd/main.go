@@ -2,25 +2,45 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kyma-project/registry-proxy/components/common/fips"
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/blobcache"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
 	"github.com/kyma-project/registry-proxy/components/connection/internal/probes"
 	"github.com/kyma-project/registry-proxy/components/connection/internal/reverseproxy"
-	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/secretdir"
 
 	"github.com/kyma-project/manager-toolkit/logging/logger"
 	"go.uber.org/zap"
 )
 
+const (
+	defaultDrainPeriod     = 5 * time.Second
+	defaultShutdownTimeout = 60 * time.Second
+	probesShutdownTimeout  = 5 * time.Second
+)
+
 func main() {
 	if !fips.IsFIPS140Only() {
 		log.Panic("FIPS 140 exclusive mode is not enabled. Check GODEBUG flags.")
 	}
+	// the signal is handled from the start, so a termination during a long setup, e.g. indexing a large blob cache,
+	// doesn't kill the process before the shutdown below
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
 	var proxyAddr string
 	var probeAddr string
 	var connectivityProxyAddress string
@@ -55,27 +75,167 @@ func main() {
 	targetHost = os.Getenv("TARGET_HOST")
 
 	locationID := os.Getenv("LOCATION_ID")
+	// locations of other Cloud Connectors used in order when the one of LOCATION_ID can't be reached
+	failoverLocationIDs := splitEnv("FAILOVER_LOCATION_IDS")
 
 	authPort := os.Getenv("AUTHORIZATION_NODE_PORT")
 
-	// read /secrets/authorization file is it exists and store it in authorizationHeader
-	authorizationHeaderData, err := os.ReadFile("/secrets/authorization/authorizationHeader")
+	// methods clients may use, the proxy is read-only if not set
+	allowedMethods := splitEnv("ALLOWED_METHODS")
+
+	// repositories clients may use, all are available if not set
+	repositories, err := distribution.NewRepositoryFilter(splitEnv("REPOSITORY_ALLOW"), splitEnv("REPOSITORY_DENY"))
+	if err != nil {
+		zapLogger.Panicf("unable to setup repository filter: %s", err)
+	}
+
+	// after a termination signal, the connection is reported as not ready for the drain period and then waits
+	// up to the shutdown timeout for in-flight requests, e.g. large blob downloads
+	drainPeriod, err := durationEnv("SHUTDOWN_DRAIN_PERIOD", defaultDrainPeriod)
+	if err != nil {
+		zapLogger.Panic(err)
+	}
+	shutdownTimeout, err := durationEnv("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		zapLogger.Panic(err)
+	}
+
+	// the authorization container proxies the token endpoint of the registry
+	isAuthorization := os.Getenv("AUTHORIZATION_PROXY") == "true"
+
+	// spans are exported only if the OTLP endpoint is configured with the standard OTEL_EXPORTER_OTLP_ENDPOINT env
+	serviceName := "registry-proxy-connection"
+	if isAuthorization {
+		serviceName = "registry-proxy-connection-authorization"
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		zapLogger.Panicf("unable to setup tracing: %s", err)
+	}
+
+	// secrets are mounted as volumes and reloaded when they change, so rotated credentials are used without restart
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	authorizationSecret, err := secretdir.Load("/secrets/authorization", zapLogger)
 	if err != nil {
-		if os.IsNotExist(err) {
-			zapLogger.Info("No authorization header file found, proceeding without it")
-		} else {
-			zapLogger.Panicf("Error reading authorization header file: %s", err)
+		zapLogger.Panicf("Error reading authorization header file: %s", err)
+	}
+	var authorizationHeader func() string
+	// the status endpoint reports which content of the Secret is used, as kubelet updates the volume with a delay
+	var credentialsDigest func() string
+	if authorizationSecret == nil {
+		zapLogger.Info("No authorization header file found, proceeding without it")
+	} else {
+		go watchSecret(watchCtx, authorizationSecret, zapLogger)
+		authorizationHeader = func() string {
+			return authorizationSecret.Get("authorizationHeader")
 		}
+		credentialsDigest = authorizationSecret.Digest
+	}
+
+	credentialsSecret, err := secretdir.Load("/secrets/credentials", zapLogger)
+	if err != nil {
+		zapLogger.Panicf("Error reading registry credentials: %s", err)
+	}
+	if credentialsSecret != nil && authorizationSecret != nil {
+		// the status would report the digest of only one of them
+		zapLogger.Panic("Authorization header and registry credentials can't be used together")
+	}
+	var credentials func() reverseproxy.Credentials
+	if credentialsSecret != nil {
+		go watchSecret(watchCtx, credentialsSecret, zapLogger)
+		credentials = func() reverseproxy.Credentials {
+			return reverseproxy.Credentials{
+				Username: strings.TrimSpace(credentialsSecret.Get("username")),
+				Password: strings.TrimSpace(credentialsSecret.Get("password")),
+			}
+		}
+		credentialsDigest = credentialsSecret.Digest
+	}
+
+	caBundle, err := secretdir.Load("/secrets/ca", zapLogger)
+	if err != nil {
+		zapLogger.Panicf("Error reading CA bundle: %s", err)
+	}
+	var rootCAs func() *x509.CertPool
+	if caBundle != nil {
+		go watchSecret(watchCtx, caBundle, zapLogger)
+		rootCAs = newRootCAs(caBundle, zapLogger)
+	}
+
+	headersSecret, err := secretdir.Load("/secrets/headers", zapLogger)
+	if err != nil {
+		zapLogger.Panicf("Error reading header values: %s", err)
+	}
+	if headersSecret != nil {
+		go watchSecret(watchCtx, headersSecret, zapLogger)
+	}
+	headers, err := newHeaders(headersSecret)
+	if err != nil {
+		zapLogger.Panicf("unable to setup headers: %s", err)
+	}
+
+	accessLog, err := newAccessLog()
+	if err != nil {
+		zapLogger.Panicf("unable to setup access log: %s", err)
+	}
+
+	limits, err := newLimits()
+	if err != nil {
+		zapLogger.Panicf("unable to setup limits: %s", err)
+	}
+
+	blobCache, err := newBlobCache(zapLogger)
+	if err != nil {
+		zapLogger.Panicf("unable to setup blob cache: %s", err)
+	}
+
+	spoolLimit, err := newSpoolLimit()
+	if err != nil {
+		zapLogger.Panicf("unable to setup spooling: %s", err)
 	}
-	authorizationHeader := string(authorizationHeaderData)
 
 	zapLogger.Infof("Registering reverse proxy on %s through %s", proxyAddr, connectivityProxyAddress)
-	reverseProxyServer, err := reverseproxy.New(proxyAddr, connectivityProxyAddress, targetHost, locationID, authPort, authorizationHeader, zapLogger)
+	reverseProxyServer, err := reverseproxy.New(reverseproxy.Config{
+		Address:              proxyAddr,
+		ConnectivityProxyURL: connectivityProxyAddress,
+		TargetHost:           targetHost,
+		LocationID:           locationID,
+		FailoverLocationIDs:  failoverLocationIDs,
+		AuthorizationPort:    authPort,
+		AuthorizationHeader:  authorizationHeader,
+		BlobCache:            blobCache,
+		Authorization:        isAuthorization,
+		Credentials:          credentials,
+		CredentialsDigest:    credentialsDigest,
+		AllowedMethods:       allowedMethods,
+		Repositories:         repositories,
+		TargetScheme:         os.Getenv("TARGET_SCHEME"),
+		TargetServerName:     os.Getenv("TARGET_SERVER_NAME"),
+		RootCAs:              rootCAs,
+		AccessLog:            accessLog,
+		Headers:              headers,
+		StripRequestHeaders:  splitEnv("STRIP_REQUEST_HEADERS"),
+		StripResponseHeaders: splitEnv("STRIP_RESPONSE_HEADERS"),
+		Limits:               limits,
+		SpoolLimit:           spoolLimit,
+		Paths:                distribution.NewPathMapping(os.Getenv("TARGET_BASE_PATH"), os.Getenv("REPOSITORY_PREFIX")),
+	}, zapLogger)
 	if err != nil {
 		log.Panicf("unable to setup reverse proxy: %s", err)
 	}
 
-	probesServer := probes.New(probeAddr, reverseProxyServer.HTTPServer.Addr, zapLogger)
+	if signalCtx.Err() != nil {
+		// the connection never got ready, so there is nothing to drain
+		zapLogger.Info("received termination signal during setup, exiting")
+		if err = shutdownTracing(context.Background()); err != nil {
+			zapLogger.Errorf("error while flushing spans: %v", err)
+		}
+		return
+	}
+
+	probesServer := probes.New(probeAddr, reverseProxyServer, zapLogger)
 
 	stop := make(chan bool)
 
@@ -85,37 +245,189 @@ func main() {
 	zapLogger.Info("Starting probes server")
 	go probesServer.Serve(stop)
 
-	// wait for channel to return anything
-	shouldStop := <-stop
-	if shouldStop {
+	// wait for a termination signal or any server to fail
+	select {
+	case <-signalCtx.Done():
+		zapLogger.Infof("received termination signal, draining for %s", drainPeriod)
+		// readiness fails while requests are still served, so the endpoint is removed before the listener closes
+		reverseProxyServer.Drain()
+		time.Sleep(drainPeriod)
+	case <-stop:
 		zapLogger.Info("one or more servers have closed, stopping all servers")
-		err = shutdownServer(reverseProxyServer)
+	}
+
+	zapLogger.Infof("Waiting up to %s for in-flight requests", shutdownTimeout)
+	err = reverseProxyServer.Shutdown(shutdownTimeout)
+	if err != nil {
+		zapLogger.Errorf("error while shutting down reverse proxy server: %v", err)
+	}
+
+	err = probesServer.Shutdown(probesShutdownTimeout)
+	if err != nil {
+		zapLogger.Errorf("error while shutting down probes server: %v", err)
+	}
+	if err = shutdownTracing(context.Background()); err != nil {
+		zapLogger.Errorf("error while flushing spans: %v", err)
+	}
+	zapLogger.Info("all servers stopped")
+}
+
+// durationEnv returns the duration from the env or the fallback if it's not set
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	if os.Getenv(name) == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s env: %w", name, err)
+	}
+	return duration, nil
+}
+
+// splitEnv returns comma separated values of the env or nil if it's not set
+func splitEnv(name string) []string {
+	if os.Getenv(name) == "" {
+		return nil
+	}
+	return strings.Split(os.Getenv(name), ",")
+}
+
+func watchSecret(ctx context.Context, secret *secretdir.Dir, log *zap.SugaredLogger) {
+	if err := secret.Watch(ctx); err != nil {
+		log.Errorf("unable to watch secret, changes will be applied after restart: %v", err)
+	}
+}
+
+// newRootCAs returns system authorities extended with the current CA bundle, which is parsed again only when it changes
+func newRootCAs(caBundle *secretdir.Dir, log *zap.SugaredLogger) func() *x509.CertPool {
+	var mu sync.Mutex
+	var lastBundle string
+	var pool *x509.CertPool
+	return func() *x509.CertPool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		bundle := caBundle.Get("ca.crt")
+		if pool != nil && bundle == lastBundle {
+			return pool
+		}
+		systemPool, err := x509.SystemCertPool()
 		if err != nil {
-			zapLogger.Errorf("error while shutting down reverse proxy server: %v", err)
+			log.Warnf("couldn't load system certificates, trusting only the CA bundle: %v", err)
+			systemPool = x509.NewCertPool()
 		}
+		if !systemPool.AppendCertsFromPEM([]byte(bundle)) {
+			log.Warn("CA bundle contains no valid certificates")
+		}
+		lastBundle, pool = bundle, systemPool
+		return pool
+	}
+}
 
-		err = shutdownServer(probesServer)
-		if err != nil {
-			zapLogger.Errorf("error while shutting down probes server: %v", err)
+// newAccessLog enables the access log if ACCESS_LOG_RATE_LIMIT env is set
+func newAccessLog() (*reverseproxy.AccessLog, error) {
+	if os.Getenv("ACCESS_LOG_RATE_LIMIT") == "" {
+		return nil, nil
+	}
+	rateLimit, err := strconv.ParseFloat(os.Getenv("ACCESS_LOG_RATE_LIMIT"), 64)
+	if err != nil || rateLimit <= 0 {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_RATE_LIMIT env %q, expected a positive number", os.Getenv("ACCESS_LOG_RATE_LIMIT"))
+	}
+	return &reverseproxy.AccessLog{RateLimit: rateLimit}, nil
+}
+
+// newHeaders reads headers set on forwarded requests from ADD_HEADERS env,
+// values of headers stored in Secrets are read from the mounted files whenever they're used
+func newHeaders(headersSecret *secretdir.Dir) ([]reverseproxy.Header, error) {
+	if os.Getenv("ADD_HEADERS") == "" {
+		return nil, nil
+	}
+	var added []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		File  string `json:"file"`
+	}
+	if err := json.Unmarshal([]byte(os.Getenv("ADD_HEADERS")), &added); err != nil {
+		return nil, fmt.Errorf("invalid ADD_HEADERS env: %w", err)
+	}
+
+	headers := make([]reverseproxy.Header, 0, len(added))
+	for _, header := range added {
+		if header.File == "" {
+			value := header.Value
+			headers = append(headers, reverseproxy.Header{Name: header.Name, Value: func() string { return value }})
+			continue
+		}
+		if headersSecret == nil {
+			return nil, fmt.Errorf("value of header %s is stored in a Secret, but no Secret is mounted", header.Name)
 		}
+		file := header.File
+		headers = append(headers, reverseproxy.Header{
+			Name: header.Name,
+			Value: func() string {
+				return strings.TrimSpace(headersSecret.Get(file))
+			},
+			Secret: true,
+		})
 	}
-	zapLogger.Info("all servers stopped")
+	return headers, nil
+}
 
-	// sanity check
-	select {
-	case s, ok := <-stop:
-		if ok {
-			zapLogger.Errorf("channel stop still contains %s", strconv.FormatBool(s))
-		} else {
-			zapLogger.Info("channel stop is closed")
+// newLimits reads limits of requests to the target registry from LIMIT_* envs, nil is returned if none is set
+func newLimits() (*reverseproxy.Limits, error) {
+	values := map[string]int64{}
+	for _, name := range []string{"LIMIT_MAX_CONCURRENT_REQUESTS", "LIMIT_BYTES_PER_SECOND", "LIMIT_QUEUE_SIZE"} {
+		if os.Getenv(name) == "" {
+			continue
 		}
-	default:
-		zapLogger.Info("channel stop is empty")
+		value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid %s env %q, expected a positive number", name, os.Getenv(name))
+		}
+		values[name] = value
+	}
+	requestsPerSecond := 0.0
+	if os.Getenv("LIMIT_REQUESTS_PER_SECOND") != "" {
+		var err error
+		requestsPerSecond, err = strconv.ParseFloat(os.Getenv("LIMIT_REQUESTS_PER_SECOND"), 64)
+		if err != nil || requestsPerSecond <= 0 {
+			return nil, fmt.Errorf("invalid LIMIT_REQUESTS_PER_SECOND env %q, expected a positive number", os.Getenv("LIMIT_REQUESTS_PER_SECOND"))
+		}
+	}
+	if len(values) == 0 && requestsPerSecond == 0 {
+		return nil, nil
+	}
+	return &reverseproxy.Limits{
+		MaxConcurrentRequests: int(values["LIMIT_MAX_CONCURRENT_REQUESTS"]),
+		RequestsPerSecond:     requestsPerSecond,
+		BytesPerSecond:        values["LIMIT_BYTES_PER_SECOND"],
+		QueueSize:             int(values["LIMIT_QUEUE_SIZE"]),
+	}, nil
+}
+
+// newBlobCache creates the blob cache if BLOB_CACHE_DIR env is set
+func newBlobCache(log *zap.SugaredLogger) (*blobcache.Cache, error) {
+	dir := os.Getenv("BLOB_CACHE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	maxSize, err := strconv.ParseInt(os.Getenv("BLOB_CACHE_MAX_SIZE"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLOB_CACHE_MAX_SIZE env: %w", err)
 	}
+	return blobcache.New(dir, maxSize, log)
 }
 
-func shutdownServer(s *server.Server) error {
-	return s.HTTPServer.Shutdown(context.Background())
+// newSpoolLimit returns the number of bytes of shared transfers spooled at the same time, 0 if SPOOL_LIMIT env isn't set
+func newSpoolLimit() (int64, error) {
+	if os.Getenv("SPOOL_LIMIT") == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(os.Getenv("SPOOL_LIMIT"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SPOOL_LIMIT env: %w", err)
+	}
+	return limit, nil
 }
 
 func newLogger(level string) (*zap.SugaredLogger, error) {
@@ -0,0 +1,252 @@
+package blobcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.uber.org/zap"
+)
+
+const (
+	blobsDir = "sha256"
+	tmpDir   = "tmp"
+)
+
+// Cache stores registry blobs on disk, addressed by their sha256 digest.
+// Least recently used blobs are evicted when the total size exceeds maxSize.
+type Cache struct {
+	dir     string
+	maxSize int64
+	log     *zap.SugaredLogger
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	// lru holds *entry values, most recently used first
+	lru *list.List
+}
+
+type entry struct {
+	hex  string
+	size int64
+}
+
+// New creates a cache in the given directory and indexes blobs left there by a previous run
+func New(dir string, maxSize int64, log *zap.SugaredLogger) (*Cache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cache size must be positive, got %d", maxSize)
+	}
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		log:     log,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+
+	// unfinished writes can't be resumed, so they are dropped
+	if err := os.RemoveAll(filepath.Join(dir, tmpDir)); err != nil {
+		return nil, err
+	}
+	for _, d := range []string{blobsDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes existing blobs, using the modification time as the last access time
+func (c *Cache) load() error {
+	files, err := os.ReadDir(filepath.Join(c.dir, blobsDir))
+	if err != nil {
+		return err
+	}
+
+	type existing struct {
+		entry
+		modTime time.Time
+	}
+	blobs := make([]existing, 0, len(files))
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		blobs = append(blobs, existing{entry: entry{hex: f.Name(), size: info.Size()}, modTime: info.ModTime()})
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].modTime.After(blobs[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range blobs {
+		c.entries[blobs[i].hex] = c.lru.PushBack(&blobs[i].entry)
+		c.size += blobs[i].size
+	}
+	c.evictLocked()
+	c.log.Infof("Loaded %d blobs (%d bytes) from cache %s", c.lru.Len(), c.size, c.dir)
+	return nil
+}
+
+// Open returns the cached blob with the given digest, the caller is responsible for closing it.
+// The file stays readable even if the blob gets evicted in the meantime.
+func (c *Cache) Open(digest string) (*os.File, bool) {
+	hexDigest, ok := parseDigest(digest)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[hexDigest]
+	if !ok {
+		return nil, false
+	}
+	path := c.blobPath(hexDigest)
+	f, err := os.Open(path)
+	if err != nil {
+		c.log.Warnf("Removing unreadable blob %s from cache: %v", digest, err)
+		c.removeLocked(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return f, true
+}
+
+// MaxSize returns the maximum size of the cache in bytes
+func (c *Cache) MaxSize() int64 {
+	return c.maxSize
+}
+
+// NewWriter starts storing a blob with the given digest.
+// The blob becomes visible only after Commit verifies its content.
+func (c *Cache) NewWriter(digest string) (*Writer, error) {
+	hexDigest, ok := parseDigest(digest)
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+	f, err := os.CreateTemp(filepath.Join(c.dir, tmpDir), hexDigest+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		cache: c,
+		hex:   hexDigest,
+		file:  f,
+		hash:  sha256.New(),
+	}, nil
+}
+
+func (c *Cache) commit(hexDigest, tmpPath string, size int64) error {
+	if size > c.maxSize {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("blob of %d bytes exceeds cache size of %d bytes", size, c.maxSize)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmpPath, c.blobPath(hexDigest)); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if element, ok := c.entries[hexDigest]; ok {
+		// the same blob was committed concurrently, the content is identical
+		c.lru.MoveToFront(element)
+		return nil
+	}
+	c.entries[hexDigest] = c.lru.PushFront(&entry{hex: hexDigest, size: size})
+	c.size += size
+	c.evictLocked()
+	return nil
+}
+
+func (c *Cache) evictLocked() {
+	for c.size > c.maxSize {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			return
+		}
+		c.log.Debugf("Evicting blob sha256:%s from cache", oldest.Value.(*entry).hex)
+		c.removeLocked(oldest)
+	}
+}
+
+func (c *Cache) removeLocked(element *list.Element) {
+	e := element.Value.(*entry)
+	c.lru.Remove(element)
+	delete(c.entries, e.hex)
+	c.size -= e.size
+	if err := os.Remove(c.blobPath(e.hex)); err != nil && !os.IsNotExist(err) {
+		c.log.Warnf("Failed to remove blob sha256:%s from cache: %v", e.hex, err)
+	}
+}
+
+func (c *Cache) blobPath(hexDigest string) string {
+	return filepath.Join(c.dir, blobsDir, hexDigest)
+}
+
+func parseDigest(digest string) (string, bool) {
+	if !distribution.IsSHA256Digest(digest) {
+		return "", false
+	}
+	return strings.TrimPrefix(digest, "sha256:"), true
+}
+
+// Writer stores a single blob in a temporary file and verifies its digest before adding it to the cache
+type Writer struct {
+	cache *Cache
+	hex   string
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	if err == nil && w.size > w.cache.maxSize {
+		err = fmt.Errorf("blob exceeds cache size of %d bytes", w.cache.maxSize)
+	}
+	return n, err
+}
+
+// Commit adds the written blob to the cache if its content matches the digest
+func (w *Writer) Commit() error {
+	tmpPath := w.file.Name()
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if got := hex.EncodeToString(w.hash.Sum(nil)); got != w.hex {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("digest mismatch: expected sha256:%s, got sha256:%s", w.hex, got)
+	}
+	return w.cache.commit(w.hex, tmpPath, w.size)
+}
+
+// Abort drops the written data
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
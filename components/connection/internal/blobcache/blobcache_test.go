@@ -0,0 +1,144 @@
+package blobcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func store(t *testing.T, c *Cache, content string) {
+	w, err := c.NewWriter(digestOf(content))
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+}
+
+func readBlob(t *testing.T, c *Cache, digest string) (string, bool) {
+	f, ok := c.Open(digest)
+	if !ok {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data), true
+}
+
+func TestNew(t *testing.T) {
+	t.Run("should fail on non positive size", func(t *testing.T) {
+		_, err := New(t.TempDir(), 0, zap.NewNop().Sugar())
+		require.Error(t, err)
+	})
+	t.Run("should index blobs left by previous run and drop unfinished writes", func(t *testing.T) {
+		dir := t.TempDir()
+		c, err := New(dir, 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		store(t, c, "layer")
+		w, err := c.NewWriter(digestOf("unfinished"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("unfin"))
+		require.NoError(t, err)
+
+		c, err = New(dir, 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		content, ok := readBlob(t, c, digestOf("layer"))
+		require.True(t, ok)
+		require.Equal(t, "layer", content)
+		tmpFiles, err := os.ReadDir(filepath.Join(dir, tmpDir))
+		require.NoError(t, err)
+		require.Empty(t, tmpFiles)
+	})
+}
+
+func TestCache(t *testing.T) {
+	t.Run("should return stored blob", func(t *testing.T) {
+		c, err := New(t.TempDir(), 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		store(t, c, "layer")
+
+		content, ok := readBlob(t, c, digestOf("layer"))
+		require.True(t, ok)
+		require.Equal(t, "layer", content)
+	})
+	t.Run("should miss unknown blob", func(t *testing.T) {
+		c, err := New(t.TempDir(), 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		_, ok := c.Open(digestOf("layer"))
+		require.False(t, ok)
+	})
+	t.Run("should reject blob with mismatching content", func(t *testing.T) {
+		c, err := New(t.TempDir(), 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w, err := c.NewWriter(digestOf("layer"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("tampered"))
+		require.NoError(t, err)
+		require.ErrorContains(t, w.Commit(), "digest mismatch")
+
+		_, ok := c.Open(digestOf("layer"))
+		require.False(t, ok)
+	})
+	t.Run("should reject unsupported digest", func(t *testing.T) {
+		c, err := New(t.TempDir(), 100, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		_, err = c.NewWriter("sha512:abc")
+		require.Error(t, err)
+	})
+	t.Run("should not store blob bigger than the cache", func(t *testing.T) {
+		c, err := New(t.TempDir(), 4, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w, err := c.NewWriter(digestOf("layer"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("layer"))
+		require.Error(t, err)
+		w.Abort()
+
+		_, ok := c.Open(digestOf("layer"))
+		require.False(t, ok)
+	})
+	t.Run("should evict least recently used blob", func(t *testing.T) {
+		c, err := New(t.TempDir(), 10, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		store(t, c, "aaaa")
+		store(t, c, "bbbb")
+		// use the older blob so the other one becomes least recently used
+		_, ok := readBlob(t, c, digestOf("aaaa"))
+		require.True(t, ok)
+		store(t, c, "cccc")
+
+		_, ok = c.Open(digestOf("bbbb"))
+		require.False(t, ok)
+		_, ok = readBlob(t, c, digestOf("aaaa"))
+		require.True(t, ok)
+		_, ok = readBlob(t, c, digestOf("cccc"))
+		require.True(t, ok)
+	})
+	t.Run("should count the same blob committed twice only once", func(t *testing.T) {
+		c, err := New(t.TempDir(), 10, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		store(t, c, "aaaa")
+		store(t, c, "aaaa")
+
+		require.Equal(t, int64(4), c.size)
+	})
+}
@@ -0,0 +1,116 @@
+package distribution
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RouteClass describes which Docker Registry HTTP API v2 endpoint a request targets
+type RouteClass string
+
+const (
+	RouteBase       RouteClass = "base"
+	RouteCatalog    RouteClass = "catalog"
+	RouteTags       RouteClass = "tags"
+	RouteManifest   RouteClass = "manifest"
+	RouteBlob       RouteClass = "blob"
+	RouteBlobUpload RouteClass = "blob_upload"
+	RouteReferrers  RouteClass = "referrers"
+	// RouteToken is a request to the token endpoint of the registry's authorization server
+	RouteToken RouteClass = "token"
+	RouteOther RouteClass = "other"
+)
+
+var sha256DigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// repositoryNameRegexp matches repository names
+// see https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pulling-manifests
+var repositoryNameRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+// Route is a parsed registry API request path
+type Route struct {
+	Class RouteClass
+	// Repository is the name of the repository, e.g. "team/app"
+	Repository string
+	// Reference is a tag or a digest of a manifest, a digest of a blob or a subject or an upload ID
+	Reference string
+}
+
+// ParsePath classifies the given request path
+// example paths: /v2/, /v2/team/app/manifests/latest, /v2/team/app/blobs/sha256:abc..., /v2/team/app/referrers/sha256:abc..., /v2/_catalog
+func ParsePath(path string) Route {
+	if path == "/v2" || path == "/v2/" {
+		return Route{Class: RouteBase}
+	}
+	if !strings.HasPrefix(path, "/v2/") {
+		return Route{Class: RouteOther}
+	}
+	if path == "/v2/_catalog" {
+		return Route{Class: RouteCatalog}
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/v2/"), "/")
+	n := len(segments)
+
+	// /v2/<name>/blobs/uploads/ and /v2/<name>/blobs/uploads/<uuid>
+	if n >= 4 && segments[n-3] == "blobs" && segments[n-2] == "uploads" {
+		return newRoute(RouteBlobUpload, segments[:n-3], segments[n-1])
+	}
+	if n >= 3 {
+		switch {
+		case segments[n-2] == "tags" && segments[n-1] == "list":
+			return newRoute(RouteTags, segments[:n-2], "")
+		case segments[n-2] == "manifests":
+			return newRoute(RouteManifest, segments[:n-2], segments[n-1])
+		case segments[n-2] == "blobs":
+			return newRoute(RouteBlob, segments[:n-2], segments[n-1])
+		case segments[n-2] == "referrers":
+			return newRoute(RouteReferrers, segments[:n-2], segments[n-1])
+		}
+	}
+	return Route{Class: RouteOther}
+}
+
+func newRoute(class RouteClass, name []string, reference string) Route {
+	repository := strings.Join(name, "/")
+	if repository == "" {
+		return Route{Class: RouteOther}
+	}
+	return Route{
+		Class:      class,
+		Repository: repository,
+		Reference:  reference,
+	}
+}
+
+// HasSHA256Digest returns true if the reference of the route is a valid sha256 digest,
+// which means the returned content can be verified and is immutable
+func (r Route) HasSHA256Digest() bool {
+	return IsSHA256Digest(r.Reference)
+}
+
+// IsSHA256Digest returns true if the given string is a sha256 digest in its canonical form
+func IsSHA256Digest(digest string) bool {
+	return sha256DigestRegexp.MatchString(digest)
+}
+
+// IsValidRepositoryName returns true if the given name matches the repository name grammar of the OCI distribution spec,
+// so it has no empty, . or .. segments
+func IsValidRepositoryName(name string) bool {
+	return repositoryNameRegexp.MatchString(name)
+}
+
+// IsValidPath returns false if the given path of the API has . or .. segments, which could escape the repository it names,
+// or if the repository name is invalid. Paths outside of the API are valid.
+func IsValidPath(path string) bool {
+	if path != "/v2" && !strings.HasPrefix(path, "/v2/") {
+		return true
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	route := ParsePath(path)
+	return route.Repository == "" || IsValidRepositoryName(route.Repository)
+}
@@ -0,0 +1,141 @@
+package distribution
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want Route
+	}{
+		{
+			name: "base without trailing slash",
+			path: "/v2",
+			want: Route{Class: RouteBase},
+		},
+		{
+			name: "base",
+			path: "/v2/",
+			want: Route{Class: RouteBase},
+		},
+		{
+			name: "catalog",
+			path: "/v2/_catalog",
+			want: Route{Class: RouteCatalog},
+		},
+		{
+			name: "tags",
+			path: "/v2/team/app/tags/list",
+			want: Route{Class: RouteTags, Repository: "team/app"},
+		},
+		{
+			name: "manifest by tag",
+			path: "/v2/app/manifests/latest",
+			want: Route{Class: RouteManifest, Repository: "app", Reference: "latest"},
+		},
+		{
+			name: "blob",
+			path: "/v2/team/app/blobs/" + testDigest,
+			want: Route{Class: RouteBlob, Repository: "team/app", Reference: testDigest},
+		},
+		{
+			name: "repository containing blobs segment",
+			path: "/v2/team/blobs/app/blobs/" + testDigest,
+			want: Route{Class: RouteBlob, Repository: "team/blobs/app", Reference: testDigest},
+		},
+		{
+			name: "upload start",
+			path: "/v2/team/app/blobs/uploads/",
+			want: Route{Class: RouteBlobUpload, Repository: "team/app"},
+		},
+		{
+			name: "upload chunk",
+			path: "/v2/team/app/blobs/uploads/4d1c2d2e",
+			want: Route{Class: RouteBlobUpload, Repository: "team/app", Reference: "4d1c2d2e"},
+		},
+		{
+			name: "referrers",
+			path: "/v2/team/app/referrers/" + testDigest,
+			want: Route{Class: RouteReferrers, Repository: "team/app", Reference: testDigest},
+		},
+		{
+			name: "missing repository",
+			path: "/v2/manifests/latest",
+			want: Route{Class: RouteOther},
+		},
+		{
+			name: "unknown registry path",
+			path: "/v2/team/app/unknown",
+			want: Route{Class: RouteOther},
+		},
+		{
+			name: "non registry path",
+			path: "/jwt/auth",
+			want: Route{Class: RouteOther},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ParsePath(tt.path))
+		})
+	}
+}
+
+func TestRoute_HasSHA256Digest(t *testing.T) {
+	t.Run("valid digest", func(t *testing.T) {
+		require.True(t, Route{Reference: testDigest}.HasSHA256Digest())
+	})
+	t.Run("tag", func(t *testing.T) {
+		require.False(t, Route{Reference: "latest"}.HasSHA256Digest())
+	})
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		require.False(t, Route{Reference: "sha512:abcdef"}.HasSHA256Digest())
+	})
+	t.Run("uppercase hex", func(t *testing.T) {
+		require.False(t, Route{Reference: "sha256:0123456789ABCDEF0123456789abcdef0123456789abcdef0123456789abcdef"}.HasSHA256Digest())
+	})
+}
+
+func TestIsValidPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "manifest", path: "/v2/team/app-1/manifests/latest", want: true},
+		{name: "name with separators", path: "/v2/team/my_app.v1__x--y/tags/list", want: true},
+		{name: "base", path: "/v2/", want: true},
+		{name: "blob upload", path: "/v2/team/app/blobs/uploads/", want: true},
+		{name: "non registry path", path: "/jwt/auth", want: true},
+		{name: "parent segment in name", path: "/v2/allowed/../denied/manifests/latest", want: false},
+		{name: "parent segment at start", path: "/v2/../other/manifests/latest", want: false},
+		{name: "current segment", path: "/v2/team/./app/manifests/latest", want: false},
+		{name: "parent segment as reference", path: "/v2/team/app/manifests/..", want: false},
+		{name: "empty segment in name", path: "/v2/team//app/manifests/latest", want: false},
+		{name: "uppercase name", path: "/v2/Team/app/manifests/latest", want: false},
+		{name: "name ending with separator", path: "/v2/team/app-/manifests/latest", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsValidPath(tt.path))
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	WriteError(w, http.StatusForbidden, ErrorCodeDenied, "push is not allowed")
+
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"errors":[{"code":"DENIED","message":"push is not allowed"}]}`, w.Body.String())
+}
@@ -0,0 +1,41 @@
+package distribution
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorCode is an error code defined by the OCI distribution spec
+type ErrorCode string
+
+const (
+	ErrorCodeDenied          ErrorCode = "DENIED"
+	ErrorCodeNameInvalid     ErrorCode = "NAME_INVALID"
+	ErrorCodeNameUnknown     ErrorCode = "NAME_UNKNOWN"
+	ErrorCodeTooManyRequests ErrorCode = "TOOMANYREQUESTS"
+)
+
+type errorResponse struct {
+	Errors []errorDetail `json:"errors"`
+}
+
+type errorDetail struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// WriteError writes an error response understood by container runtimes
+// see https://github.com/opencontainers/distribution-spec/blob/main/spec.md#error-codes
+func WriteError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(ErrorBody(code, message))
+}
+
+// ErrorBody returns the body of an error response
+func ErrorBody(code ErrorCode, message string) []byte {
+	body, _ := json.Marshal(errorResponse{
+		Errors: []errorDetail{{Code: code, Message: message}},
+	})
+	return append(body, '\n')
+}
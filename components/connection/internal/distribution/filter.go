@@ -0,0 +1,97 @@
+package distribution
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RepositoryFilter decides which repositories can be used based on glob patterns.
+// In patterns, "*" matches any characters except "/", "**" matches any characters and "?" matches a single character except "/".
+type RepositoryFilter struct {
+	allow         []*regexp.Regexp
+	deny          []*regexp.Regexp
+	allowPatterns []string
+	denyPatterns  []string
+}
+
+// NewRepositoryFilter returns a filter allowing repositories matching any allow pattern (or all if there are none),
+// unless they match a deny pattern. It returns nil if there are no patterns at all.
+func NewRepositoryFilter(allow, deny []string) (*RepositoryFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &RepositoryFilter{allowPatterns: allow, denyPatterns: deny}
+	var err error
+	if f.allow, err = compileGlobs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = compileGlobs(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Allows returns true if the repository can be used, a nil filter allows everything
+func (f *RepositoryFilter) Allows(repository string) bool {
+	if f == nil {
+		return true
+	}
+	if matchesAny(f.deny, repository) {
+		return false
+	}
+	return len(f.allow) == 0 || matchesAny(f.allow, repository)
+}
+
+// Patterns returns glob patterns the filter was created with
+func (f *RepositoryFilter) Patterns() (allow, deny []string) {
+	if f == nil {
+		return nil, nil
+	}
+	return f.allowPatterns, f.denyPatterns
+}
+
+func matchesAny(patterns []*regexp.Regexp, repository string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		glob = strings.TrimSpace(glob)
+		if glob == "" {
+			continue
+		}
+		pattern, err := compileGlob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q: %w", glob, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	expression := strings.Builder{}
+	expression.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expression.WriteString("[^/]*")
+		case glob[i] == '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
@@ -0,0 +1,76 @@
+package distribution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      []string
+		deny       []string
+		repository string
+		want       bool
+	}{
+		{
+			name:       "should allow repository matching allow pattern",
+			allow:      []string{"team/*"},
+			repository: "team/app",
+			want:       true,
+		},
+		{
+			name:       "should not match nested repository with single star",
+			allow:      []string{"team/*"},
+			repository: "team/sub/app",
+			want:       false,
+		},
+		{
+			name:       "should match nested repository with double star",
+			allow:      []string{"team/**"},
+			repository: "team/sub/app",
+			want:       true,
+		},
+		{
+			name:       "should deny repository not matching any allow pattern",
+			allow:      []string{"team/*", "base/alpine"},
+			repository: "other/app",
+			want:       false,
+		},
+		{
+			name:       "should allow everything not denied without allow patterns",
+			deny:       []string{"internal/**"},
+			repository: "team/app",
+			want:       true,
+		},
+		{
+			name:       "should prefer deny over allow",
+			allow:      []string{"team/**"},
+			deny:       []string{"team/secret-?"},
+			repository: "team/secret-1",
+			want:       false,
+		},
+		{
+			name:       "should treat regexp characters literally",
+			allow:      []string{"team/app.v1"},
+			repository: "team/appXv1",
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewRepositoryFilter(tt.allow, tt.deny)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.want, f.Allows(tt.repository))
+		})
+	}
+
+	t.Run("should return nil filter allowing everything without patterns", func(t *testing.T) {
+		f, err := NewRepositoryFilter(nil, []string{})
+		require.NoError(t, err)
+		require.Nil(t, f)
+		require.True(t, f.Allows("anything"))
+	})
+}
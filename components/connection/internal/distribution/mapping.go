@@ -0,0 +1,128 @@
+package distribution
+
+import "strings"
+
+// PathMapping maps requests of clients to a registry serving the API under a base path, e.g. /artifactory/api/docker/repo/v2/,
+// or storing the repositories of clients under a common prefix, e.g. team/app as prod/team/app
+type PathMapping struct {
+	basePath string
+	prefix   string
+}
+
+// NewPathMapping returns the mapping for the given base path of the API and repository prefix.
+// It returns nil if both are empty, a nil mapping doesn't change anything.
+func NewPathMapping(basePath, repositoryPrefix string) *PathMapping {
+	basePath = strings.TrimSuffix(strings.TrimSuffix(basePath, "/"), "/v2")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	repositoryPrefix = strings.Trim(repositoryPrefix, "/")
+	if basePath == "" && repositoryPrefix == "" {
+		return nil
+	}
+	return &PathMapping{basePath: basePath, prefix: repositoryPrefix}
+}
+
+// BasePath returns the path the registry serves the API under, without /v2
+func (m *PathMapping) BasePath() string {
+	if m == nil {
+		return ""
+	}
+	return m.basePath
+}
+
+// RepositoryPrefix returns the prefix of repository names in the registry
+func (m *PathMapping) RepositoryPrefix() string {
+	if m == nil {
+		return ""
+	}
+	return m.prefix
+}
+
+// TargetPath returns the path of the registry for the path requested by a client, paths outside of the API stay the same.
+// False is returned for paths which could escape the base path or the prefix, see IsValidPath.
+func (m *PathMapping) TargetPath(path string) (string, bool) {
+	if m == nil || (path != "/v2" && !strings.HasPrefix(path, "/v2/")) {
+		return path, true
+	}
+	if !IsValidPath(path) {
+		return path, false
+	}
+	rest := strings.TrimPrefix(path, "/v2")
+	if m.prefix != "" && ParsePath(path).Repository != "" {
+		rest = "/" + m.prefix + rest
+	}
+	return m.basePath + "/v2" + rest, true
+}
+
+// ClientPath returns the path a client uses for the given path of the registry,
+// false is returned if the path is outside of the base path or its repository is outside of the prefix
+func (m *PathMapping) ClientPath(path string) (string, bool) {
+	if m == nil {
+		return path, true
+	}
+	rest, ok := strings.CutPrefix(path, m.basePath+"/v2")
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return path, false
+	}
+	if m.prefix != "" && ParsePath("/v2"+rest).Repository != "" {
+		if rest, ok = strings.CutPrefix(rest, "/"+m.prefix+"/"); !ok {
+			return path, false
+		}
+		rest = "/" + rest
+	}
+	return "/v2" + rest, true
+}
+
+// TargetRepository returns the name of the repository in the registry
+func (m *PathMapping) TargetRepository(name string) string {
+	if m == nil || m.prefix == "" {
+		return name
+	}
+	return m.prefix + "/" + name
+}
+
+// ClientRepository returns the name clients use for the repository of the registry,
+// false is returned if the repository is outside of the prefix
+func (m *PathMapping) ClientRepository(name string) (string, bool) {
+	if m == nil || m.prefix == "" {
+		return name, true
+	}
+	return strings.CutPrefix(name, m.prefix+"/")
+}
+
+// TargetScope returns the token scope for the registry, e.g. repository:prod/team/app:pull for repository:team/app:pull
+// see https://distribution.github.io/distribution/spec/auth/scope/
+func (m *PathMapping) TargetScope(scope string) string {
+	return m.mapScope(scope, func(name string) (string, bool) {
+		return m.TargetRepository(name), true
+	})
+}
+
+// ClientScope returns the token scope clients request for the given scope of the registry
+func (m *PathMapping) ClientScope(scope string) string {
+	return m.mapScope(scope, m.ClientRepository)
+}
+
+// mapScope maps names of repository scopes, scopes of other resources and names which can't be mapped stay the same
+func (m *PathMapping) mapScope(scope string, mapName func(string) (string, bool)) string {
+	if m == nil || m.prefix == "" {
+		return scope
+	}
+	scopes := strings.Fields(scope)
+	for i, s := range scopes {
+		resourceType, rest, ok := strings.Cut(s, ":")
+		// the type may have a class, e.g. repository(plugin)
+		if !ok || (resourceType != "repository" && !strings.HasPrefix(resourceType, "repository(")) {
+			continue
+		}
+		separator := strings.LastIndex(rest, ":")
+		if separator == -1 {
+			continue
+		}
+		if name, ok := mapName(rest[:separator]); ok {
+			scopes[i] = resourceType + ":" + name + rest[separator:]
+		}
+	}
+	return strings.Join(scopes, " ")
+}
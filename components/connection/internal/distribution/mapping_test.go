@@ -0,0 +1,83 @@
+package distribution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathMapping(t *testing.T) {
+	artifactory := NewPathMapping("/artifactory/api/docker/docker-remote/v2/", "")
+	prefixed := NewPathMapping("", "registry/prod")
+	both := NewPathMapping("base", "prod")
+
+	t.Run("should return nil without base path and prefix", func(t *testing.T) {
+		require.Nil(t, NewPathMapping("/", ""))
+	})
+
+	t.Run("should map paths to the target", func(t *testing.T) {
+		tests := []struct {
+			mapping *PathMapping
+			path    string
+			want    string
+		}{
+			{mapping: artifactory, path: "/v2/", want: "/artifactory/api/docker/docker-remote/v2/"},
+			{mapping: artifactory, path: "/v2/team/app/manifests/latest", want: "/artifactory/api/docker/docker-remote/v2/team/app/manifests/latest"},
+			{mapping: prefixed, path: "/v2/", want: "/v2/"},
+			{mapping: prefixed, path: "/v2/_catalog", want: "/v2/_catalog"},
+			{mapping: prefixed, path: "/v2/team-a/app/blobs/uploads/", want: "/v2/registry/prod/team-a/app/blobs/uploads/"},
+			{mapping: both, path: "/v2/app/tags/list", want: "/base/v2/prod/app/tags/list"},
+			{mapping: both, path: "/healthz", want: "/healthz"},
+			{mapping: nil, path: "/v2/app/tags/list", want: "/v2/app/tags/list"},
+		}
+		for _, tt := range tests {
+			got, ok := tt.mapping.TargetPath(tt.path)
+			require.True(t, ok, tt.path)
+			require.Equal(t, tt.want, got, tt.path)
+		}
+	})
+
+	t.Run("should reject paths escaping the base path and prefix", func(t *testing.T) {
+		for _, path := range []string{
+			"/v2/../../other/manifests/latest",
+			"/v2/team/../../../other/blobs/uploads/",
+			"/v2/team/app/manifests/../../../../x",
+			"/v2/./app/tags/list",
+		} {
+			_, ok := both.TargetPath(path)
+			require.False(t, ok, path)
+		}
+	})
+
+	t.Run("should map paths back to clients", func(t *testing.T) {
+		tests := []struct {
+			mapping *PathMapping
+			path    string
+			want    string
+			wantOK  bool
+		}{
+			{mapping: artifactory, path: "/artifactory/api/docker/docker-remote/v2/team/app/blobs/uploads/1", want: "/v2/team/app/blobs/uploads/1", wantOK: true},
+			{mapping: artifactory, path: "/artifactory/api/docker/other/v2/team/app/blobs/uploads/1", wantOK: false},
+			{mapping: prefixed, path: "/v2/registry/prod/team-a/app/blobs/uploads/1", want: "/v2/team-a/app/blobs/uploads/1", wantOK: true},
+			{mapping: prefixed, path: "/v2/other/app/blobs/uploads/1", wantOK: false},
+			{mapping: both, path: "/base/v2/_catalog", want: "/v2/_catalog", wantOK: true},
+			{mapping: both, path: "/basement/v2/_catalog", wantOK: false},
+		}
+		for _, tt := range tests {
+			got, ok := tt.mapping.ClientPath(tt.path)
+			require.Equal(t, tt.wantOK, ok, tt.path)
+			if tt.wantOK {
+				require.Equal(t, tt.want, got, tt.path)
+			}
+		}
+	})
+
+	t.Run("should map repository scopes", func(t *testing.T) {
+		require.Equal(t, "repository:registry/prod/team-a/app:pull,push registry:catalog:*",
+			prefixed.TargetScope("repository:team-a/app:pull,push registry:catalog:*"))
+		require.Equal(t, "repository(plugin):registry/prod/app:pull", prefixed.TargetScope("repository(plugin):app:pull"))
+		require.Equal(t, "repository:team-a/app:pull repository:other/app:pull",
+			prefixed.ClientScope("repository:registry/prod/team-a/app:pull repository:other/app:pull"))
+		require.Equal(t, "repository:team-a/app:pull", artifactory.TargetScope("repository:team-a/app:pull"))
+	})
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "registry_proxy_connection"
+
+// Registry holds all metrics of the connection, it's exposed by the probes server
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of requests handled by the proxy, partitioned by method, status code and route class.",
+	}, []string{"method", "code", "route"})
+
+	inFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of requests currently handled by the proxy.",
+	})
+
+	responseBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_bytes_total",
+		Help:      "Number of response body bytes sent to clients, partitioned by route class.",
+	}, []string{"route"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time until the response headers are received from the target registry through the Connectivity Proxy, partitioned by method, status code and route class.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"method", "code", "route"})
+
+	deniedRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "denied_requests_total",
+		Help:      "Number of requests rejected by the proxy policy, partitioned by method and reason.",
+	}, []string{"method", "reason"})
+
+	connectivityProxyErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connectivity_proxy_errors_total",
+		Help:      "Number of requests which couldn't be sent through the Connectivity Proxy, partitioned by route class.",
+	}, []string{"route"})
+
+	queuedRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queued_requests",
+		Help:      "Number of requests waiting for the rate and concurrency limits of the connection.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		inFlightRequests,
+		responseBytesTotal,
+		upstreamDuration,
+		deniedRequestsTotal,
+		connectivityProxyErrorsTotal,
+		queuedRequests,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveUpstream records a round trip to the target registry, resp is nil if the request failed
+func ObserveUpstream(method string, route distribution.RouteClass, start time.Time, resp *http.Response, err error) {
+	if err != nil {
+		connectivityProxyErrorsTotal.WithLabelValues(string(route)).Inc()
+		return
+	}
+	upstreamDuration.WithLabelValues(methodLabel(method), strconv.Itoa(resp.StatusCode), string(route)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDenied records a request rejected by the proxy policy
+func ObserveDenied(method, reason string) {
+	deniedRequestsTotal.WithLabelValues(methodLabel(method), reason).Inc()
+}
+
+// ObserveQueued records a request starting or, with a negative delta, stopping to wait for the limits
+func ObserveQueued(delta float64) {
+	queuedRequests.Add(delta)
+}
+
+// InstrumentHandler counts requests and response bytes of the given handler, classify returns the route class of a request
+func InstrumentHandler(next http.Handler, classify func(*http.Request) distribution.RouteClass) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlightRequests.Inc()
+		defer inFlightRequests.Dec()
+
+		route := string(classify(r))
+		rw := server.NewResponseWriter(w)
+		defer func() {
+			// aborted responses are counted too, the panic is handled by the server
+			requestsTotal.WithLabelValues(methodLabel(r.Method), strconv.Itoa(rw.Status), route).Inc()
+			responseBytesTotal.WithLabelValues(route).Add(float64(rw.Written))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// methodLabel limits the method label to the known methods to keep the cardinality bounded
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func classifyPath(r *http.Request) distribution.RouteClass {
+	return distribution.ParsePath(r.URL.Path).Class
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	t.Run("should count requests and response bytes by route class", func(t *testing.T) {
+		requests := requestsTotal.WithLabelValues("GET", "404", "manifest")
+		bytes := responseBytesTotal.WithLabelValues("manifest")
+		requestsBefore, bytesBefore := testutil.ToFloat64(requests), testutil.ToFloat64(bytes)
+
+		handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, float64(1), testutil.ToFloat64(inFlightRequests))
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+		}), classifyPath)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/latest", nil))
+
+		require.Equal(t, requestsBefore+1, testutil.ToFloat64(requests))
+		require.Equal(t, bytesBefore+9, testutil.ToFloat64(bytes))
+		require.Equal(t, float64(0), testutil.ToFloat64(inFlightRequests))
+	})
+
+	t.Run("should count implicit 200 status", func(t *testing.T) {
+		requests := requestsTotal.WithLabelValues("HEAD", "200", "blob")
+		before := testutil.ToFloat64(requests)
+
+		handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), classifyPath)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "/v2/app/blobs/sha256:abc", nil))
+
+		require.Equal(t, before+1, testutil.ToFloat64(requests))
+	})
+
+	t.Run("should keep the response writer flushable", func(t *testing.T) {
+		handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, http.NewResponseController(w).Flush())
+		}), classifyPath)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/", nil))
+
+		require.True(t, w.Flushed)
+	})
+}
+
+func TestObserveUpstream(t *testing.T) {
+	t.Run("should count connectivity proxy errors", func(t *testing.T) {
+		errorsCounter := connectivityProxyErrorsTotal.WithLabelValues("blob")
+		before := testutil.ToFloat64(errorsCounter)
+
+		ObserveUpstream(http.MethodGet, distribution.RouteBlob, time.Now(), nil, errors.New("connection refused"))
+
+		require.Equal(t, before+1, testutil.ToFloat64(errorsCounter))
+	})
+
+	t.Run("should observe upstream latency", func(t *testing.T) {
+		before := testutil.CollectAndCount(upstreamDuration)
+
+		ObserveUpstream(http.MethodGet, distribution.RouteCatalog, time.Now(), &http.Response{StatusCode: http.StatusOK}, nil)
+
+		require.Equal(t, before+1, testutil.CollectAndCount(upstreamDuration))
+	})
+}
+
+func TestMethodLabel(t *testing.T) {
+	require.Equal(t, "GET", methodLabel(http.MethodGet))
+	require.Equal(t, "OTHER", methodLabel("PROPFIND"))
+}
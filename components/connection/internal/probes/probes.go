@@ -1,10 +1,16 @@
 package probes
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/kyma-project/registry-proxy/components/connection/internal/metrics"
 	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
 
 	"go.uber.org/zap"
@@ -14,62 +20,272 @@ func healthz(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// getReadyz checks if we can access the registry proxy connection URL and returns its status code (or 503 if it's unreachable)
-func getReadyz(registryProxyConnection string, log *zap.SugaredLogger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// don't follow redirects
-		c := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		}}
-		resp, err := c.Get(registryProxyConnection)
+// UpstreamState classifies how the upstream answered the readiness probe
+type UpstreamState string
+
+const (
+	// StateHealthy means the upstream answered the probe
+	StateHealthy UpstreamState = "Healthy"
+	// StateAuthRequired means the registry works, but clients have to log in
+	StateAuthRequired UpstreamState = "AuthRequired"
+	// StateAuthFailed means the registry rejected credentials of the connection
+	StateAuthFailed UpstreamState = "AuthFailed"
+	// StateTunnelDown means the upstream couldn't be reached through the connectivity proxy
+	StateTunnelDown UpstreamState = "TunnelDown"
+	// StateTargetMissing means the target host doesn't serve the registry API, e.g. the host or the location ID is wrong
+	StateTargetMissing UpstreamState = "TargetMissing"
+)
+
+// probeTimeout is below the 2s timeout of the readiness probe of the Deployment,
+// so an unreachable upstream is reported with its state instead of a timed out probe
+const probeTimeout = 1500 * time.Millisecond
 
+// probeResult is the outcome of a single readiness probe
+type probeResult struct {
+	State      UpstreamState
+	Message    string
+	StatusCode int
+}
+
+// ready returns true if clients can pull images through the connection
+func (r probeResult) ready() bool {
+	return r.State == StateHealthy || r.State == StateAuthRequired
+}
+
+// probeUpstream sends GET /v2/ (or GET / if the upstream isn't a registry) with the probe of the upstream
+// or through the registry proxy connection, and classifies the response
+func probeUpstream(registryProxyConnection string, upstream *server.Upstream, log *zap.SugaredLogger) probeResult {
+	var statusCode int
+	var err error
+	if upstream != nil && upstream.Probe != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		statusCode, err = upstream.Probe(ctx)
 		if err != nil {
-			log.Warnf("couldn't reach registry proxy connection at %s: %v", registryProxyConnection, err)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = fmt.Fprintf(w, "couldn't reach registry proxy connection at %s: %v", registryProxyConnection, err)
-			return
+			log.Warnf("couldn't reach upstream of registry proxy connection: %v", err)
+			return probeResult{State: StateTunnelDown, Message: fmt.Sprintf("couldn't reach upstream of registry proxy connection: %v", err)}
+		}
+		log.Debugf("upstream of registry proxy connection returned status code %d", statusCode)
+	} else {
+		statusCode, err = probeConnection(registryProxyConnection, upstream, log)
+		if err != nil {
+			log.Warn(err)
+			return probeResult{State: StateTunnelDown, Message: err.Error()}
 		}
+	}
 
-		defer func() {
-			errClose := resp.Body.Close()
-			if errClose != nil {
-				log.Infof("error closing body of response from to %s: %v", registryProxyConnection, errClose)
-			}
-		}()
+	result := classify(statusCode, upstream)
+	if !result.ready() {
+		log.Warnf("registry proxy connection is not ready: %s", result.Message)
+	}
+	return result
+}
 
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Warnf("couldn't read response body from registry proxy connection at %s: %v", registryProxyConnection, err)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = fmt.Fprintf(w, "couldn't read response body from registry proxy connection at %s: %v", registryProxyConnection, err)
-			return
+// probeConnection sends the probe through the listener of the registry proxy connection and returns the status code
+func probeConnection(registryProxyConnection string, upstream *server.Upstream, log *zap.SugaredLogger) (int, error) {
+	probeURL := registryProxyConnection
+	if upstream != nil && upstream.Registry {
+		probeURL = registryProxyConnection + "/v2/"
+	}
+	// don't follow redirects
+	c := &http.Client{
+		Timeout: probeTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := c.Get(probeURL)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't reach registry proxy connection at %s: %w", probeURL, err)
+	}
+
+	defer func() {
+		errClose := resp.Body.Close()
+		if errClose != nil {
+			log.Infof("error closing body of response from to %s: %v", probeURL, errClose)
 		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read response body from registry proxy connection at %s: %w", probeURL, err)
+	}
+
+	log.Debugf("registry proxy connection at %s returned status code %d", probeURL, resp.StatusCode)
+	if string(respBody) != "" && resp.StatusCode != http.StatusOK {
+		log.Debugf("registry proxy connection at %s returned body: %s", probeURL, string(respBody))
+	}
+	return resp.StatusCode, nil
+}
 
-		log.Debugf("registry proxy connection at %s returned status code %d", registryProxyConnection, resp.StatusCode)
-		if string(respBody) != "" && resp.StatusCode != http.StatusOK {
-			log.Debugf("registry proxy connection at %s returned body: %s", registryProxyConnection, string(respBody))
+// classify maps the status code of the probe response to the state of the upstream
+func classify(statusCode int, upstream *server.Upstream) probeResult {
+	result := probeResult{StatusCode: statusCode}
+	if upstream == nil || !upstream.Registry {
+		// we only want to check if the target system is responsive, we don't care if the response is non 200, as long as server works
+		if statusCode < http.StatusInternalServerError {
+			result.State, result.Message = StateHealthy, "target system is reachable"
+		} else {
+			result.State, result.Message = StateTunnelDown, fmt.Sprintf("target system returned status code %d", statusCode)
 		}
+		return result
+	}
 
-		// we only want to check if the target system is responsice, we don't care if the response is non 200, as long as server works
-		if resp.StatusCode < http.StatusInternalServerError {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		result.State, result.Message = StateHealthy, "target registry is reachable"
+	case statusCode == http.StatusUnauthorized && !upstream.InjectsCredentials:
+		result.State, result.Message = StateAuthRequired, "target registry is reachable, clients have to log in"
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		result.State, result.Message = StateAuthFailed, fmt.Sprintf("target registry rejected credentials with status code %d", statusCode)
+	case statusCode == http.StatusNotFound:
+		result.State, result.Message = StateTargetMissing, "target host doesn't serve the registry API at /v2/, check the target host and the location ID"
+	case statusCode >= http.StatusInternalServerError:
+		result.State, result.Message = StateTunnelDown, fmt.Sprintf("target registry couldn't be reached, status code %d", statusCode)
+	default:
+		result.State, result.Message = StateHealthy, fmt.Sprintf("target registry is reachable, status code %d", statusCode)
+	}
+	return result
+}
+
+// prober probes the upstream and remembers the last result,
+// so the status endpoint reports it without sending more requests through the tunnel
+type prober struct {
+	registryProxyConnection string
+	upstream                *server.Upstream
+	log                     *zap.SugaredLogger
+
+	mu   sync.Mutex
+	last *probeResult
+}
+
+func newProber(registryProxyConnection string, upstream *server.Upstream, log *zap.SugaredLogger) *prober {
+	return &prober{registryProxyConnection: registryProxyConnection, upstream: upstream, log: log}
+}
+
+// probe probes the upstream and remembers the result
+func (p *prober) probe() probeResult {
+	result := probeUpstream(p.registryProxyConnection, p.upstream, p.log)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = &result
+	return result
+}
+
+// lastResult returns the result of the last probe, the upstream is only probed if it never was
+func (p *prober) lastResult() probeResult {
+	p.mu.Lock()
+	last := p.last
+	p.mu.Unlock()
+	if last == nil {
+		return p.probe()
+	}
+	return *last
+}
+
+// getReadyz probes the upstream through the registry proxy connection and returns 200 if it can be used,
+// the status code of the proxy if it failed with 5XX or 503 otherwise
+func getReadyz(p *prober) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := p.probe()
+		switch {
+		case result.ready():
 			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(resp.StatusCode)
+		case result.StatusCode >= http.StatusInternalServerError:
+			w.WriteHeader(result.StatusCode)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = fmt.Fprintf(w, "%s: %s", result.State, result.Message)
+	}
+}
+
+// status is the diagnostic report of the connection
+type status struct {
+	Ready       bool          `json:"ready"`
+	State       UpstreamState `json:"state"`
+	Message     string        `json:"message"`
+	LastContact *time.Time    `json:"lastContact,omitempty"`
+	// LocationID is the location ID of the Cloud Connector currently used
+	LocationID string `json:"locationID,omitempty"`
+	// CredentialsDigest identifies the content of the authorization Secret the connection uses
+	CredentialsDigest string `json:"credentialsDigest,omitempty"`
+	Config            any    `json:"config,omitempty"`
+}
+
+// getStatus returns the result of the last readiness probe with the redacted configuration as JSON
+func getStatus(p *prober, reverseProxy *server.Server, log *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := p.lastResult()
+		s := status{Ready: result.ready(), State: result.State, Message: result.Message}
+		if err := serverNotReady(reverseProxy); err != nil {
+			s.Ready, s.Message = false, err.Error()
+		}
+		if upstream := reverseProxy.Upstream; upstream != nil {
+			if upstream.LastContact != nil {
+				if lastContact := upstream.LastContact(); !lastContact.IsZero() {
+					s.LastContact = &lastContact
+				}
+			}
+			if upstream.LocationID != nil {
+				s.LocationID = upstream.LocationID()
+			}
+			if upstream.Config != nil {
+				s.Config = upstream.Config()
+			}
+			if upstream.CredentialsDigest != nil {
+				s.CredentialsDigest = upstream.CredentialsDigest()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			log.Warnf("couldn't write status: %v", err)
+		}
+	}
+}
+
+// serverNotReady returns why the server can't handle requests, nil if it can
+func serverNotReady(reverseProxy *server.Server) error {
+	if reverseProxy.Draining() {
+		return errors.New("registry proxy connection is shutting down")
+	}
+	if reverseProxy.Readiness != nil {
+		if err := reverseProxy.Readiness(); err != nil {
+			return fmt.Errorf("registry proxy connection is not ready: %w", err)
+		}
+	}
+	return nil
+}
+
+// serverReady reports the server as not ready once it's going to shut down, so it's removed from endpoints
+// before its listener closes, or while the server itself reports it can't handle requests
+func serverReady(reverseProxy *server.Server, readyz http.HandlerFunc, log *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := serverNotReady(reverseProxy); err != nil {
+			log.Warn(err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprint(w, err)
+			return
 		}
+		readyz(w, r)
 	}
 }
 
-func newProbesMuxer(reverseProxyURL string, log *zap.SugaredLogger) *http.ServeMux {
+func newProbesMuxer(reverseProxy *server.Server, log *zap.SugaredLogger) *http.ServeMux {
 	muxer := http.NewServeMux()
 
+	registryProxyConnection := fmt.Sprintf("%s%s", "http://localhost", reverseProxy.HTTPServer.Addr)
 	muxer.HandleFunc("/healthz", healthz)
-	muxer.HandleFunc("/readyz", getReadyz(fmt.Sprintf("%s%s", "http://localhost", reverseProxyURL), log))
+	p := newProber(registryProxyConnection, reverseProxy.Upstream, log)
+	muxer.HandleFunc("/readyz", serverReady(reverseProxy, getReadyz(p), log))
+	muxer.HandleFunc("/status", getStatus(p, reverseProxy, log))
+	muxer.Handle("/metrics", metrics.Handler())
 	return muxer
 }
 
-// New creates a new probes server
-func New(probesURL, reverseProxyURL string, log *zap.SugaredLogger) *server.Server {
-	muxer := newProbesMuxer(reverseProxyURL, log)
+// New creates a new probes server of the given reverse proxy server
+func New(probesURL string, reverseProxy *server.Server, log *zap.SugaredLogger) *server.Server {
+	muxer := newProbesMuxer(reverseProxy, log)
 	httpServer := http.Server{
 		Addr:    probesURL,
 		Handler: muxer,
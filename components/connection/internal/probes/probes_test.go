@@ -1,23 +1,45 @@
 package probes
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
+func testServer(addr string) *server.Server {
+	return &server.Server{HTTPServer: &http.Server{Addr: addr}, Log: zap.NewNop().Sugar()}
+}
+
 func TestNewProbesMuxer(t *testing.T) {
 	t.Run("should return muxer with healthz and readyz endpoints", func(t *testing.T) {
 		log := zap.NewNop().Sugar()
 
-		muxer := newProbesMuxer(":1234", log)
+		muxer := newProbesMuxer(testServer(":1234"), log)
 		require.NotNil(t, muxer)
 	})
 }
 
+func TestMetrics(t *testing.T) {
+	t.Run("should expose connection metrics", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		w := httptest.NewRecorder()
+
+		newProbesMuxer(testServer(":1234"), zap.NewNop().Sugar()).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		require.Contains(t, w.Body.String(), "registry_proxy_connection_in_flight_requests")
+	})
+}
+
 func TestHealthz(t *testing.T) {
 	t.Run("should return 200", func(t *testing.T) {
 		r := httptest.NewRequest("GET", "/healthz", nil)
@@ -45,7 +67,7 @@ func TestReadyz(t *testing.T) {
 		r := httptest.NewRequest("GET", "/readyz", nil)
 		w := httptest.NewRecorder()
 
-		getReadyz(testServer.URL, log)(w, r)
+		getReadyz(newProber(testServer.URL, nil, log))(w, r)
 		require.Equal(t, http.StatusOK, w.Result().StatusCode)
 	})
 
@@ -55,7 +77,7 @@ func TestReadyz(t *testing.T) {
 		r := httptest.NewRequest("GET", "/readyz", nil)
 		w := httptest.NewRecorder()
 
-		getReadyz(testServer.URL, log)(w, r)
+		getReadyz(newProber(testServer.URL, nil, log))(w, r)
 		require.Equal(t, http.StatusOK, w.Result().StatusCode)
 	})
 
@@ -65,7 +87,7 @@ func TestReadyz(t *testing.T) {
 		r := httptest.NewRequest("GET", "/readyz", nil)
 		w := httptest.NewRecorder()
 
-		getReadyz(testServer.URL, log)(w, r)
+		getReadyz(newProber(testServer.URL, nil, log))(w, r)
 		require.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
 	})
 
@@ -74,15 +96,208 @@ func TestReadyz(t *testing.T) {
 		r := httptest.NewRequest("GET", "/readyz", nil)
 		w := httptest.NewRecorder()
 
-		getReadyz(":0", log)(w, r)
+		getReadyz(newProber(":0", nil, log))(w, r)
 		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
 	})
 }
 
+func TestReadyzRegistry(t *testing.T) {
+	tests := []struct {
+		name               string
+		statusCode         int
+		injectsCredentials bool
+		wantStatusCode     int
+		wantState          UpstreamState
+	}{
+		{name: "healthy registry", statusCode: http.StatusOK, wantStatusCode: http.StatusOK, wantState: StateHealthy},
+		{name: "clients have to log in", statusCode: http.StatusUnauthorized, wantStatusCode: http.StatusOK, wantState: StateAuthRequired},
+		{name: "credentials rejected", statusCode: http.StatusUnauthorized, injectsCredentials: true, wantStatusCode: http.StatusServiceUnavailable, wantState: StateAuthFailed},
+		{name: "access denied", statusCode: http.StatusForbidden, wantStatusCode: http.StatusServiceUnavailable, wantState: StateAuthFailed},
+		{name: "no registry API", statusCode: http.StatusNotFound, wantStatusCode: http.StatusServiceUnavailable, wantState: StateTargetMissing},
+		{name: "tunnel down", statusCode: http.StatusBadGateway, wantStatusCode: http.StatusBadGateway, wantState: StateTunnelDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer target.Close()
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			getReadyz(newProber(target.URL, &server.Upstream{Registry: true, InjectsCredentials: tt.injectsCredentials}, zap.NewNop().Sugar()))(w, r)
+			require.Equal(t, "/v2/", path)
+			require.Equal(t, tt.wantStatusCode, w.Result().StatusCode)
+			require.Contains(t, w.Body.String(), string(tt.wantState))
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("should return classification, last contact and config", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer target.Close()
+		lastContact := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		reverseProxy := testServer(":1234")
+		reverseProxy.Upstream = &server.Upstream{
+			Registry:          true,
+			LastContact:       func() time.Time { return lastContact },
+			LocationID:        func() string { return "backup" },
+			Config:            func() any { return map[string]string{"targetHost": "registry.example"} },
+			CredentialsDigest: func() string { return "abc" },
+		}
+		r := httptest.NewRequest("GET", "/status", nil)
+		w := httptest.NewRecorder()
+
+		getStatus(newProber(target.URL, reverseProxy.Upstream, zap.NewNop().Sugar()), reverseProxy, zap.NewNop().Sugar())(w, r)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		require.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+		require.JSONEq(t, `{
+			"ready": true,
+			"state": "AuthRequired",
+			"message": "target registry is reachable, clients have to log in",
+			"lastContact": "2026-01-02T03:04:05Z",
+			"locationID": "backup",
+			"credentialsDigest": "abc",
+			"config": {"targetHost": "registry.example"}
+		}`, w.Body.String())
+	})
+
+	t.Run("should report not ready server", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(readyzHandleSuccess))
+		defer target.Close()
+		reverseProxy := testServer(":1234")
+		reverseProxy.Drain()
+		r := httptest.NewRequest("GET", "/status", nil)
+		w := httptest.NewRecorder()
+
+		getStatus(newProber(target.URL, reverseProxy.Upstream, zap.NewNop().Sugar()), reverseProxy, zap.NewNop().Sugar())(w, r)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		require.JSONEq(t, `{
+			"ready": false,
+			"state": "Healthy",
+			"message": "registry proxy connection is shutting down"
+		}`, w.Body.String())
+	})
+}
+
+func TestStatusLastProbe(t *testing.T) {
+	t.Run("should report the last readiness probe without probing again", func(t *testing.T) {
+		requests := atomic.Int32{}
+		statusCode := atomic.Int32{}
+		statusCode.Store(http.StatusOK)
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(int(statusCode.Load()))
+		}))
+		defer target.Close()
+		reverseProxy := testServer(":1234")
+		reverseProxy.Upstream = &server.Upstream{Registry: true}
+		p := newProber(target.URL, reverseProxy.Upstream, zap.NewNop().Sugar())
+
+		getReadyz(p)(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+		statusCode.Store(http.StatusBadGateway)
+		w := httptest.NewRecorder()
+		getStatus(p, reverseProxy, zap.NewNop().Sugar())(w, httptest.NewRequest("GET", "/status", nil))
+
+		require.Equal(t, int32(1), requests.Load())
+		require.Contains(t, w.Body.String(), `"state":"Healthy"`)
+	})
+
+	t.Run("should use the probe of the upstream", func(t *testing.T) {
+		upstream := &server.Upstream{
+			Registry:           true,
+			InjectsCredentials: true,
+			Probe: func(ctx context.Context) (int, error) {
+				return http.StatusUnauthorized, nil
+			},
+		}
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+
+		getReadyz(newProber(":0", upstream, zap.NewNop().Sugar()))(w, r)
+		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+		require.Contains(t, w.Body.String(), string(StateAuthFailed))
+	})
+
+	t.Run("should time out probes of the upstream", func(t *testing.T) {
+		upstream := &server.Upstream{
+			Registry: true,
+			Probe: func(ctx context.Context) (int, error) {
+				<-ctx.Done()
+				return 0, ctx.Err()
+			},
+		}
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+
+		start := time.Now()
+		getReadyz(newProber(":0", upstream, zap.NewNop().Sugar()))(w, r)
+		require.Less(t, time.Since(start), 2*time.Second)
+		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+		require.Contains(t, w.Body.String(), string(StateTunnelDown))
+	})
+
+	t.Run("should time out probes before the readiness probe does", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer target.Close()
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+
+		start := time.Now()
+		getReadyz(newProber(target.URL, nil, zap.NewNop().Sugar()))(w, r)
+		require.Less(t, time.Since(start), 2*time.Second)
+		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+		require.Contains(t, w.Body.String(), string(StateTunnelDown))
+	})
+}
+
 func TestNew(t *testing.T) {
 	t.Run("should return server with probes", func(t *testing.T) {
 		log := zap.NewNop().Sugar()
-		server := New(":1234", ":5678", log)
-		require.NotNil(t, server)
+		probesServer := New(":1234", testServer(":5678"), log)
+		require.NotNil(t, probesServer)
+	})
+}
+
+func TestServerReady(t *testing.T) {
+	t.Run("should return readiness of the server", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+
+		serverReady(testServer(":1234"), readyzHandleSuccess, zap.NewNop().Sugar())(w, r)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+
+	t.Run("should return 503 when server is draining", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+		reverseProxy := testServer(":1234")
+		reverseProxy.Drain()
+
+		serverReady(reverseProxy, readyzHandleSuccess, zap.NewNop().Sugar())(w, r)
+		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	})
+
+	t.Run("should return 503 when server reports it's not ready", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+		reverseProxy := testServer(":1234")
+		reverseProxy.Readiness = func() error {
+			return errors.New("circuit breaker is open")
+		}
+
+		serverReady(reverseProxy, readyzHandleSuccess, zap.NewNop().Sugar())(w, r)
+		require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+		require.Contains(t, w.Body.String(), "circuit breaker is open")
 	})
 }
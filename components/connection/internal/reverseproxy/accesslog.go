@@ -0,0 +1,97 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// AccessLog configures the access log, one entry is written per request handled by the proxy
+type AccessLog struct {
+	// RateLimit is the maximum number of entries written per second, entries above it are dropped and counted
+	RateLimit float64
+}
+
+type accessRecordKey struct{}
+
+// accessRecord collects details of a request which are known only deeper in the handler chain
+type accessRecord struct {
+	upstreamLatency atomic.Int64
+}
+
+// addUpstreamLatency adds the time of a single round trip, retries and redirects of one request are summed up
+func (rec *accessRecord) addUpstreamLatency(d time.Duration) {
+	rec.upstreamLatency.Add(int64(d))
+}
+
+func accessRecordFrom(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(accessRecordKey{}).(*accessRecord)
+	return rec
+}
+
+// accessLogger writes a JSON line with image-level fields for every request
+type accessLogger struct {
+	log        *zap.Logger
+	limiter    *rate.Limiter
+	dropped    atomic.Int64
+	locations  *locations
+	routeClass func(*http.Request) distribution.RouteClass
+	// secretHeaders are redacted in entries
+	secretHeaders []string
+}
+
+func newAccessLogger(cfg AccessLog, locations *locations, routeClass func(*http.Request) distribution.RouteClass, secretHeaders []string, log *zap.SugaredLogger) *accessLogger {
+	burst := max(int(cfg.RateLimit), 1)
+	return &accessLogger{
+		log:           log.Desugar().Named("access"),
+		limiter:       rate.NewLimiter(rate.Limit(cfg.RateLimit), burst),
+		locations:     locations,
+		routeClass:    routeClass,
+		secretHeaders: secretHeaders,
+	}
+}
+
+// wrap returns a handler writing an access log entry after the given handler returns
+func (al *accessLogger) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecord{}
+		// the route is taken before the handler rewrites the request
+		route := distribution.ParsePath(r.URL.Path)
+		class := al.routeClass(r)
+		headers := getRedactedHeaders(r.Header, al.secretHeaders)
+
+		rw := server.NewResponseWriter(w)
+		defer func() {
+			if !al.limiter.Allow() {
+				al.dropped.Add(1)
+				return
+			}
+			al.log.Info("access",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("route", string(class)),
+				zap.String("repository", route.Repository),
+				zap.String("reference", route.Reference),
+				zap.Int("status", rw.Status),
+				zap.Int64("bytes", rw.Written),
+				zap.Duration("duration", time.Since(start)),
+				zap.Duration("upstreamLatency", time.Duration(rec.upstreamLatency.Load())),
+				zap.String("clientAddress", r.RemoteAddr),
+				zap.String("userAgent", r.UserAgent()),
+				zap.String("locationID", al.locations.activeID()),
+				zap.Any("headers", headers),
+				// number of entries dropped by the rate limit since the last written one
+				zap.Int64("dropped", al.dropped.Swap(0)),
+			)
+		}()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, rec)))
+	})
+}
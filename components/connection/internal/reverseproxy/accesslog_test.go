@@ -0,0 +1,99 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/time/rate"
+)
+
+func newAccessLoggingProxy(t *testing.T, registryURL string, rateLimit float64) (http.Handler, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	proxy, err := New(Config{
+		ConnectivityProxyURL: registryURL,
+		TargetHost:           "target",
+		LocationID:           "on-prem",
+		AccessLog:            &AccessLog{RateLimit: rateLimit},
+	}, zap.New(core).Sugar())
+	require.NoError(t, err)
+	return proxy.HTTPServer.Handler, logs
+}
+
+func accessEntries(logs *observer.ObservedLogs) []observer.LoggedEntry {
+	return logs.FilterLoggerName("access").All()
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Run("should write an entry with image-level fields", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler, logs := newAccessLoggingProxy(t, registry.URL, 10)
+
+		w := pull(t, handler, testBlobPath(), "Bearer secret")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		entries := accessEntries(logs)
+		require.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		require.Equal(t, http.MethodGet, fields["method"])
+		require.Equal(t, "blob", fields["route"])
+		require.Equal(t, "team/app", fields["repository"])
+		require.Equal(t, testBlobPath()[len("/v2/team/app/blobs/"):], fields["reference"])
+		require.Equal(t, int64(http.StatusOK), fields["status"])
+		require.Equal(t, int64(len(testBlob)), fields["bytes"])
+		require.Equal(t, "on-prem", fields["locationID"])
+		require.NotEmpty(t, fields["clientAddress"])
+		require.Positive(t, fields["upstreamLatency"])
+		require.Positive(t, fields["duration"])
+	})
+
+	t.Run("should redact credentials of the client", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler, logs := newAccessLoggingProxy(t, registry.URL, 10)
+
+		pull(t, handler, testBlobPath(), "Bearer secret")
+
+		entries := accessEntries(logs)
+		require.Len(t, entries, 1)
+		require.Equal(t, []string{"***"}, entries[0].ContextMap()["headers"].(http.Header)["Authorization"])
+	})
+
+	t.Run("should log requests denied by the policy", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler, logs := newAccessLoggingProxy(t, registry.URL, 10)
+
+		r := httptest.NewRequest(http.MethodDelete, testBlobPath(), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		entries := accessEntries(logs)
+		require.Len(t, entries, 1)
+		require.Equal(t, int64(http.StatusForbidden), entries[0].ContextMap()["status"])
+		require.Equal(t, time.Duration(0), entries[0].ContextMap()["upstreamLatency"])
+		require.Zero(t, registry.gets.Load()+registry.heads.Load())
+	})
+
+	t.Run("should drop entries above the rate limit and count them", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		accessLog := newAccessLogger(AccessLog{RateLimit: 0.001}, newLocations(Config{}, zap.NewNop().Sugar()), (Config{}).routeClass, (Config{}).secretHeaders(), zap.New(core).Sugar())
+		handler := accessLog.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		for range 3 {
+			pull(t, handler, "/v2/team/app/manifests/latest", "")
+		}
+		require.Len(t, accessEntries(logs), 1)
+
+		accessLog.limiter.SetLimit(rate.Inf)
+		pull(t, handler, "/v2/team/app/manifests/latest", "")
+
+		entries := accessEntries(logs)
+		require.Len(t, entries, 2)
+		require.Equal(t, int64(0), entries[0].ContextMap()["dropped"])
+		require.Equal(t, int64(2), entries[1].ContextMap()["dropped"])
+	})
+}
@@ -1,133 +1,306 @@
-// Copied from:
-// https://github.com/gboddin/go-www-authenticate-parser/commit/ec0b649bb07701564d8bf002d0262a7bc4c13fe8
-
 package reverseproxy
 
 import (
-	"bytes"
-	"encoding/json"
+	"fmt"
+	"strings"
 )
 
-type wwwAuthenticateSettings struct {
-	state        func() error
-	digestBuffer *bytes.Buffer
-	buffer       string
-	currentParam string
-	quoteOpened  bool
-	AuthType     string
-	Params       map[string]string
+// Challenge is a single authentication challenge of the WWW-Authenticate header
+// see https://www.rfc-editor.org/rfc/rfc7235#section-4.1
+type Challenge struct {
+	// Scheme is the authentication scheme, e.g. "Bearer", compare it case-insensitively
+	Scheme string
+	// Token68 is set instead of Params if the challenge carries a single token68 value
+	Token68 string
+	// Params are auth-params of the challenge in the order they were sent
+	Params []ChallengeParam
 }
 
-func ParseAuthSettings(digestBuffer string) wwwAuthenticateSettings {
-	digest := wwwAuthenticateSettings{
-		digestBuffer: bytes.NewBufferString(digestBuffer),
-		buffer:       "",
-		AuthType:     "",
-		Params:       make(map[string]string),
-	}
-	digest.state = digest.ParseType
-	for {
-		if err := digest.state(); err != nil {
-			break
+// ChallengeParam is a single auth-param of a challenge
+type ChallengeParam struct {
+	Name  string
+	Value string
+}
+
+// Param returns the value of the auth-param with the given name, names are case-insensitive
+func (c Challenge) Param(name string) string {
+	for _, param := range c.Params {
+		if strings.EqualFold(param.Name, name) {
+			return param.Value
 		}
 	}
-	return digest
-}
-
-func (d *wwwAuthenticateSettings) ParseType() error {
-	currentByte, err := d.digestBuffer.ReadByte()
-	if err != nil {
-		return err
-	}
-	if currentByte != ' ' && currentByte != '\n' {
-		d.buffer += string(currentByte)
-		return nil
-	}
-	d.AuthType = d.buffer
-	d.buffer = ""
-	d.state = d.ParseParamKey
-	return nil
-}
-
-func (d *wwwAuthenticateSettings) ParseParamKey() error {
-	currentByte, err := d.digestBuffer.ReadByte()
-	if err != nil {
-		return err
-	}
-	switch currentByte {
-	case '=':
-		d.currentParam = d.buffer
-		d.buffer = ""
-		d.state = d.ParseParamValue
-		return nil
-	case ' ':
-		if len(d.buffer) > 0 {
-			d.Params[d.buffer] = "true"
-			d.currentParam = ""
-			d.buffer = ""
-			d.state = d.ParseParamKey
+	return ""
+}
+
+// SetParam replaces the value of the auth-param with the given name or adds it
+func (c *Challenge) SetParam(name, value string) {
+	for i, param := range c.Params {
+		if strings.EqualFold(param.Name, name) {
+			c.Params[i].Value = value
+			return
 		}
-		return nil
-	case ',':
-		if len(d.buffer) > 0 {
-			d.Params[d.buffer] = "true"
+	}
+	c.Params = append(c.Params, ChallengeParam{Name: name, Value: value})
+}
+
+// String serializes the challenge the way registries send it, values of auth-params are always sent as quoted-strings
+func (c Challenge) String() string {
+	b := strings.Builder{}
+	b.WriteString(c.Scheme)
+	if c.Token68 != "" {
+		b.WriteString(" ")
+		b.WriteString(c.Token68)
+		return b.String()
+	}
+	for i, param := range c.Params {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
 		}
-		d.currentParam = ""
-		d.buffer = ""
-		d.state = d.ParseParamKey
-		return nil
+		b.WriteString(param.Name)
+		b.WriteString("=")
+		b.WriteString(quote(param.Value))
 	}
-	d.buffer += string(currentByte)
-	return nil
+	return b.String()
 }
 
-func (d *wwwAuthenticateSettings) ParseParamValue() error {
-	currentByte, err := d.digestBuffer.ReadByte()
-	if err != nil {
-		return err
+// FormatChallenges serializes challenges into a single WWW-Authenticate header value
+func FormatChallenges(challenges []Challenge) string {
+	values := make([]string, 0, len(challenges))
+	for _, challenge := range challenges {
+		values = append(values, challenge.String())
 	}
-	switch currentByte {
-	case '\\':
-		nextByte, err := d.digestBuffer.ReadByte()
-		if err != nil {
-			return err
+	return strings.Join(values, ", ")
+}
+
+// FindChallenge returns the first challenge with the given scheme
+func FindChallenge(challenges []Challenge, scheme string) (Challenge, bool) {
+	for _, challenge := range challenges {
+		if strings.EqualFold(challenge.Scheme, scheme) {
+			return challenge, true
+		}
+	}
+	return Challenge{}, false
+}
+
+// ParseChallenges parses a WWW-Authenticate header value, which may contain several comma separated challenges,
+// e.g. `Basic realm="registry", Bearer realm="https://auth.example/token",service="registry"`
+func ParseChallenges(header string) ([]Challenge, error) {
+	challenges, _, err := parseChallenges(header)
+	return challenges, err
+}
+
+// paramSpan locates the value of an auth-param in the header, including quotes of a quoted-string,
+// so it can be replaced without touching the rest of the header
+type paramSpan struct {
+	name       string
+	value      string
+	start, end int
+}
+
+// parseChallenges parses the header and returns locations of values of all auth-params in order
+func parseChallenges(header string) ([]Challenge, []paramSpan, error) {
+	p := &challengeParser{input: header}
+	var challenges []Challenge
+	for {
+		p.skipListSeparators()
+		if p.done() {
+			break
 		}
-		var unquoted string
-		err = json.Unmarshal([]byte("\""+string(currentByte)+string(nextByte)+"\""), &unquoted)
+		challenge, err := p.challenge()
 		if err != nil {
-			return err
+			return challenges, p.spans, err
 		}
-		d.buffer += unquoted
-		return nil
-	case '"':
-		if d.quoteOpened {
-			d.quoteOpened = false
-			d.Params[d.currentParam] = d.buffer
-			d.currentParam = ""
-			d.buffer = ""
-			// Read until the next ','
-			_, err = d.digestBuffer.ReadString(',')
-			if err != nil {
-				return err
+		challenges = append(challenges, challenge)
+	}
+	if len(challenges) == 0 {
+		return nil, nil, fmt.Errorf("no challenge in %q", header)
+	}
+	return challenges, p.spans, nil
+}
+
+type challengeParser struct {
+	input string
+	pos   int
+	spans []paramSpan
+}
+
+func (p *challengeParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *challengeParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *challengeParser) skipSpaces() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipListSeparators skips empty list elements, which are allowed by the list syntax
+func (p *challengeParser) skipListSeparators() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == ',') {
+		p.pos++
+	}
+}
+
+func (p *challengeParser) read(allowed func(byte) bool) string {
+	start := p.pos
+	for !p.done() && allowed(p.peek()) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *challengeParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid challenge at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// challenge = auth-scheme [ 1*SP ( token68 / #auth-param ) ]
+func (p *challengeParser) challenge() (Challenge, error) {
+	c := Challenge{Scheme: p.read(isTokenChar)}
+	if c.Scheme == "" {
+		return c, p.errorf("expected auth-scheme, got %q", p.peek())
+	}
+	if p.done() || p.peek() == ',' {
+		return c, nil
+	}
+	if p.peek() != ' ' && p.peek() != '\t' {
+		return c, p.errorf("expected space after auth-scheme, got %q", p.peek())
+	}
+	p.skipSpaces()
+
+	if token68, ok := p.token68(); ok {
+		c.Token68 = token68
+		return c, nil
+	}
+
+	for {
+		start := p.pos
+		name := p.read(isTokenChar)
+		if name == "" {
+			if p.done() || p.peek() == ',' {
+				return c, nil
 			}
-			d.state = d.ParseParamKey
-			return nil
+			return c, p.errorf("expected auth-param name, got %q", p.peek())
+		}
+		p.skipSpaces()
+		if p.peek() != '=' {
+			// the token is the scheme of the next challenge
+			p.pos = start
+			return c, nil
+		}
+		p.pos++
+		p.skipSpaces()
+
+		valueStart := p.pos
+		value, err := p.paramValue()
+		if err != nil {
+			return c, err
 		}
-		if !d.quoteOpened {
-			d.quoteOpened = true
-			return nil
+		c.Params = append(c.Params, ChallengeParam{Name: name, Value: value})
+		p.spans = append(p.spans, paramSpan{name: name, value: value, start: valueStart, end: p.pos})
+
+		p.skipSpaces()
+		if p.done() {
+			return c, nil
 		}
-	case ',':
-		if !d.quoteOpened {
-			d.quoteOpened = false
-			d.Params[d.currentParam] = d.buffer
-			d.currentParam = ""
-			d.buffer = ""
-			d.state = d.ParseParamKey
-			return nil
+		if p.peek() != ',' {
+			return c, p.errorf("expected comma after auth-param %q, got %q", name, p.peek())
 		}
+		p.skipListSeparators()
 	}
+}
+
+// token68 reads the token68 value if the challenge carries one instead of auth-params
+func (p *challengeParser) token68() (string, bool) {
+	start := p.pos
+	value := p.read(isToken68Char)
+	value += p.read(func(b byte) bool { return b == '=' })
+	end := p.pos
+	p.skipSpaces()
+	if value == "" || (!p.done() && p.peek() != ',') {
+		// it's the name of an auth-param
+		p.pos = start
+		return "", false
+	}
+	return p.input[start:end], true
+}
+
+// paramValue = token / quoted-string
+func (p *challengeParser) paramValue() (string, error) {
+	if p.peek() != '"' {
+		value := p.read(isTokenChar)
+		if value == "" {
+			return "", p.errorf("expected auth-param value, got %q", p.peek())
+		}
+		return value, nil
+	}
+
+	p.pos++
+	value := strings.Builder{}
+	for !p.done() {
+		b := p.peek()
+		p.pos++
+		switch {
+		case b == '"':
+			return value.String(), nil
+		case b == '\\':
+			if p.done() || !isQuotedChar(p.peek()) {
+				return "", p.errorf("invalid quoted-pair")
+			}
+			value.WriteByte(p.peek())
+			p.pos++
+		case isQuotedChar(b):
+			value.WriteByte(b)
+		default:
+			return "", p.errorf("invalid character %q in quoted-string", b)
+		}
+	}
+	return "", p.errorf("unterminated quoted-string")
+}
+
+// isQuotedChar returns true for HTAB, SP, VCHAR and obs-text, which may appear in quoted-strings
+func isQuotedChar(b byte) bool {
+	return b == '\t' || b >= 0x20 && b != 0x7f
+}
+
+// quote returns the value as quoted-string with quotes and backslashes escaped
+func quote(value string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(value[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isTokenChar returns true for tchar, see https://www.rfc-editor.org/rfc/rfc7230#section-3.2.6
+func isTokenChar(b byte) bool {
+	if isAlphaNumeric(b) {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", b) >= 0
+}
+
+// isToken68Char returns true for characters of token68 except the trailing "="
+func isToken68Char(b byte) bool {
+	if isAlphaNumeric(b) {
+		return true
+	}
+	return strings.IndexByte("-._~+/", b) >= 0
+}
 
-	d.buffer += string(currentByte)
-	return nil
+func isAlphaNumeric(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
 }
@@ -0,0 +1,170 @@
+package reverseproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []Challenge
+		wantErr bool
+	}{
+		{
+			name:   "bearer challenge of registry",
+			header: `Bearer realm="https://auth.example/jwt/auth",service="container_registry",scope="repository:team/app:pull"`,
+			want: []Challenge{{Scheme: "Bearer", Params: []ChallengeParam{
+				{Name: "realm", Value: "https://auth.example/jwt/auth"},
+				{Name: "service", Value: "container_registry"},
+				{Name: "scope", Value: "repository:team/app:pull"},
+			}}},
+		},
+		{
+			name:   "several challenges",
+			header: `Basic realm="registry", Bearer realm="https://auth.example/token", service=registry`,
+			want: []Challenge{
+				{Scheme: "Basic", Params: []ChallengeParam{{Name: "realm", Value: "registry"}}},
+				{Scheme: "Bearer", Params: []ChallengeParam{
+					{Name: "realm", Value: "https://auth.example/token"},
+					{Name: "service", Value: "registry"},
+				}},
+			},
+		},
+		{
+			name:   "token68 and challenge without params",
+			header: `Negotiate abc123+/==, NTLM, Basic realm="x"`,
+			want: []Challenge{
+				{Scheme: "Negotiate", Token68: "abc123+/=="},
+				{Scheme: "NTLM"},
+				{Scheme: "Basic", Params: []ChallengeParam{{Name: "realm", Value: "x"}}},
+			},
+		},
+		{
+			name:   "escaped quotes and commas in quoted-string",
+			header: `Bearer realm="https://auth.example/token",error="insufficient_scope",error_description="say \"hi\", then \\ go"`,
+			want: []Challenge{{Scheme: "Bearer", Params: []ChallengeParam{
+				{Name: "realm", Value: "https://auth.example/token"},
+				{Name: "error", Value: "insufficient_scope"},
+				{Name: "error_description", Value: `say "hi", then \ go`},
+			}}},
+		},
+		{
+			name:   "whitespace around equals sign and empty list elements",
+			header: ` ,Bearer  realm = "https://auth.example/token" ,, service = "registry" , `,
+			want: []Challenge{{Scheme: "Bearer", Params: []ChallengeParam{
+				{Name: "realm", Value: "https://auth.example/token"},
+				{Name: "service", Value: "registry"},
+			}}},
+		},
+		{
+			name:    "unterminated quoted-string",
+			header:  `Bearer realm="https://auth.example/token`,
+			wantErr: true,
+		},
+		{
+			name:    "missing comma between params",
+			header:  `Bearer realm="a" service="b"`,
+			wantErr: true,
+		},
+		{
+			name:    "missing param value",
+			header:  `Bearer realm=,service="b"`,
+			wantErr: true,
+		},
+		{
+			name:    "empty header",
+			header:  ``,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChallenges(tt.header)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRewriteRealmHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{
+			name:   "should rewrite only realm host",
+			header: `Bearer realm="https://auth.example:8443/jwt/auth?a=b",service="container_registry"`,
+			want:   `Bearer realm="http://localhost:30001/jwt/auth?a=b",service="container_registry"`,
+		},
+		{
+			name:   "should rewrite realms of all challenges and keep basic realm",
+			header: `Basic realm="registry", Bearer realm="https://auth.example/token",error="say \"hi\""`,
+			want:   `Basic realm="registry", Bearer realm="http://localhost:30001/token",error="say \"hi\""`,
+		},
+		{
+			name:   "should keep other params as they were sent",
+			header: `Bearer service=registry, realm="https://auth.example/token" ,scope="repository:team/app:pull",error="insufficient_scope"`,
+			want:   `Bearer service=registry, realm="http://localhost:30001/token" ,scope="repository:team/app:pull",error="insufficient_scope"`,
+		},
+		{
+			name:   "should keep http scheme of realm",
+			header: `bearer realm="http://auth.example/token",service="registry"`,
+			want:   `bearer realm="http://localhost:30001/token",service="registry"`,
+		},
+		{
+			name:   "should keep challenge without realm",
+			header: `Bearer service="registry"`,
+			want:   `Bearer service="registry"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteRealmHost(tt.header, "localhost:30001")
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("should fail on malformed header", func(t *testing.T) {
+		_, err := rewriteRealmHost(`Bearer realm="https://auth.example`, "localhost:30001")
+
+		require.Error(t, err)
+	})
+}
+
+func FuzzParseChallenges(f *testing.F) {
+	for _, seed := range []string{
+		`Bearer realm="https://auth.example/jwt/auth",service="container_registry",scope="repository:team/app:pull"`,
+		`Basic realm="registry", Bearer realm="https://auth.example/token", service=registry`,
+		`Negotiate abc123+/==, NTLM`,
+		`Bearer error_description="say \"hi\", then \\ go"`,
+		`Bearer realm="unterminated`,
+		` , ,`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		challenges, err := ParseChallenges(header)
+		if err != nil {
+			return
+		}
+
+		// serialized challenges are parsed back to the same ones
+		formatted := FormatChallenges(challenges)
+		reparsed, err := ParseChallenges(formatted)
+		require.NoError(t, err, "formatted: %s", formatted)
+		require.Equal(t, challenges, reparsed, "formatted: %s", formatted)
+
+		_, _ = rewriteRealmHost(header, "localhost:30001")
+	})
+}
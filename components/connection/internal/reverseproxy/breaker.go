@@ -0,0 +1,132 @@
+package reverseproxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// breakerThreshold is the number of consecutive upstream failures which open the circuit
+	breakerThreshold = 5
+	// breakerCooldown is the time requests are rejected right away before the upstream is tried again
+	breakerCooldown = 30 * time.Second
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open, target registry is unreachable")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// circuitBreaker stops sending requests to the upstream after it failed repeatedly,
+// after the cooldown a single trial request decides if the circuit closes again, a nil breaker allows all requests
+type circuitBreaker struct {
+	log       *zap.SugaredLogger
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newCircuitBreaker(log *zap.SugaredLogger) *circuitBreaker {
+	return &circuitBreaker{
+		log:       log,
+		threshold: breakerThreshold,
+		cooldown:  breakerCooldown,
+		now:       time.Now,
+		state:     breakerClosed,
+	}
+}
+
+// allow returns true if a request may be sent to the upstream
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.log.Info("Circuit breaker is half-open, trying the target registry again")
+		b.state = breakerHalfOpen
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		// only the trial request is sent until its result is known
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record counts the result of a request sent to the upstream
+func (b *circuitBreaker) record(success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if b.state != breakerClosed {
+			b.log.Info("Circuit breaker closed, target registry is reachable again")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.trial = false
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.log.Warnf("Circuit breaker opened after %d consecutive failures, rejecting requests for %s", b.failures, b.cooldown)
+		b.state = breakerOpen
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
+
+// abandon gives up the trial request whose result is unknown, e.g. because the client went away
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// readiness returns an error while the circuit is open,
+// after the cooldown the connection is ready again, so the trial request can be sent
+func (b *circuitBreaker) readiness() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) < b.cooldown {
+		return fmt.Errorf("circuit breaker is open after %d consecutive upstream failures", b.failures)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package reverseproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/blobcache"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.uber.org/zap"
+)
+
+// serveCachedBlob writes the requested blob from the cache and returns true if it was found there.
+// Blobs are addressed by their digest, so the content doesn't depend on the client,
+// but access to it does - if the proxy doesn't authorize requests on its own,
+// the registry is asked with a HEAD request if the client is allowed to read the blob.
+func serveCachedBlob(p *httputil.ReverseProxy, cfg Config, w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	route := distribution.ParsePath(r.URL.Path)
+	if route.Class != distribution.RouteBlob || !route.HasSHA256Digest() {
+		return false
+	}
+
+	blob, ok := cfg.BlobCache.Open(route.Reference)
+	if !ok {
+		return false
+	}
+	defer func() {
+		_ = blob.Close()
+	}()
+
+	if !cfg.injectsCredentials() && !upstreamAllowsRead(p, r, log) {
+		return false
+	}
+
+	log.Debugf("Serving %s from cache", route.Reference)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", route.Reference)
+	w.Header().Set("Etag", `"`+route.Reference+`"`)
+	http.ServeContent(w, r, "", time.Time{}, blob)
+	return true
+}
+
+// upstreamAllowsRead sends the blob request as HEAD to the target registry and checks if it succeeds
+func upstreamAllowsRead(p *httputil.ReverseProxy, r *http.Request, log *zap.SugaredLogger) bool {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodHead
+	req.Body = nil
+	req.ContentLength = 0
+	req.RequestURI = ""
+	req.Header.Del("Range")
+	p.Director(req)
+
+	resp, err := p.Transport.RoundTrip(req)
+	if err != nil {
+		log.Warnf("couldn't verify access to cached blob %s: %v", r.URL.Path, err)
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// getCacheResponseFunc stores blobs from successful responses in the cache while they are streamed to the client
+func getCacheResponseFunc(cache *blobcache.Cache, log *zap.SugaredLogger) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.Request.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		route := distribution.ParsePath(resp.Request.URL.Path)
+		if route.Class != distribution.RouteBlob || !route.HasSHA256Digest() {
+			return nil
+		}
+		if resp.ContentLength > cache.MaxSize() {
+			return nil
+		}
+
+		writer, err := cache.NewWriter(route.Reference)
+		if err != nil {
+			log.Warnf("couldn't start caching blob %s: %v", route.Reference, err)
+			return nil
+		}
+		resp.Body = &cachingBody{
+			ReadCloser: resp.Body,
+			digest:     route.Reference,
+			writer:     writer,
+			log:        log,
+		}
+		return nil
+	}
+}
+
+// cachingBody copies the response body to the cache and commits it once the body is read completely
+type cachingBody struct {
+	io.ReadCloser
+	digest string
+	writer *blobcache.Writer
+	log    *zap.SugaredLogger
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.writer == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, writeErr := b.writer.Write(p[:n]); writeErr != nil {
+			b.log.Warnf("couldn't cache blob %s: %v", b.digest, writeErr)
+			b.writer.Abort()
+			b.writer = nil
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		if commitErr := b.writer.Commit(); commitErr != nil {
+			b.log.Warnf("couldn't cache blob %s: %v", b.digest, commitErr)
+		} else {
+			b.log.Debugf("Cached blob %s", b.digest)
+		}
+		b.writer = nil
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	if b.writer != nil {
+		// the client went away before the whole blob was transferred
+		b.writer.Abort()
+		b.writer = nil
+	}
+	return b.ReadCloser.Close()
+}
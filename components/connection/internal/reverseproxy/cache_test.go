@@ -0,0 +1,138 @@
+package reverseproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/blobcache"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testBlob = "layer content"
+
+type fakeRegistry struct {
+	*httptest.Server
+	gets  atomic.Int32
+	heads atomic.Int32
+	// authorized decides if the request may read blobs, everyone can if nil
+	authorized func(r *http.Request) bool
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	registry := &fakeRegistry{}
+	registry.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			registry.gets.Add(1)
+		case http.MethodHead:
+			registry.heads.Add(1)
+		}
+		if registry.authorized != nil && !registry.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(testBlob))
+	}))
+	t.Cleanup(registry.Close)
+	return registry
+}
+
+func testBlobPath() string {
+	sum := sha256.Sum256([]byte(testBlob))
+	return "/v2/team/app/blobs/sha256:" + hex.EncodeToString(sum[:])
+}
+
+func pull(t *testing.T, handler http.Handler, path, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func newCachingProxy(t *testing.T, registryURL, authorizationHeader string) http.Handler {
+	log := zap.NewNop().Sugar()
+	cache, err := blobcache.New(t.TempDir(), 1024, log)
+	require.NoError(t, err)
+	cfg := Config{
+		ConnectivityProxyURL: registryURL,
+		TargetHost:           "target",
+		BlobCache:            cache,
+	}
+	if authorizationHeader != "" {
+		cfg.AuthorizationHeader = func() string {
+			return authorizationHeader
+		}
+	}
+	proxy, err := New(cfg, log)
+	require.NoError(t, err)
+	return proxy.HTTPServer.Handler
+}
+
+func TestBlobCache(t *testing.T) {
+	t.Run("should serve blob from cache after first pull", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler := newCachingProxy(t, registry.URL, "Basic abc")
+
+		first := pull(t, handler, testBlobPath(), "")
+		second := pull(t, handler, testBlobPath(), "")
+
+		require.Equal(t, http.StatusOK, first.Code)
+		require.Equal(t, testBlob, first.Body.String())
+		require.Equal(t, http.StatusOK, second.Code)
+		require.Equal(t, testBlob, second.Body.String())
+		require.Equal(t, "application/octet-stream", second.Header().Get("Content-Type"))
+		require.Equal(t, int32(1), registry.gets.Load())
+		require.Equal(t, int32(0), registry.heads.Load())
+	})
+
+	t.Run("should verify client access before serving from cache", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		registry.authorized = func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer valid"
+		}
+		handler := newCachingProxy(t, registry.URL, "")
+
+		first := pull(t, handler, testBlobPath(), "Bearer valid")
+		second := pull(t, handler, testBlobPath(), "Bearer valid")
+		unauthorized := pull(t, handler, testBlobPath(), "Bearer invalid")
+
+		require.Equal(t, http.StatusOK, first.Code)
+		require.Equal(t, http.StatusOK, second.Code)
+		require.Equal(t, testBlob, second.Body.String())
+		require.Equal(t, http.StatusUnauthorized, unauthorized.Code)
+		require.Empty(t, unauthorized.Body.String())
+		// the unauthorized request falls through to the registry
+		require.Equal(t, int32(2), registry.gets.Load())
+		require.Equal(t, int32(2), registry.heads.Load())
+	})
+
+	t.Run("should not cache blob not matching its digest", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler := newCachingProxy(t, registry.URL, "Basic abc")
+		wrongDigestPath := "/v2/team/app/blobs/sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+		pull(t, handler, wrongDigestPath, "")
+		pull(t, handler, wrongDigestPath, "")
+
+		require.Equal(t, int32(2), registry.gets.Load())
+	})
+
+	t.Run("should not cache manifests by tag", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		handler := newCachingProxy(t, registry.URL, "Basic abc")
+
+		pull(t, handler, "/v2/team/app/manifests/latest", "")
+		pull(t, handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, int32(2), registry.gets.Load())
+	})
+}
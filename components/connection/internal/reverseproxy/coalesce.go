@@ -0,0 +1,478 @@
+package reverseproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBufferedSize is the size of the largest shared response kept in memory instead of the spool,
+	// it fits manifests, which registries limit to 4MiB, and most config blobs
+	maxBufferedSize = 4 << 20
+	// defaultBufferLimit is the number of bytes of shared responses kept in memory at the same time
+	defaultBufferLimit = 16 << 20
+)
+
+var (
+	errFlightAborted    = errors.New("upstream transfer aborted")
+	errResponseTooLarge = errors.New("upstream response is larger than its Content-Length")
+)
+
+// coalescer shares a single upstream transfer between identical concurrent requests for content-addressed data.
+// The first request starts the transfer. If it's the only client when the upstream response arrives,
+// the response is streamed straight to it and later requests start their own transfers. Otherwise, the response
+// is buffered in memory if it's small, or spooled to a temporary file, and every client streams it from there
+// while it's being written.
+type coalescer struct {
+	proxy       *httputil.ReverseProxy
+	spoolDir    string
+	spoolLimit  int64
+	bufferLimit int64
+	log         *zap.SugaredLogger
+
+	mu      sync.Mutex
+	flights map[string]*flight
+	// spooled is the sum of sizes of responses being spooled, guarded by mu
+	spooled int64
+	// buffered is the sum of sizes of responses buffered in memory, guarded by mu
+	buffered int64
+}
+
+func newCoalescer(proxy *httputil.ReverseProxy, spoolDir string, spoolLimit int64, log *zap.SugaredLogger) *coalescer {
+	return &coalescer{
+		proxy:       proxy,
+		spoolDir:    spoolDir,
+		spoolLimit:  spoolLimit,
+		bufferLimit: defaultBufferLimit,
+		log:         log,
+		flights:     map[string]*flight{},
+	}
+}
+
+// coalesceKey returns the key identifying identical requests, or false if the request can't be shared.
+// The key contains the authorization of the client, so clients never get data fetched with someone else's credentials.
+func coalesceKey(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return "", false
+	}
+	route := distribution.ParsePath(r.URL.Path)
+	if route.Class != distribution.RouteBlob && route.Class != distribution.RouteManifest {
+		return "", false
+	}
+	if !route.HasSHA256Digest() {
+		return "", false
+	}
+	return r.URL.Path + "\x00" + r.Header.Get("Authorization") + "\x00" + r.Header.Get("Accept"), true
+}
+
+// serve writes the response of a shared upstream transfer and returns false if the request can't be shared
+func (c *coalescer) serve(w http.ResponseWriter, r *http.Request) bool {
+	key, ok := coalesceKey(r)
+	if !ok {
+		return false
+	}
+	f := c.join(key, r)
+	defer c.leave(key, f)
+
+	return f.writeTo(r.Context(), w)
+}
+
+func (c *coalescer) join(key string, r *http.Request) *flight {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.flights[key]; ok {
+		c.log.Debugf("Joining in-flight request %s", r.URL.Path)
+		f.clients++
+		f.refs++
+		return f
+	}
+
+	// the transfer must outlive the first client if others are still waiting for it
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	f := &flight{
+		cancel:  cancel,
+		header:  http.Header{},
+		clients: 1,
+		// the upstream transfer holds a reference until it's finished
+		refs: 2,
+	}
+	f.cond = sync.NewCond(&f.mu)
+	c.flights[key] = f
+
+	go c.fetch(key, f, r.Clone(ctx))
+	return f
+}
+
+func (c *coalescer) fetch(key string, f *flight, r *http.Request) {
+	var err error
+	defer func() {
+		// ReverseProxy aborts the handler with a panic when the upstream body breaks
+		if recovered := recover(); recovered != nil {
+			err = errFlightAborted
+		}
+		f.finish(err)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closeLocked(key, f)
+		c.releaseLocked(f)
+	}()
+
+	c.proxy.ServeHTTP(&flightWriter{coalescer: c, key: key, flight: f, header: http.Header{}}, r)
+}
+
+// share decides how the response is passed to clients when its headers arrive, the response is buffered or spooled
+// only if more than one client waits for it and it fits into the buffer or spool limit
+func (c *coalescer) share(key string, f *flight, header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := contentLength(header)
+	if f.clients > 1 && size >= 0 && size <= maxBufferedSize && c.buffered+size <= c.bufferLimit {
+		f.spool = &memorySpool{buf: make([]byte, size)}
+		f.spoolSize = size
+		f.buffered = true
+		c.buffered += size
+		return
+	}
+	if f.clients > 1 && size >= 0 && c.spooled+size <= c.spoolLimit {
+		spool, err := os.CreateTemp(c.spoolDir, "flight-*")
+		if err == nil {
+			f.spool = fileSpool{spool}
+			f.spoolSize = size
+			c.spooled += size
+			return
+		}
+		c.log.Warnf("couldn't spool shared response, streaming it to one client: %v", err)
+	}
+
+	// clients joining from now on couldn't get the part which is already streamed
+	c.closeLocked(key, f)
+	f.pipeReader, f.pipeWriter = io.Pipe()
+	if f.clients == 0 {
+		// nobody would ever read the response
+		_ = f.pipeReader.CloseWithError(errFlightAborted)
+	}
+}
+
+func (c *coalescer) leave(key string, f *flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f.clients--
+	if f.clients == 0 && !f.isDone() {
+		c.log.Debugf("All clients left, cancelling upstream transfer")
+		f.abort()
+		c.closeLocked(key, f)
+	}
+	c.releaseLocked(f)
+}
+
+// closeLocked stops other clients from joining the flight, c.mu must be held
+func (c *coalescer) closeLocked(key string, f *flight) {
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+}
+
+func (c *coalescer) releaseLocked(f *flight) {
+	f.refs--
+	if f.refs == 0 {
+		f.cancel()
+		if f.buffered {
+			c.buffered -= f.spoolSize
+		} else if f.spool != nil {
+			c.spooled -= f.spoolSize
+		}
+		if f.spool != nil {
+			_ = f.spool.Close()
+		}
+	}
+}
+
+// contentLength returns the size of the response body, or -1 if it's unknown
+func contentLength(header http.Header) int64 {
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+// flight is a single upstream transfer shared by many clients
+type flight struct {
+	cancel context.CancelFunc
+
+	// guarded by coalescer.mu
+	clients int
+	refs    int
+
+	// spool is set if the response is shared by many clients, it's read from the beginning by each of them
+	spool     spool
+	spoolSize int64
+	// buffered is true if the spool is kept in memory
+	buffered bool
+	// pipeReader and pipeWriter are set if the response is streamed straight to a single client
+	pipeReader *io.PipeReader
+	pipeWriter *io.PipeWriter
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// status is 0 until the upstream response headers arrive
+	status  int
+	header  http.Header
+	written int64
+	// streamed is true once a client reads the response from the pipe
+	streamed bool
+	done     bool
+	err      error
+}
+
+func (f *flight) isDone() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.done
+}
+
+func (f *flight) finish(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done = true
+	if f.err == nil {
+		f.err = err
+	}
+	if f.pipeWriter != nil {
+		_ = f.pipeWriter.CloseWithError(f.err)
+	}
+	f.cond.Broadcast()
+}
+
+// abort cancels the upstream transfer, which may be blocked writing to the pipe nobody reads anymore
+func (f *flight) abort() {
+	f.cancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pipeReader != nil {
+		_ = f.pipeReader.CloseWithError(errFlightAborted)
+	}
+}
+
+// wait blocks until the condition is met, the flight is done or the context is cancelled, f.mu must be held
+func (f *flight) wait(ctx context.Context, condition func() bool) {
+	stop := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.cond.Broadcast()
+	})
+	defer stop()
+	for !condition() && !f.done && ctx.Err() == nil {
+		f.cond.Wait()
+	}
+}
+
+// writeTo streams the shared response to the client as it arrives from upstream, it returns false if the response
+// is streamed to another client and the request must be sent upstream on its own
+func (f *flight) writeTo(ctx context.Context, w http.ResponseWriter) bool {
+	f.mu.Lock()
+	f.wait(ctx, func() bool { return f.status != 0 })
+	status, header := f.status, f.header
+	streamed := f.pipeReader != nil
+	ownsPipe := streamed && !f.streamed
+	if ownsPipe {
+		f.streamed = true
+	}
+	f.mu.Unlock()
+	if ownsPipe {
+		// unblocks the upstream transfer if the client is gone
+		defer f.pipeReader.Close()
+	}
+	if ctx.Err() != nil {
+		return true
+	}
+	if streamed && !ownsPipe {
+		return false
+	}
+	if status == 0 {
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	}
+
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+
+	if streamed {
+		f.streamTo(w)
+	} else {
+		f.spoolTo(ctx, w)
+	}
+	return true
+}
+
+// streamTo copies the response from the pipe, the upstream transfer is slowed down to the pace of the client
+func (f *flight) streamTo(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.pipeReader.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// let the client know the response is incomplete
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// spoolTo copies the response from the spool as it's being written
+func (f *flight) spoolTo(ctx context.Context, w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		f.mu.Lock()
+		f.wait(ctx, func() bool { return f.written > offset })
+		written, done, err := f.written, f.done, f.err
+		f.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+
+		for offset < written {
+			n, readErr := f.spool.ReadAt(buf[:min(int64(len(buf)), written-offset)], offset)
+			if n > 0 {
+				if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+					return
+				}
+				offset += int64(n)
+			}
+			if readErr != nil && readErr != io.EOF {
+				panic(http.ErrAbortHandler)
+			}
+		}
+		_ = rc.Flush()
+
+		if done {
+			if err != nil {
+				// let the client know the response is incomplete
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+	}
+}
+
+// flightWriter receives the upstream response from the ReverseProxy and makes it available to the flight's clients
+type flightWriter struct {
+	coalescer *coalescer
+	key       string
+	flight    *flight
+	header    http.Header
+}
+
+func (fw *flightWriter) Header() http.Header {
+	return fw.header
+}
+
+func (fw *flightWriter) WriteHeader(status int) {
+	f := fw.flight
+	f.mu.Lock()
+	started := f.status != 0
+	f.mu.Unlock()
+	if started || status < http.StatusOK {
+		return
+	}
+
+	fw.coalescer.share(fw.key, f, fw.header)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+	f.header = fw.header.Clone()
+	f.cond.Broadcast()
+}
+
+func (fw *flightWriter) Write(p []byte) (int, error) {
+	fw.WriteHeader(http.StatusOK)
+	f := fw.flight
+
+	if f.pipeWriter != nil {
+		return f.pipeWriter.Write(p)
+	}
+
+	n, err := f.spool.Write(p)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written += int64(n)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	f.cond.Broadcast()
+	return n, err
+}
+
+// spool keeps a shared response, which clients read while it's being written
+type spool interface {
+	io.Writer
+	io.ReaderAt
+	io.Closer
+}
+
+// fileSpool keeps the response in a temporary file, which is removed when it's closed
+type fileSpool struct {
+	*os.File
+}
+
+func (s fileSpool) Close() error {
+	err := s.File.Close()
+	_ = os.Remove(s.Name())
+	return err
+}
+
+// memorySpool keeps a response of known size in memory. The buffer is allocated upfront, so clients can read
+// the written part while the rest is being written.
+type memorySpool struct {
+	buf []byte
+	// written is only used by the writer
+	written int
+}
+
+func (s *memorySpool) Write(p []byte) (int, error) {
+	n := copy(s.buf[s.written:], p)
+	s.written += n
+	if n < len(p) {
+		return n, errResponseTooLarge
+	}
+	return n, nil
+}
+
+func (s *memorySpool) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(s.buf)) {
+		return 0, io.EOF
+	}
+	return copy(p, s.buf[off:]), nil
+}
+
+func (s *memorySpool) Close() error {
+	return nil
+}
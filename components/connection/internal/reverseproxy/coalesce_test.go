@@ -0,0 +1,251 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testSpoolLimit is the number of bytes of shared transfers tests spool at the same time
+const testSpoolLimit = 1 << 30
+
+// newGatedRegistry returns a registry which doesn't answer until release is closed
+func newGatedRegistry(t *testing.T, release <-chan struct{}) (*httptest.Server, *atomic.Int32) {
+	gets := &atomic.Int32{}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets.Add(1)
+		<-release
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		_, _ = w.Write([]byte(testBlob))
+	}))
+	t.Cleanup(registry.Close)
+	return registry, gets
+}
+
+func newTestCoalescer(t *testing.T, registryURL string) *coalescer {
+	remote, err := url.Parse(registryURL)
+	require.NoError(t, err)
+	return newCoalescer(httputil.NewSingleHostReverseProxy(remote), t.TempDir(), testSpoolLimit, zap.NewNop().Sugar())
+}
+
+func (c *coalescer) clientsOf(r *http.Request) int {
+	key, _ := coalesceKey(r)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f.clients
+	}
+	return 0
+}
+
+func TestCoalescer(t *testing.T) {
+	t.Run("should fetch identical blob requests from upstream once", func(t *testing.T) {
+		release := make(chan struct{})
+		registry, gets := newGatedRegistry(t, release)
+		c := newTestCoalescer(t, registry.URL)
+		// the response is spooled to disk
+		c.bufferLimit = 0
+		clients := 10
+
+		responses := make([]*httptest.ResponseRecorder, clients)
+		wg := sync.WaitGroup{}
+		for i := range clients {
+			responses[i] = httptest.NewRecorder()
+			wg.Go(func() {
+				require.True(t, c.serve(responses[i], httptest.NewRequest(http.MethodGet, testBlobPath(), nil)))
+			})
+		}
+		probe := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+		require.Eventually(t, func() bool {
+			return c.clientsOf(probe) == clients
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(1), gets.Load())
+		for _, response := range responses {
+			require.Equal(t, http.StatusOK, response.Code)
+			require.Equal(t, testBlob, response.Body.String())
+			require.Equal(t, "sha256:abc", response.Header().Get("Docker-Content-Digest"))
+		}
+		require.Empty(t, c.flights)
+		require.Zero(t, c.spooled)
+	})
+
+	t.Run("should fetch small responses from upstream once without a spool", func(t *testing.T) {
+		release := make(chan struct{})
+		registry, gets := newGatedRegistry(t, release)
+		remote, err := url.Parse(registry.URL)
+		require.NoError(t, err)
+		spoolDir := t.TempDir()
+		c := newCoalescer(httputil.NewSingleHostReverseProxy(remote), spoolDir, 0, zap.NewNop().Sugar())
+		clients := 10
+
+		responses := make([]*httptest.ResponseRecorder, clients)
+		wg := sync.WaitGroup{}
+		for i := range clients {
+			responses[i] = httptest.NewRecorder()
+			wg.Go(func() {
+				require.True(t, c.serve(responses[i], httptest.NewRequest(http.MethodGet, testBlobPath(), nil)))
+			})
+		}
+		probe := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+		require.Eventually(t, func() bool {
+			return c.clientsOf(probe) == clients
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(1), gets.Load())
+		for _, response := range responses {
+			require.Equal(t, http.StatusOK, response.Code)
+			require.Equal(t, testBlob, response.Body.String())
+		}
+		entries, err := os.ReadDir(spoolDir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		require.Zero(t, c.buffered)
+	})
+
+	t.Run("should not share responses between different credentials", func(t *testing.T) {
+		release := make(chan struct{})
+		registry, gets := newGatedRegistry(t, release)
+		c := newTestCoalescer(t, registry.URL)
+
+		wg := sync.WaitGroup{}
+		for _, authorization := range []string{"Bearer first", "Bearer second"} {
+			wg.Go(func() {
+				r := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+				r.Header.Set("Authorization", authorization)
+				require.True(t, c.serve(httptest.NewRecorder(), r))
+			})
+		}
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return len(c.flights) == 2
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(2), gets.Load())
+	})
+
+	t.Run("should cancel upstream transfer when all clients leave", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		registry, _ := newGatedRegistry(t, release)
+		c := newTestCoalescer(t, registry.URL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, testBlobPath(), nil)
+		done := make(chan bool)
+		go func() {
+			done <- c.serve(httptest.NewRecorder(), r)
+		}()
+		require.Eventually(t, func() bool {
+			return c.clientsOf(r) == 1
+		}, time.Second, time.Millisecond)
+		cancel()
+
+		require.True(t, <-done)
+		require.Empty(t, c.flights)
+	})
+
+	t.Run("should stream response of a single client without spooling it", func(t *testing.T) {
+		release := make(chan struct{})
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(testBlob)))
+			_, _ = w.Write([]byte(testBlob[:1]))
+			w.(http.Flusher).Flush()
+			<-release
+			_, _ = w.Write([]byte(testBlob[1:]))
+		}))
+		defer registry.Close()
+		spoolDir := t.TempDir()
+		remote, err := url.Parse(registry.URL)
+		require.NoError(t, err)
+		c := newCoalescer(httputil.NewSingleHostReverseProxy(remote), spoolDir, testSpoolLimit, zap.NewNop().Sugar())
+
+		response := httptest.NewRecorder()
+		done := make(chan bool)
+		go func() {
+			done <- c.serve(response, httptest.NewRequest(http.MethodGet, testBlobPath(), nil))
+		}()
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// the flight is closed for joining once the response is streamed
+			return len(c.flights) == 0
+		}, time.Second, time.Millisecond)
+		entries, err := os.ReadDir(spoolDir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		close(release)
+
+		require.True(t, <-done)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, testBlob, response.Body.String())
+	})
+
+	t.Run("should send requests on their own when the response exceeds the buffer and spool limits", func(t *testing.T) {
+		release := make(chan struct{})
+		registry, gets := newGatedRegistry(t, release)
+		remote, err := url.Parse(registry.URL)
+		require.NoError(t, err)
+		proxy := httputil.NewSingleHostReverseProxy(remote)
+		c := newCoalescer(proxy, t.TempDir(), 1, zap.NewNop().Sugar())
+		c.bufferLimit = 0
+		clients := 3
+
+		responses := make([]*httptest.ResponseRecorder, clients)
+		wg := sync.WaitGroup{}
+		for i := range clients {
+			responses[i] = httptest.NewRecorder()
+			wg.Go(func() {
+				r := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+				if !c.serve(responses[i], r) {
+					proxy.ServeHTTP(responses[i], r)
+				}
+			})
+		}
+		probe := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+		require.Eventually(t, func() bool {
+			return c.clientsOf(probe) == clients
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(clients), gets.Load())
+		for _, response := range responses {
+			require.Equal(t, http.StatusOK, response.Code)
+			require.Equal(t, testBlob, response.Body.String())
+		}
+		require.Zero(t, c.spooled)
+		require.Zero(t, c.buffered)
+	})
+
+	t.Run("should not coalesce mutable or partial requests", func(t *testing.T) {
+		c := newTestCoalescer(t, "http://registry")
+
+		byTag := httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/latest", nil)
+		head := httptest.NewRequest(http.MethodHead, testBlobPath(), nil)
+		partial := httptest.NewRequest(http.MethodGet, testBlobPath(), nil)
+		partial.Header.Set("Range", "bytes=0-3")
+
+		for _, r := range []*http.Request{byTag, head, partial} {
+			require.False(t, c.serve(httptest.NewRecorder(), r))
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package reverseproxy
+
+import "net/http"
+
+// Header is set on every request forwarded to the target registry
+type Header struct {
+	Name string
+	// Value returns the current value of the header
+	Value func() string
+	// Secret is true if the value must not be logged
+	Secret bool
+}
+
+// applyRequestHeaders removes stripped headers from the request and sets the configured ones afterward,
+// so a header can be both stripped and set to replace whatever the client sent
+func applyRequestHeaders(cfg Config, r *http.Request) {
+	for _, name := range cfg.StripRequestHeaders {
+		r.Header.Del(name)
+	}
+	for _, header := range cfg.Headers {
+		if value := header.Value(); value != "" {
+			r.Header.Set(header.Name, value)
+		}
+	}
+}
+
+// getStripResponseFunc removes the given headers from responses of the target registry
+func getStripResponseFunc(names []string) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		for _, name := range names {
+			resp.Header.Del(name)
+		}
+		return nil
+	}
+}
+
+// secretHeaders returns canonical names of headers whose values are redacted in logs
+func (cfg Config) secretHeaders() []string {
+	names := []string{"Authorization", "Proxy-Authorization"}
+	for _, header := range cfg.Headers {
+		if header.Secret {
+			names = append(names, http.CanonicalHeaderKey(header.Name))
+		}
+	}
+	return names
+}
@@ -0,0 +1,76 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHeaders(t *testing.T) {
+	t.Run("should set and strip headers", func(t *testing.T) {
+		var header http.Header
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			w.Header().Set("X-Internal-Trace", "abc")
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(registry.Close)
+		apiKey := "key-1"
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Headers: []Header{
+				{Name: "X-Tenant", Value: func() string { return "acme" }},
+				{Name: "User-Agent", Value: func() string { return "gateway-client" }},
+				{Name: "X-Api-Key", Value: func() string { return apiKey }, Secret: true},
+			},
+			StripRequestHeaders:  []string{"Cookie", "X-Tenant"},
+			StripResponseHeaders: []string{"X-Internal-Trace"},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/latest", nil)
+		r.Header.Set("Cookie", "session=1")
+		r.Header.Set("X-Tenant", "other")
+		w := httptest.NewRecorder()
+		proxy.HTTPServer.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, header.Get("Cookie"))
+		require.Equal(t, "acme", header.Get("X-Tenant"))
+		require.Equal(t, "gateway-client", header.Get("User-Agent"))
+		require.Equal(t, "key-1", header.Get("X-Api-Key"))
+		require.Empty(t, w.Header().Get("X-Internal-Trace"))
+		require.Equal(t, "registry/2.0", w.Header().Get("Docker-Distribution-Api-Version"))
+
+		// rotated values are used by the next request
+		apiKey = "key-2"
+		proxy.HTTPServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/", nil))
+		require.Equal(t, "key-2", header.Get("X-Api-Key"))
+	})
+
+	t.Run("should redact secret headers in logs", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(registry.Close)
+		core, logs := observer.New(zapcore.DebugLevel)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Headers: []Header{
+				{Name: "x-api-key", Value: func() string { return "very-secret" }, Secret: true},
+			},
+		}, zap.New(core).Sugar())
+		require.NoError(t, err)
+
+		proxy.HTTPServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/", nil))
+
+		require.NotEmpty(t, logs.FilterMessageSnippet("X-Api-Key").All())
+		require.Empty(t, logs.FilterMessageSnippet("very-secret").All())
+	})
+}
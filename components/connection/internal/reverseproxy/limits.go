@@ -0,0 +1,222 @@
+package reverseproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/metrics"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultQueueSize is the number of requests waiting for the limits if the size isn't configured
+	defaultQueueSize = 100
+	// limitRetryAfter is suggested to clients rejected because the queue is full
+	limitRetryAfter = time.Second
+	// maxBandwidthBurst is the largest chunk of the response body read at once when the bandwidth is limited
+	maxBandwidthBurst = 64 << 10
+)
+
+var errQueueFull = errors.New("too many requests are waiting for the limits of the connection")
+
+// Limits protect the Cloud Connector tunnel shared with other workloads from bursts of requests, zero values mean no limit
+type Limits struct {
+	// MaxConcurrentRequests sent to the target registry at the same time
+	MaxConcurrentRequests int
+	// RequestsPerSecond sent to the target registry
+	RequestsPerSecond float64
+	// BytesPerSecond read from the target registry by all requests together
+	BytesPerSecond int64
+	// QueueSize is the number of requests waiting for the limits, requests above it are rejected, 100 by default
+	QueueSize int
+}
+
+// limiter queues requests until they're within the limits
+type limiter struct {
+	// slots holds a value for every request in progress, nil if the concurrency isn't limited
+	slots chan struct{}
+	// requests is nil if the request rate isn't limited
+	requests *rate.Limiter
+	// bytes is nil if the bandwidth isn't limited
+	bytes     *rate.Limiter
+	queueSize int64
+	queued    atomic.Int64
+}
+
+func newLimiter(cfg Limits) *limiter {
+	l := &limiter{queueSize: defaultQueueSize}
+	if cfg.QueueSize > 0 {
+		l.queueSize = int64(cfg.QueueSize)
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	if cfg.RequestsPerSecond > 0 {
+		l.requests = rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), max(int(cfg.RequestsPerSecond), 1))
+	}
+	if cfg.BytesPerSecond > 0 {
+		l.bytes = rate.NewLimiter(rate.Limit(cfg.BytesPerSecond), int(min(cfg.BytesPerSecond, maxBandwidthBurst)))
+	}
+	return l
+}
+
+// acquire waits until the request is within the limits and returns the function ending it,
+// errQueueFull is returned right away if too many requests are waiting already
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	queued := false
+	enqueue := func() error {
+		if queued {
+			return nil
+		}
+		if l.queued.Add(1) > l.queueSize {
+			l.queued.Add(-1)
+			return errQueueFull
+		}
+		queued = true
+		metrics.ObserveQueued(1)
+		return nil
+	}
+	defer func() {
+		if queued {
+			l.queued.Add(-1)
+			metrics.ObserveQueued(-1)
+		}
+	}()
+
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if err := enqueue(); err != nil {
+				return nil, err
+			}
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		release = func() {
+			<-l.slots
+		}
+	}
+
+	if l.requests != nil {
+		reservation := l.requests.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			err := enqueue()
+			if err == nil {
+				err = sleep(ctx, delay)
+			}
+			if err != nil {
+				reservation.Cancel()
+				release()
+				return nil, err
+			}
+		}
+	}
+	return release, nil
+}
+
+// limitRoundTripper sends requests to the target registry within the limits, so clients sharing a coalesced transfer
+// or served from the cache don't count against them. A request holds its concurrency slot until its body is closed.
+type limitRoundTripper struct {
+	transport http.RoundTripper
+	limiter   *limiter
+}
+
+func (t *limitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isProbe(req.Context()) {
+		// a busy connection is still ready
+		return t.transport.RoundTrip(req)
+	}
+	release, err := t.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: sync.OnceFunc(release)}
+	return resp, nil
+}
+
+// releasingBody ends the request within the limits when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// getErrorHandler answers requests rejected by the limits with TOOMANYREQUESTS,
+// other errors are answered with Bad Gateway like ReverseProxy does by default, or passed to the readiness probe
+func getErrorHandler(log *zap.SugaredLogger) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if probe, ok := w.(*probeWriter); ok {
+			// the probe reports the error on its own
+			probe.err = err
+			return
+		}
+		if errors.Is(err, errQueueFull) {
+			log.Infof("Rejected %s %s, %v", r.Method, r.URL.Path, err)
+			metrics.ObserveDenied(r.Method, "limit")
+			w.Header().Set("Retry-After", strconv.Itoa(int(limitRetryAfter.Seconds())))
+			distribution.WriteError(w, http.StatusTooManyRequests, distribution.ErrorCodeTooManyRequests, err.Error())
+			return
+		}
+		log.Warnf("http: proxy error: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// getBandwidthResponseFunc reads responses of the target registry no faster than the bandwidth limit
+func getBandwidthResponseFunc(l *limiter) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		ctx := context.Background()
+		if resp.Request != nil {
+			ctx = resp.Request.Context()
+		}
+		resp.Body = &throttledBody{body: resp.Body, ctx: ctx, limiter: l.bytes}
+		return nil
+	}
+}
+
+// throttledBody waits after every read until the bandwidth limit allows the bytes read
+type throttledBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > b.limiter.Burst() {
+		p = p[:b.limiter.Burst()]
+	}
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,152 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("should queue requests above the concurrency limit", func(t *testing.T) {
+		l := newLimiter(Limits{MaxConcurrentRequests: 1, QueueSize: 1})
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+
+		acquired := make(chan struct{})
+		go func() {
+			releaseQueued, err := l.acquire(context.Background())
+			if err == nil {
+				releaseQueued()
+			}
+			close(acquired)
+		}()
+		require.Eventually(t, func() bool {
+			return l.queued.Load() == 1
+		}, time.Second, time.Millisecond)
+
+		_, err = l.acquire(context.Background())
+		require.ErrorIs(t, err, errQueueFull)
+
+		release()
+		<-acquired
+		require.Zero(t, l.queued.Load())
+	})
+
+	t.Run("should stop waiting when the client goes away", func(t *testing.T) {
+		l := newLimiter(Limits{MaxConcurrentRequests: 1})
+		_, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err = l.acquire(ctx)
+
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Zero(t, l.queued.Load())
+	})
+
+	t.Run("should delay requests above the rate limit", func(t *testing.T) {
+		l := newLimiter(Limits{RequestsPerSecond: 10})
+		for range 10 {
+			_, err := l.acquire(context.Background())
+			require.NoError(t, err)
+		}
+
+		start := time.Now()
+		_, err := l.acquire(context.Background())
+
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("should reject requests with TOOMANYREQUESTS when the queue is full", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		getErrorHandler(zap.NewNop().Sugar())(w, httptest.NewRequest(http.MethodGet, "/v2/team/app/blobs/sha256:abc", nil), errQueueFull)
+
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+		require.Contains(t, w.Body.String(), `"code":"TOOMANYREQUESTS"`)
+	})
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("should limit bandwidth of responses", func(t *testing.T) {
+		blob := bytes.Repeat([]byte("a"), 96<<10)
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(blob)
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Limits:               &Limits{BytesPerSecond: 64 << 10},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		start := time.Now()
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/blobs/sha256:abc", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, blob, w.Body.Bytes())
+		// the first 64KiB are the burst, the rest takes half a second
+		require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("should count coalesced clients as one upstream request", func(t *testing.T) {
+		release := make(chan struct{})
+		registry, gets := newGatedRegistry(t, release)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Limits:               &Limits{MaxConcurrentRequests: 1, QueueSize: 1},
+			SpoolLimit:           testSpoolLimit,
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		clients := 5
+
+		responses := make([]*httptest.ResponseRecorder, clients)
+		wg := sync.WaitGroup{}
+		for i := range clients {
+			wg.Go(func() {
+				responses[i] = pull(t, proxy.HTTPServer.Handler, testBlobPath(), "")
+			})
+		}
+		require.Eventually(t, func() bool {
+			return gets.Load() == 1
+		}, time.Second, time.Millisecond)
+		// clients above the queue size would be rejected if they waited for the limits
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(1), gets.Load())
+		for _, response := range responses {
+			require.Equal(t, http.StatusOK, response.Code)
+			require.Equal(t, testBlob, response.Body.String())
+		}
+	})
+
+	t.Run("should pass requests within the limits", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Limits:               &Limits{MaxConcurrentRequests: 1, RequestsPerSecond: 100},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		for range 3 {
+			w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+	})
+}
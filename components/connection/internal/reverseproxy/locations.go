@@ -0,0 +1,242 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// locationHeader passes the location ID of the Cloud Connector to the HTTP proxy of the Connectivity Proxy
+	locationHeader = "SAP-Connectivity-SCC-Location_ID"
+	// defaultLocationFailback is how long failover locations are used before the first location is tried again
+	defaultLocationFailback = 5 * time.Minute
+	// maxTunnelErrorSize is read from gateway errors to tell errors of the Connectivity Proxy from the ones of the target
+	maxTunnelErrorSize = 4096
+	// locationCheckTimeout limits checks of the first location before switching back to it
+	locationCheckTimeout = 10 * time.Second
+)
+
+// tunnelErrorPattern matches error pages of the Connectivity Proxy which can't reach the Cloud Connector of the location,
+// e.g. "There is no SAP Cloud Connector (SCC) connected to your subaccount with location ID dc-1"
+var tunnelErrorPattern = regexp.MustCompile(`(?i)\bno SAP Cloud Connector \(SCC\) (is )?connected\b`)
+
+// locations selects the location ID of the Cloud Connector requests are sent to. Locations are used in the configured
+// order, the next one is used after a tunnel error of the current one and the first one again after the failback period
+// once it's reachable.
+type locations struct {
+	ids      []string
+	failback time.Duration
+	log      *zap.SugaredLogger
+	// check returns an error if the Cloud Connector of the location can't be reached, nil switches back without checking
+	check func(ctx context.Context, id string) error
+	// onSwitch are called when the location changes, e.g. to close idle connections tunneled through the previous one
+	onSwitch []func()
+
+	mu     sync.Mutex
+	active int
+	// failedOverAt is when the first location was left or last checked
+	failedOverAt time.Time
+	checking     bool
+}
+
+func newLocations(cfg Config, log *zap.SugaredLogger) *locations {
+	var ids []string
+	if cfg.LocationID != "" {
+		ids = append(ids, cfg.LocationID)
+	}
+	ids = append(ids, cfg.FailoverLocationIDs...)
+	failback := cfg.LocationFailback
+	if failback == 0 {
+		failback = defaultLocationFailback
+	}
+	return &locations{ids: ids, failback: failback, log: log}
+}
+
+// current returns the location ID to use, empty if none is configured
+func (l *locations) current() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ids) == 0 {
+		return ""
+	}
+	if l.active != 0 && !l.checking && time.Since(l.failedOverAt) >= l.failback {
+		if l.check == nil {
+			l.log.Infof("Switching back to location %q after %s", l.ids[0], l.failback)
+			l.active = 0
+			go l.switched()
+		} else {
+			// requests keep using the current location until the check is done
+			l.checking = true
+			go l.checkFirst()
+		}
+	}
+	return l.ids[l.active]
+}
+
+// activeID returns the location ID in use, empty if none is configured. Unlike current, it never switches back
+// to the first location, so it can be used by logs and the status.
+func (l *locations) activeID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ids) == 0 {
+		return ""
+	}
+	return l.ids[l.active]
+}
+
+// checkFirst switches back to the first location if its Cloud Connector can be reached,
+// otherwise it's checked again after another failback period
+func (l *locations) checkFirst() {
+	ctx, cancel := context.WithTimeout(context.Background(), locationCheckTimeout)
+	defer cancel()
+	err := l.check(ctx, l.ids[0])
+
+	l.mu.Lock()
+	l.checking = false
+	if l.active == 0 {
+		// wrapped around in the meantime
+		l.mu.Unlock()
+		return
+	}
+	if err != nil {
+		l.log.Infof("Staying on location %q, location %q can't be reached yet: %v", l.ids[l.active], l.ids[0], err)
+		l.failedOverAt = time.Now()
+		l.mu.Unlock()
+		return
+	}
+	l.log.Infof("Switching back to location %q after %s", l.ids[0], l.failback)
+	l.active = 0
+	l.mu.Unlock()
+	l.switched()
+}
+
+// dialLocation returns the location ID tunnels opened for the request use
+func (l *locations) dialLocation(ctx context.Context) string {
+	if id, ok := ctx.Value(locationKey{}).(string); ok {
+		return id
+	}
+	return l.current()
+}
+
+type locationKey struct{}
+
+// withLocation makes tunnels opened for the request use the given location instead of the current one
+func withLocation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, locationKey{}, id)
+}
+
+// fail switches to the next location if the given one is still in use, so concurrent failures switch only once
+func (l *locations) fail(id, cause string) {
+	l.mu.Lock()
+	if len(l.ids) < 2 || l.ids[l.active] != id {
+		l.mu.Unlock()
+		return
+	}
+	next := (l.active + 1) % len(l.ids)
+	l.log.Warnf("Switching from location %q to %q after tunnel error: %s", id, l.ids[next], cause)
+	if l.active == 0 {
+		l.failedOverAt = time.Now()
+	}
+	l.active = next
+	l.mu.Unlock()
+	l.switched()
+}
+
+func (l *locations) switched() {
+	for _, f := range l.onSwitch {
+		f()
+	}
+}
+
+// newLocationCheck returns a check sending a request to the target through the given location. Its transport doesn't
+// keep connections alive, so connections tunneled through the current location aren't reused.
+func newLocationCheck(remote *url.URL, cfg Config, locations *locations) func(context.Context, string) error {
+	var transport *http.Transport
+	target := *remote
+	target.Path = strings.TrimSuffix(remote.Path, "/") + "/v2/"
+	switch {
+	case cfg.usesSOCKS():
+		transport = newSOCKSTransport(remote, cfg, locations)
+	case cfg.usesTLS():
+		transport = newTLSTransport(remote, cfg, locations)
+	default:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if cfg.usesTLS() || cfg.usesSOCKS() {
+		target.Scheme = cfg.targetScheme()
+		target.Host = cfg.TargetHost
+	}
+	transport.DisableKeepAlives = true
+
+	return func(ctx context.Context, id string) error {
+		req, err := http.NewRequestWithContext(withLocation(ctx, id), http.MethodGet, target.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Host = cfg.TargetHost
+		if !cfg.usesSOCKS() {
+			req.Header.Set(locationHeader, id)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if isTunnelError(resp) {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// locationRoundTripper sends requests passing a location ID to the current location,
+// so retries after a tunnel error are sent to the next one
+type locationRoundTripper struct {
+	transport http.RoundTripper
+	locations *locations
+}
+
+func (t *locationRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := req.Header.Get(locationHeader)
+	if id == "" {
+		// tunneled requests pass the location ID when the tunnel is opened
+		return t.transport.RoundTrip(req)
+	}
+	if current := t.locations.current(); current != id {
+		req = req.Clone(req.Context())
+		req.Header.Set(locationHeader, current)
+		id = current
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err == nil && isTunnelError(resp) {
+		t.locations.fail(id, resp.Status)
+	}
+	return resp, err
+}
+
+// isTunnelError returns true if the response is an error page of the Connectivity Proxy which couldn't reach
+// the Cloud Connector, the body stays readable
+func isTunnelError(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusBadGateway && resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTunnelErrorSize))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil {
+		return false
+	}
+	return tunnelErrorPattern.Match(body)
+}
@@ -0,0 +1,228 @@
+package reverseproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeLocationsProxy answers like the Connectivity Proxy which can't reach the Cloud Connectors of the down locations
+type fakeLocationsProxy struct {
+	mu   sync.Mutex
+	down map[string]bool
+	// used are location IDs of received requests in order
+	used []string
+}
+
+func (p *fakeLocationsProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	locationID := r.Header.Get(locationHeader)
+	p.used = append(p.used, locationID)
+	if p.down[locationID] {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("There is no SAP Cloud Connector (SCC) connected to your subaccount with location ID " + locationID))
+		return
+	}
+	_, _ = w.Write([]byte(locationID))
+}
+
+func (p *fakeLocationsProxy) requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.used...)
+}
+
+func TestLocations(t *testing.T) {
+	t.Run("should fail over to next location after tunnel error", func(t *testing.T) {
+		cp := &fakeLocationsProxy{down: map[string]bool{"primary": true}}
+		server := httptest.NewServer(cp)
+		t.Cleanup(server.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: server.URL,
+			TargetHost:           "registry.example",
+			LocationID:           "primary",
+			FailoverLocationIDs:  []string{"backup"},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "backup", w.Body.String())
+		require.Equal(t, "backup", proxy.Upstream.LocationID())
+
+		// following requests are sent to the backup right away
+		w = pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []string{"primary", "backup", "backup"}, cp.requests())
+	})
+
+	t.Run("should keep location on gateway errors of the target", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"UNAVAILABLE","message":"maintenance"}]}`))
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			LocationID:           "primary",
+			FailoverLocationIDs:  []string{"backup"},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "maintenance")
+		require.Equal(t, "primary", proxy.Upstream.LocationID())
+	})
+
+	t.Run("should keep location on gateway errors of the target mentioning the Cloud Connector", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"UNAVAILABLE","message":"mirror scc-eu of the Cloud Connector docs is down"}]}`))
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			LocationID:           "primary",
+			FailoverLocationIDs:  []string{"backup"},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Equal(t, "primary", proxy.Upstream.LocationID())
+	})
+
+	t.Run("should switch back to first location after failback period once it's reachable", func(t *testing.T) {
+		l := newLocations(Config{LocationID: "primary", FailoverLocationIDs: []string{"backup"}, LocationFailback: 50 * time.Millisecond}, zap.NewNop().Sugar())
+		var reachable atomic.Bool
+		var checks atomic.Int32
+		l.check = func(_ context.Context, id string) error {
+			require.Equal(t, "primary", id)
+			checks.Add(1)
+			if !reachable.Load() {
+				return errors.New("status 503")
+			}
+			return nil
+		}
+		var switches atomic.Int32
+		l.onSwitch = append(l.onSwitch, func() { switches.Add(1) })
+
+		l.fail("primary", "status 503")
+		require.Equal(t, "backup", l.current())
+		// the failure of a request sent before the switch doesn't switch again
+		l.fail("primary", "status 503")
+		require.Equal(t, "backup", l.current())
+		require.Equal(t, int32(1), switches.Load())
+
+		// the unreachable first location is checked again after another failback period
+		require.Eventually(t, func() bool {
+			return l.current() == "backup" && checks.Load() >= 2
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, int32(1), switches.Load())
+
+		reachable.Store(true)
+		require.Eventually(t, func() bool {
+			return l.current() == "primary"
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, int32(2), switches.Load())
+	})
+
+	t.Run("should report the active location without switching back", func(t *testing.T) {
+		l := newLocations(Config{LocationID: "primary", FailoverLocationIDs: []string{"backup"}, LocationFailback: time.Millisecond}, zap.NewNop().Sugar())
+		var checks atomic.Int32
+		l.check = func(context.Context, string) error {
+			checks.Add(1)
+			return nil
+		}
+
+		l.fail("primary", "status 503")
+		time.Sleep(10 * time.Millisecond)
+
+		require.Equal(t, "backup", l.activeID())
+		require.Equal(t, int32(0), checks.Load())
+		require.False(t, l.checking)
+	})
+
+	t.Run("should wrap around to first location", func(t *testing.T) {
+		l := newLocations(Config{LocationID: "primary", FailoverLocationIDs: []string{"backup"}}, zap.NewNop().Sugar())
+
+		l.fail("primary", "status 503")
+		l.fail("backup", "status 502")
+
+		require.Equal(t, "primary", l.current())
+	})
+
+	t.Run("should fail over CONNECT tunnels", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeTunnelProxy(t, registry)
+		cp.downLocationID = "primary"
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+			TargetServerName:     "example.com",
+			RootCAs:              trusting(registry),
+			LocationID:           "primary",
+			FailoverLocationIDs:  []string{"backup"},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, int32(1), cp.tunnels.Load())
+		require.Equal(t, "backup", cp.lastConnect.Header.Get(locationHeader))
+	})
+
+	t.Run("should close tunnels of the previous location when switching back", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeTunnelProxy(t, registry)
+		cp.downLocationID = "primary"
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+			TargetServerName:     "example.com",
+			RootCAs:              trusting(registry),
+			LocationID:           "primary",
+			FailoverLocationIDs:  []string{"backup"},
+			LocationFailback:     50 * time.Millisecond,
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "backup", cp.lastConnect.Header.Get(locationHeader))
+
+		cp.setDown("")
+		// the status doesn't switch back, requests after the failback period start the check
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, "backup", proxy.Upstream.LocationID())
+		w = pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Eventually(t, func() bool {
+			return proxy.Upstream.LocationID() == "primary"
+		}, time.Second, 10*time.Millisecond)
+		// the check opened a tunnel through the first location
+		require.Equal(t, int32(2), cp.tunnels.Load())
+
+		w = pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, int32(3), cp.tunnels.Load())
+		require.Equal(t, "primary", cp.lastConnect.Header.Get(locationHeader))
+	})
+}
@@ -0,0 +1,167 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+)
+
+// linkURLRegexp matches the URL of a Link header, e.g. </v2/_catalog?last=app&n=100>; rel="next"
+var linkURLRegexp = regexp.MustCompile(`<([^>]*)>`)
+
+// pathRoundTripper sends requests for the target registry to the paths and repositories of the mapping
+// and maps paths and repositories of its responses back, so the transports above it only see paths of clients
+type pathRoundTripper struct {
+	transport  http.RoundTripper
+	paths      *distribution.PathMapping
+	targetHost string
+	scheme     string
+}
+
+func (t *pathRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Host != t.targetHost || isTokenRequest(req) {
+		// redirect locations and the authorization server are reached as they are
+		return t.transport.RoundTrip(req)
+	}
+	targetPath, ok := t.paths.TargetPath(req.URL.Path)
+	if !ok {
+		return invalidNameResponse(req), nil
+	}
+	route := distribution.ParsePath(req.URL.Path)
+	out := req.Clone(req.Context())
+	out.URL.Path, out.URL.RawPath = targetPath, ""
+	query := out.URL.Query()
+	if from := query.Get("from"); from != "" && route.Class == distribution.RouteBlobUpload {
+		// cross-repository blob mount
+		if !distribution.IsValidRepositoryName(from) {
+			return invalidNameResponse(req), nil
+		}
+		query.Set("from", t.paths.TargetRepository(from))
+		out.URL.RawQuery = query.Encode()
+	}
+	if last := query.Get("last"); last != "" && route.Class == distribution.RouteCatalog {
+		query.Set("last", t.paths.TargetRepository(last))
+		out.URL.RawQuery = query.Encode()
+	}
+
+	resp, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", t.clientURL(location))
+	}
+	links := resp.Header.Values("Link")
+	for i, link := range links {
+		links[i] = linkURLRegexp.ReplaceAllStringFunc(link, func(match string) string {
+			return "<" + t.clientURL(match[1:len(match)-1]) + ">"
+		})
+	}
+	if route.Class == distribution.RouteCatalog && resp.StatusCode == http.StatusOK {
+		// repositories outside of the prefix are removed
+		if err := rewriteCatalog(resp, t.paths.ClientRepository); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// invalidNameResponse rejects requests the mapping can't send to the registry, without reaching it
+func invalidNameResponse(req *http.Request) *http.Response {
+	body := distribution.ErrorBody(distribution.ErrorCodeNameInvalid, "invalid repository name")
+	return &http.Response{
+		Status:        strconv.Itoa(http.StatusBadRequest) + " " + http.StatusText(http.StatusBadRequest),
+		StatusCode:    http.StatusBadRequest,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// clientURL maps the path of URLs pointing at the target registry, other URLs stay the same
+func (t *pathRoundTripper) clientURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Host != "" && !sameHost(u, t.scheme, t.targetHost)) {
+		return rawURL
+	}
+	path, ok := t.paths.ClientPath(u.Path)
+	if !ok {
+		return rawURL
+	}
+	u.Path, u.RawPath = path, ""
+	query := u.Query()
+	if last := query.Get("last"); last != "" {
+		if name, ok := t.paths.ClientRepository(last); ok {
+			query.Set("last", name)
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.String()
+}
+
+// getScopeResponseFunc replaces repositories of the registry in scopes of WWW-Authenticate headers with the names clients use,
+// clients request tokens for these scopes from the authorization container which maps them back
+func getScopeResponseFunc(paths *distribution.PathMapping) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		values := resp.Header.Values("WWW-Authenticate")
+		for i, value := range values {
+			challenges, err := ParseChallenges(value)
+			if err != nil {
+				// the client gets the challenge as it is
+				continue
+			}
+			for j, challenge := range challenges {
+				if scope := challenge.Param("scope"); scope != "" {
+					challenges[j].SetParam("scope", paths.ClientScope(scope))
+				}
+			}
+			values[i] = FormatChallenges(challenges)
+		}
+		return nil
+	}
+}
+
+// mapTokenScopes replaces repositories in scopes of a token request with the names of the registry,
+// scopes are passed in the query or in the form of POST requests
+func mapTokenScopes(paths *distribution.PathMapping, r *http.Request) error {
+	query := r.URL.Query()
+	if scopes := query["scope"]; len(scopes) != 0 {
+		for i, scope := range scopes {
+			scopes[i] = paths.TargetScope(scope)
+		}
+		r.URL.RawQuery = query.Encode()
+	}
+
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTokenResponseSize))
+	_ = r.Body.Close()
+	if err != nil {
+		return err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	if scopes := form["scope"]; len(scopes) != 0 {
+		for i, scope := range scopes {
+			scopes[i] = paths.TargetScope(scope)
+		}
+		body = []byte(form.Encode())
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
@@ -0,0 +1,135 @@
+package reverseproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestPathMapping(t *testing.T) {
+	newMappingProxy := func(t *testing.T, handler http.HandlerFunc) http.Handler {
+		registry := httptest.NewServer(handler)
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			AllowedMethods:       []string{http.MethodGet, http.MethodHead, http.MethodPost},
+			Paths:                distribution.NewPathMapping("/artifactory/api/docker/docker-remote", "prod"),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		return proxy.HTTPServer.Handler
+	}
+
+	t.Run("should send requests to the base path and repository prefix", func(t *testing.T) {
+		var path string
+		proxy := newMappingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://auth.example/token",service="registry",scope="repository:prod/team-a/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+
+		w := pull(t, proxy, "/v2/team-a/app/manifests/latest", "")
+
+		require.Equal(t, "/artifactory/api/docker/docker-remote/v2/prod/team-a/app/manifests/latest", path)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Header().Get("WWW-Authenticate"), `scope="repository:team-a/app:pull"`)
+	})
+
+	t.Run("should reject paths escaping the base path and prefix", func(t *testing.T) {
+		transport := &pathRoundTripper{
+			transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				t.Fatalf("unexpected request to %s", r.URL.Path)
+				return nil, nil
+			}),
+			paths:      distribution.NewPathMapping("/artifactory/api/docker/docker-remote", "prod"),
+			targetHost: "registry.example",
+		}
+		r := httptest.NewRequest(http.MethodGet, "http://registry.example/v2/../../other/manifests/latest", nil)
+
+		resp, err := transport.RoundTrip(r)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `"code":"NAME_INVALID"`)
+	})
+
+	t.Run("should map locations and mounts back", func(t *testing.T) {
+		var query url.Values
+		proxy := newMappingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Header().Set("Location", "http://registry.example/artifactory/api/docker/docker-remote/v2/prod/team-a/app/blobs/uploads/1?state=abc")
+			w.WriteHeader(http.StatusAccepted)
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/v2/team-a/app/blobs/uploads/?mount=sha256:abc&from=team-b/base", nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Equal(t, "prod/team-b/base", query.Get("from"))
+		require.Equal(t, "/v2/team-a/app/blobs/uploads/1?state=abc", w.Header().Get("Location"))
+	})
+
+	t.Run("should list only repositories under the prefix", func(t *testing.T) {
+		var last string
+		proxy := newMappingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+			last = r.URL.Query().Get("last")
+			w.Header().Set("Link", `</artifactory/api/docker/docker-remote/v2/_catalog?last=prod%2Fteam-b%2Fapp&n=2>; rel="next"`)
+			_, _ = w.Write([]byte(`{"repositories":["prod/team-a/app","prod/team-b/app","staging/team-a/app"]}`))
+		})
+
+		w := pull(t, proxy, "/v2/_catalog?n=2&last=base", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "prod/base", last)
+		require.JSONEq(t, `{"repositories":["team-a/app","team-b/app"]}`, w.Body.String())
+		require.Equal(t, `</v2/_catalog?last=team-b%2Fapp&n=2>; rel="next"`, w.Header().Get("Link"))
+	})
+
+	t.Run("should map scopes of token requests in the authorization container", func(t *testing.T) {
+		var scopes []string
+		var form url.Values
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes = r.URL.Query()["scope"]
+			_ = r.ParseForm()
+			form = r.PostForm
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "auth.example",
+			Authorization:        true,
+			AllowedMethods:       []string{http.MethodGet, http.MethodHead, http.MethodPost},
+			Paths:                distribution.NewPathMapping("", "prod"),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/token?service=registry&scope=repository:team-a/app:pull&scope=repository:team-b/base:pull", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []string{"repository:prod/team-a/app:pull", "repository:prod/team-b/base:pull"}, scopes)
+
+		r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=password&scope=repository%3Ateam-a%2Fapp%3Apull%2Cpush"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		proxy.HTTPServer.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "repository:prod/team-a/app:pull,push", form.Get("scope"))
+		require.Equal(t, "password", form.Get("grant_type"))
+	})
+}
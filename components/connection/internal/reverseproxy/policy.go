@@ -0,0 +1,117 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// defaultAllowedMethods make the proxy read-only
+var defaultAllowedMethods = []string{http.MethodGet, http.MethodHead}
+
+// allowedMethods returns methods clients may use
+func (cfg Config) allowedMethods() []string {
+	if len(cfg.AllowedMethods) == 0 {
+		return defaultAllowedMethods
+	}
+	return cfg.AllowedMethods
+}
+
+// enforceMethodPolicy rejects requests with methods not allowed by the configuration and returns false if it did
+func enforceMethodPolicy(cfg Config, w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger) bool {
+	if slices.Contains(cfg.allowedMethods(), r.Method) {
+		return true
+	}
+	log.Infof("Denied %s %s, allowed methods: %v", r.Method, r.URL.Path, cfg.allowedMethods())
+	metrics.ObserveDenied(r.Method, "method")
+	distribution.WriteError(w, http.StatusForbidden, distribution.ErrorCodeDenied,
+		fmt.Sprintf("method %s is not allowed by the connection", r.Method))
+	return false
+}
+
+// enforceRepositoryPolicy rejects requests for invalid repository names or repositories hidden by the configuration
+// and returns false if it did
+func enforceRepositoryPolicy(cfg Config, w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger) bool {
+	// names with . or .. segments, e.g. team/../secret, would match other patterns than the repository the registry serves
+	if !distribution.IsValidPath(r.URL.Path) {
+		log.Infof("Denied %s %s, repository name is invalid", r.Method, r.URL.Path)
+		metrics.ObserveDenied(r.Method, "name")
+		distribution.WriteError(w, http.StatusBadRequest, distribution.ErrorCodeNameInvalid, "invalid repository name")
+		return false
+	}
+	if cfg.Repositories == nil {
+		return true
+	}
+	route := distribution.ParsePath(r.URL.Path)
+	switch {
+	case route.Class == distribution.RouteCatalog:
+		// the upstream response is decompressed by the transport, so it can be filtered
+		r.Header.Del("Accept-Encoding")
+		return true
+	case route.Class == distribution.RouteBase:
+		return true
+	case route.Repository != "" && cfg.Repositories.Allows(route.Repository):
+		return true
+	}
+	log.Infof("Denied %s %s, repository is not allowed", r.Method, r.URL.Path)
+	metrics.ObserveDenied(r.Method, "repository")
+	// hidden repositories look like they don't exist
+	distribution.WriteError(w, http.StatusNotFound, distribution.ErrorCodeNameUnknown,
+		"repository name not known to registry")
+	return false
+}
+
+type catalogResponse struct {
+	Repositories []string `json:"repositories"`
+}
+
+// getCatalogResponseFunc removes repositories hidden by the filter from catalog listings
+func getCatalogResponseFunc(filter *distribution.RepositoryFilter) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK || resp.Request == nil ||
+			distribution.ParsePath(resp.Request.URL.Path).Class != distribution.RouteCatalog {
+			return nil
+		}
+		return rewriteCatalog(resp, func(repository string) (string, bool) {
+			return repository, filter.Allows(repository)
+		})
+	}
+}
+
+// rewriteCatalog replaces repositories of the catalog response with the mapped ones, repositories mapped to false are removed
+func rewriteCatalog(resp *http.Response, mapRepository func(string) (string, bool)) error {
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	catalog := catalogResponse{}
+	if err := json.Unmarshal(body, &catalog); err != nil {
+		return fmt.Errorf("couldn't decode catalog response: %w", err)
+	}
+
+	rewritten := catalogResponse{Repositories: []string{}}
+	for _, repository := range catalog.Repositories {
+		if mapped, ok := mapRepository(repository); ok {
+			rewritten.Repositories = append(rewritten.Repositories, mapped)
+		}
+	}
+	body, err = json.Marshal(rewritten)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del("Content-Encoding")
+	return nil
+}
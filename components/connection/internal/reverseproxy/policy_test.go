@@ -0,0 +1,186 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMethodPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedMethods []string
+		method         string
+		wantStatus     int
+	}{
+		{
+			name:       "should allow pull by default",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "should deny push by default",
+			method:     http.MethodPut,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:           "should allow explicitly enabled delete",
+			allowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodDelete},
+			method:         http.MethodDelete,
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "should deny push when only delete is enabled",
+			allowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodDelete},
+			method:         http.MethodPost,
+			wantStatus:     http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newFakeRegistry(t)
+			proxy, err := New(Config{
+				ConnectivityProxyURL: registry.URL,
+				TargetHost:           "target",
+				AllowedMethods:       tt.allowedMethods,
+			}, zap.NewNop().Sugar())
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			proxy.HTTPServer.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/v2/team/app/manifests/latest", nil))
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusForbidden {
+				require.Contains(t, w.Body.String(), `"code":"DENIED"`)
+				require.Equal(t, int32(0), registry.gets.Load()+registry.heads.Load())
+			}
+		})
+	}
+}
+
+func TestRepositoryPolicy(t *testing.T) {
+	filter, err := distribution.NewRepositoryFilter([]string{"team/**"}, []string{"team/secret"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "should allow repository matching allow pattern",
+			path:       "/v2/team/app/manifests/latest",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "should hide repository not matching allow pattern",
+			path:       "/v2/other/app/manifests/latest",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "should hide tags of denied repository",
+			path:       "/v2/team/secret/tags/list",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "should allow base endpoint",
+			path:       "/v2/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "should deny unknown endpoints",
+			path:       "/v2/team/app/unknown",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newFakeRegistry(t)
+			proxy, err := New(Config{
+				ConnectivityProxyURL: registry.URL,
+				TargetHost:           "target",
+				Repositories:         filter,
+			}, zap.NewNop().Sugar())
+			require.NoError(t, err)
+
+			w := pull(t, proxy.HTTPServer.Handler, tt.path, "")
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusNotFound {
+				require.Contains(t, w.Body.String(), `"code":"NAME_UNKNOWN"`)
+				require.Equal(t, int32(0), registry.gets.Load())
+			}
+		})
+	}
+
+	t.Run("should reject repository names escaping allow pattern", func(t *testing.T) {
+		filter, err := distribution.NewRepositoryFilter([]string{"allowed/**"}, []string{"denied"})
+		require.NoError(t, err)
+		for _, path := range []string{
+			"/v2/allowed/../denied/manifests/latest",
+			"/v2/allowed%2F..%2Fdenied/manifests/latest",
+			"/v2/allowed/./app/manifests/latest",
+		} {
+			registry := newFakeRegistry(t)
+			proxy, err := New(Config{
+				ConnectivityProxyURL: registry.URL,
+				TargetHost:           "target",
+				Repositories:         filter,
+			}, zap.NewNop().Sugar())
+			require.NoError(t, err)
+
+			w := pull(t, proxy.HTTPServer.Handler, path, "")
+
+			require.Equal(t, http.StatusBadRequest, w.Code, path)
+			require.Contains(t, w.Body.String(), `"code":"NAME_INVALID"`)
+			require.Equal(t, int32(0), registry.gets.Load())
+		}
+	})
+
+	t.Run("should reject invalid repository names without filter", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "target",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/../../other/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), `"code":"NAME_INVALID"`)
+		require.Equal(t, int32(0), registry.gets.Load())
+	})
+
+	t.Run("should filter catalog", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NotEqual(t, "br", r.Header.Get("Accept-Encoding"))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Link", `</v2/_catalog?last=team%2Fsecret&n=3>; rel="next"`)
+			_, _ = w.Write([]byte(`{"repositories":["other/app","team/app","team/secret"]}`))
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "target",
+			Repositories:         filter,
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodGet, "/v2/_catalog?n=3", nil)
+		r.Header.Set("Accept-Encoding", "br")
+		w := httptest.NewRecorder()
+		proxy.HTTPServer.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"repositories":["team/app"]}`, w.Body.String())
+		require.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+		require.NotEmpty(t, w.Header().Get("Link"))
+	})
+}
@@ -0,0 +1,64 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+)
+
+type probeKey struct{}
+
+// withProbe marks the request as the readiness probe, so it skips the limits, retries and the circuit breaker,
+// and isn't counted in upstream metrics
+func withProbe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, probeKey{}, true)
+}
+
+// isProbe returns true if the request is the readiness probe
+func isProbe(ctx context.Context) bool {
+	probe, _ := ctx.Value(probeKey{}).(bool)
+	return probe
+}
+
+// getProbeFunc sends the readiness probe straight to the handler of the proxy, so it's neither written
+// to the access log nor counted in request metrics, and returns the status code of the response
+func getProbeFunc(h http.Handler, registry bool) func(context.Context) (int, error) {
+	path := "/"
+	if registry {
+		path = "/v2/"
+	}
+	return func(ctx context.Context) (int, error) {
+		r, err := http.NewRequestWithContext(withProbe(ctx), http.MethodGet, path, nil)
+		if err != nil {
+			return 0, err
+		}
+		w := &probeWriter{header: http.Header{}}
+		h.ServeHTTP(w, r)
+		if w.err != nil {
+			return 0, w.err
+		}
+		return w.status, nil
+	}
+}
+
+// probeWriter records the status code of the probe response and discards its body
+type probeWriter struct {
+	header http.Header
+	status int
+	// err is set if the upstream couldn't be reached
+	err error
+}
+
+func (w *probeWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *probeWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= http.StatusOK {
+		w.status = status
+	}
+}
+
+func (w *probeWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
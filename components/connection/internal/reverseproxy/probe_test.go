@@ -0,0 +1,77 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProbe(t *testing.T) {
+	t.Run("should probe the target while the limits are saturated", func(t *testing.T) {
+		release := make(chan struct{})
+		pulls := atomic.Int32{}
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/" {
+				return
+			}
+			pulls.Add(1)
+			<-release
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			Limits:               &Limits{MaxConcurrentRequests: 1, QueueSize: 1},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		// the first pull takes the only slot, the second one fills the queue
+		wg := sync.WaitGroup{}
+		for range 2 {
+			wg.Go(func() {
+				pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+			})
+		}
+		t.Cleanup(func() {
+			close(release)
+			wg.Wait()
+		})
+		require.Eventually(t, func() bool {
+			return pulls.Load() == 1
+		}, time.Second, time.Millisecond)
+		require.Eventually(t, func() bool {
+			return pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "").Code == http.StatusTooManyRequests
+		}, time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		statusCode, err := proxy.Upstream.Probe(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, statusCode)
+	})
+
+	t.Run("should return the error if the target can't be reached", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		registry.Close()
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		start := time.Now()
+		_, err = proxy.Upstream.Probe(context.Background())
+
+		require.ErrorContains(t, err, "connection refused")
+		// the probe isn't retried
+		require.Less(t, time.Since(start), retryBackoff/2)
+	})
+}
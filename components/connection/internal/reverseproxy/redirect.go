@@ -0,0 +1,137 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+)
+
+// maxRedirects followed for a single request
+const maxRedirects = 5
+
+// forwardedRedirectHeaders are sent to redirect targets, credentials of the target registry never are
+var forwardedRedirectHeaders = []string{"Accept", "Range", "If-Range", "If-None-Match", "If-Modified-Since", "User-Agent"}
+
+// redirectRoundTripper follows redirects of pulls to hosts other than the target registry, e.g. storage backends,
+// through the Connectivity Proxy, because clients can't reach these hosts on their own
+type redirectRoundTripper struct {
+	transport http.RoundTripper
+	// redirects is used to reach redirect targets, it must tunnel https requests through the Connectivity Proxy
+	redirects  http.RoundTripper
+	proxyURL   *url.URL
+	targetHost string
+	scheme     string
+	locations  *locations
+	// tunnelsAll is true if the redirects transport reaches all hosts on its own through the Connectivity Proxy
+	tunnelsAll bool
+	log        *zap.SugaredLogger
+}
+
+func newRedirectRoundTripper(transport, redirects http.RoundTripper, proxyURL *url.URL, cfg Config, locations *locations, log *zap.SugaredLogger) *redirectRoundTripper {
+	return &redirectRoundTripper{
+		transport:  transport,
+		redirects:  redirects,
+		proxyURL:   proxyURL,
+		targetHost: cfg.TargetHost,
+		scheme:     cfg.targetScheme(),
+		locations:  locations,
+		tunnelsAll: cfg.usesSOCKS(),
+		log:        log,
+	}
+}
+
+func (t *redirectRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return resp, err
+	}
+
+	// the first request may be sent to the Connectivity Proxy, relative locations are resolved against the target
+	current := &url.URL{Scheme: t.scheme, Host: req.Host, Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+	for redirects := 0; isRedirect(resp.StatusCode); redirects++ {
+		location, err := current.Parse(resp.Header.Get("Location"))
+		if err != nil || location.Host == "" || sameHost(location, t.scheme, t.targetHost) {
+			// the client follows locations of the target registry through the proxy
+			return resp, nil
+		}
+		if redirects == maxRedirects {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDiscardedBodySize))
+		_ = resp.Body.Close()
+
+		t.log.Debugf("Following redirect of %s to %s", req.URL.Path, location.Host)
+		resp, err = t.redirects.RoundTrip(t.redirectRequest(req, location))
+		if err != nil {
+			return nil, err
+		}
+		current = location
+	}
+	// the content is the one requested by the client, e.g. a blob which can be cached
+	resp.Request = req
+	return resp, nil
+}
+
+// redirectRequest creates the request to the redirect location which the Connectivity Proxy forwards to its host
+func (t *redirectRoundTripper) redirectRequest(req *http.Request, location *url.URL) *http.Request {
+	redirect := (&http.Request{
+		Method: req.Method,
+		URL:    location,
+		Host:   location.Host,
+		Header: http.Header{},
+	}).WithContext(req.Context())
+	for _, name := range forwardedRedirectHeaders {
+		if values := req.Header.Values(name); len(values) != 0 {
+			redirect.Header[name] = values
+		}
+	}
+	if location.Scheme != "https" && !t.tunnelsAll {
+		// https requests are tunneled by the transport, plain ones are sent to the Connectivity Proxy directly
+		redirect.URL = &url.URL{Scheme: t.proxyURL.Scheme, Host: t.proxyURL.Host, Path: location.Path, RawPath: location.RawPath, RawQuery: location.RawQuery}
+		if locationID := t.locations.current(); locationID != "" {
+			redirect.Header.Set(locationHeader, locationID)
+		}
+	}
+	return redirect
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// sameHost returns true if the location points at the given host, ports are compared with defaults of their schemes
+func sameHost(location *url.URL, scheme, host string) bool {
+	return hostPort(location.Host, defaultPort(location.Scheme)) == hostPort(host, defaultPort(scheme))
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// getLocationResponseFunc makes Location headers pointing at the target registry relative, e.g. of blob uploads,
+// so clients send following requests to the proxy
+func getLocationResponseFunc(cfg Config) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return nil
+		}
+		locationURL, err := url.Parse(location)
+		if err != nil || locationURL.Host == "" || !sameHost(locationURL, cfg.targetScheme(), cfg.TargetHost) {
+			return nil
+		}
+		resp.Header.Set("Location", locationURL.RequestURI())
+		return nil
+	}
+}
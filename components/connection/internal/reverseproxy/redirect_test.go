@@ -0,0 +1,142 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newRedirectingProxy returns a Connectivity Proxy in front of a registry redirecting blobs to a storage host
+func newRedirectingProxy(t *testing.T, storageLocation string) (*httptest.Server, *atomic.Int32) {
+	storageRequests := &atomic.Int32{}
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "registry.example":
+			w.Header().Set("Location", storageLocation)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		case "storage.example":
+			storageRequests.Add(1)
+			if r.Header.Get("Authorization") != "" || r.URL.Query().Get("signature") != "abc" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.URL.Path == "/loop" {
+				http.Redirect(w, r, "http://storage.example/loop?signature=abc", http.StatusFound)
+				return
+			}
+			_, _ = w.Write([]byte(testBlob))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(cp.Close)
+	return cp, storageRequests
+}
+
+func TestRedirects(t *testing.T) {
+	newProxy := func(t *testing.T, cpURL string) http.Handler {
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cpURL,
+			TargetHost:           "registry.example",
+			AuthorizationHeader: func() string {
+				return "Basic abc"
+			},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		return proxy.HTTPServer.Handler
+	}
+
+	t.Run("should follow redirect to storage host through Connectivity Proxy", func(t *testing.T) {
+		cp, storageRequests := newRedirectingProxy(t, "http://storage.example/blobs/abc?signature=abc")
+
+		w := pull(t, newProxy(t, cp.URL), testBlobPath(), "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, testBlob, w.Body.String())
+		require.Equal(t, int32(1), storageRequests.Load())
+	})
+
+	t.Run("should return redirect to target host as relative location", func(t *testing.T) {
+		cp, _ := newRedirectingProxy(t, "http://registry.example:80/v2/team/other/blobs/abc")
+
+		w := pull(t, newProxy(t, cp.URL), testBlobPath(), "")
+
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		require.Equal(t, "/v2/team/other/blobs/abc", w.Header().Get("Location"))
+	})
+
+	t.Run("should stop following redirect loop", func(t *testing.T) {
+		cp, storageRequests := newRedirectingProxy(t, "http://storage.example/loop?signature=abc")
+
+		w := pull(t, newProxy(t, cp.URL), testBlobPath(), "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Equal(t, int32(maxRedirects), storageRequests.Load())
+	})
+}
+
+func TestLocationResponseFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		location string
+		want     string
+	}{
+		{
+			name:     "should make upload location of target relative",
+			location: "http://registry.example/v2/team/app/blobs/uploads/123?_state=abc",
+			want:     "/v2/team/app/blobs/uploads/123?_state=abc",
+		},
+		{
+			name:     "should match default port of https target",
+			scheme:   "https",
+			location: "https://registry.example:443/v2/team/app/blobs/uploads/123",
+			want:     "/v2/team/app/blobs/uploads/123",
+		},
+		{
+			name:     "should keep location of other host",
+			location: "https://storage.example/blob",
+			want:     "https://storage.example/blob",
+		},
+		{
+			name:     "should keep relative location",
+			location: "/v2/team/app/blobs/uploads/123",
+			want:     "/v2/team/app/blobs/uploads/123",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Location", tt.location)
+
+			err := getLocationResponseFunc(Config{TargetHost: "registry.example", TargetScheme: tt.scheme})(resp)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, resp.Header.Get("Location"))
+		})
+	}
+
+	t.Run("should rewrite upload location returned through proxy", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "http://registry.example/v2/team/app/blobs/uploads/123")
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(registry.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "registry.example",
+			AllowedMethods:       []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		proxy.HTTPServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v2/team/app/blobs/uploads/", nil))
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Equal(t, "/v2/team/app/blobs/uploads/123", w.Header().Get("Location"))
+	})
+}
@@ -0,0 +1,203 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAttempts of an idempotent request, including the first one
+	maxAttempts = 3
+	// maxResumes of a single interrupted response body
+	maxResumes   = 3
+	retryBackoff = 200 * time.Millisecond
+	// maxDiscardedBodySize is read from failed responses, so their connections can be reused
+	maxDiscardedBodySize = 1 << 16
+)
+
+// retryRoundTripper retries idempotent requests which failed because of the connection or a gateway error,
+// interrupted transfers of content addressed by digest are resumed with Range requests
+type retryRoundTripper struct {
+	transport http.RoundTripper
+	// breaker is nil if failures shouldn't stop requests, e.g. to hosts other than the target registry
+	breaker *circuitBreaker
+	log     *zap.SugaredLogger
+	// backoff returns the time to wait before the given retry
+	backoff func(retry int) time.Duration
+}
+
+func newRetryRoundTripper(transport http.RoundTripper, breaker *circuitBreaker, log *zap.SugaredLogger) *retryRoundTripper {
+	return &retryRoundTripper{
+		transport: transport,
+		breaker:   breaker,
+		log:       log,
+		backoff:   jitteredBackoff,
+	}
+}
+
+// jitteredBackoff doubles the wait time with every retry and randomizes it, so clients don't retry in lockstep
+func jitteredBackoff(retry int) time.Duration {
+	maxWait := retryBackoff << (retry - 1)
+	return maxWait/2 + rand.N(maxWait/2)
+}
+
+func (t *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err != nil || !resumable(req, resp) {
+		return resp, err
+	}
+	resp.Body = &resumingBody{body: resp.Body, req: req, transport: t}
+	return resp, nil
+}
+
+func (t *retryRoundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	if isProbe(req.Context()) {
+		// the probe reports the upstream as it is, it neither waits for retries nor depends on the breaker
+		return t.transport.RoundTrip(req)
+	}
+	idempotent := (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+	// the breaker counts the result of the request after all its attempts
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := t.transport.RoundTrip(req)
+		if req.Context().Err() != nil {
+			// the client went away, it says nothing about the upstream
+			t.breaker.abandon()
+			return resp, err
+		}
+		reason := retryReason(resp, err)
+		var certificateErr *tls.CertificateVerificationError
+		if reason == "" || !idempotent || attempt == maxAttempts || errors.As(err, &certificateErr) {
+			t.recordResult(idempotent, reason == "")
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDiscardedBodySize))
+			_ = resp.Body.Close()
+		}
+
+		wait := t.backoff(attempt)
+		t.log.Infof("Retrying %s %s in %s after %s (attempt %d of %d)", req.Method, req.URL.Path, wait, reason, attempt+1, maxAttempts)
+		if err := sleep(req.Context(), wait); err != nil {
+			t.breaker.abandon()
+			return nil, err
+		}
+	}
+}
+
+// recordResult counts the result of the request in the breaker, failures of requests with side effects,
+// e.g. uploads, may be caused by the request itself, so they don't count against the upstream
+func (t *retryRoundTripper) recordResult(idempotent, success bool) {
+	if success || idempotent {
+		t.breaker.record(success)
+		return
+	}
+	t.breaker.abandon()
+}
+
+// retryReason describes why the upstream request failed or returns an empty string if it didn't
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "status " + resp.Status
+	}
+	return ""
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// resumable returns true if the response content is immutable, so a transfer can be continued from another response
+func resumable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet || resp.StatusCode != http.StatusOK || req.Header.Get("Range") != "" {
+		return false
+	}
+	route := distribution.ParsePath(req.URL.Path)
+	return (route.Class == distribution.RouteBlob || route.Class == distribution.RouteManifest) && route.HasSHA256Digest()
+}
+
+// resumingBody continues reading the response from where the upstream connection broke
+type resumingBody struct {
+	body      io.ReadCloser
+	req       *http.Request
+	transport *retryRoundTripper
+	read      int64
+	resumes   int
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.read += int64(n)
+		if err == nil || errors.Is(err, io.EOF) || b.req.Context().Err() != nil || b.resumes == maxResumes {
+			return n, err
+		}
+		if resumeErr := b.resume(err); resumeErr != nil {
+			b.transport.log.Warnf("couldn't resume %s at byte %d: %v", b.req.URL.Path, b.read, resumeErr)
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces the broken body with the rest of the content
+func (b *resumingBody) resume(cause error) error {
+	b.resumes++
+	b.transport.log.Infof("Resuming %s at byte %d after %v (resume %d of %d)", b.req.URL.Path, b.read, cause, b.resumes, maxResumes)
+	_ = b.body.Close()
+	b.body = io.NopCloser(strings.NewReader(""))
+
+	req := b.req.Clone(b.req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.read))
+	resp, err := b.transport.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(b.read, 10)+"-"):
+		b.body = resp.Body
+	case resp.StatusCode == http.StatusOK:
+		// the registry doesn't support ranges, skip what was already read
+		if _, err := io.CopyN(io.Discard, resp.Body, b.read); err != nil {
+			_ = resp.Body.Close()
+			return err
+		}
+		b.body = resp.Body
+	default:
+		_ = resp.Body.Close()
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
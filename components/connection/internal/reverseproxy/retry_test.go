@@ -0,0 +1,311 @@
+package reverseproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newFlakyRegistry returns a registry which fails the first requests with the given status or by closing the connection
+func newFlakyRegistry(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > failures {
+			_, _ = w.Write([]byte(testBlob))
+			return
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		_ = conn.Close()
+	}))
+	t.Cleanup(registry.Close)
+	return registry, requests
+}
+
+func newTestRetryRoundTripper() *retryRoundTripper {
+	log := zap.NewNop().Sugar()
+	rt := newRetryRoundTripper(http.DefaultTransport, newCircuitBreaker(log), log)
+	rt.backoff = func(int) time.Duration {
+		return 0
+	}
+	return rt
+}
+
+func send(t *testing.T, rt http.RoundTripper, method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	if resp != nil {
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+	}
+	return resp, err
+}
+
+func TestRetryRoundTripper(t *testing.T) {
+	t.Run("should retry idempotent request after connection reset", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 2, 0)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("should retry gateway errors", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 1, http.StatusServiceUnavailable)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodHead, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("should give up after max attempts", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 10, http.StatusBadGateway)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		require.Equal(t, int32(maxAttempts), requests.Load())
+	})
+
+	t.Run("should not retry other methods", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 1, http.StatusServiceUnavailable)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodPost, registry.URL+"/v2/team/app/blobs/uploads/")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("should not retry client errors", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 1, http.StatusNotFound)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.Equal(t, int32(1), requests.Load())
+	})
+}
+
+// newInterruptingRegistry returns a registry which breaks the transfer of the blob in the middle
+// until the given number of interruptions happened
+func newInterruptingRegistry(t *testing.T, content string, interruptions int32, supportsRange bool) (*httptest.Server, *atomic.Int32) {
+	interrupted := &atomic.Int32{}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if rangeHeader := r.Header.Get("Range"); supportsRange && rangeHeader != "" {
+			start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+		rest := content[start:]
+		if interrupted.Load() < interruptions {
+			interrupted.Add(1)
+			_, _ = w.Write([]byte(rest[:len(rest)/2]))
+			_ = http.NewResponseController(w).Flush()
+			conn, _, _ := http.NewResponseController(w).Hijack()
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte(rest))
+	}))
+	t.Cleanup(registry.Close)
+	return registry, interrupted
+}
+
+func blobPath(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "/v2/team/app/blobs/sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestResumingBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	t.Run("should resume interrupted blob with range request", func(t *testing.T) {
+		registry, interrupted := newInterruptingRegistry(t, content, 2, true)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+blobPath(content))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+
+		require.NoError(t, err)
+		require.Equal(t, content, string(body))
+		require.Equal(t, int32(2), interrupted.Load())
+	})
+
+	t.Run("should skip read content when registry doesn't support ranges", func(t *testing.T) {
+		registry, _ := newInterruptingRegistry(t, content, 1, false)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+blobPath(content))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+
+		require.NoError(t, err)
+		require.Equal(t, content, string(body))
+	})
+
+	t.Run("should fail after max resumes", func(t *testing.T) {
+		registry, _ := newInterruptingRegistry(t, content, maxResumes+1, true)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+blobPath(content))
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+
+		require.Error(t, err)
+	})
+
+	t.Run("should not resume mutable content", func(t *testing.T) {
+		registry, _ := newInterruptingRegistry(t, content, 1, true)
+
+		resp, err := send(t, newTestRetryRoundTripper(), http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+
+		require.Error(t, err)
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	newTestBreaker := func(now *time.Time) *circuitBreaker {
+		b := newCircuitBreaker(zap.NewNop().Sugar())
+		b.now = func() time.Time {
+			return *now
+		}
+		return b
+	}
+
+	t.Run("should open after consecutive failures", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+
+		for range breakerThreshold {
+			require.True(t, b.allow())
+			b.record(false)
+		}
+
+		require.False(t, b.allow())
+		require.Error(t, b.readiness())
+	})
+
+	t.Run("should reset failures after success", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+
+		for range breakerThreshold - 1 {
+			b.record(false)
+		}
+		b.record(true)
+		b.record(false)
+
+		require.True(t, b.allow())
+		require.NoError(t, b.readiness())
+	})
+
+	t.Run("should let single trial request through after cooldown", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+		for range breakerThreshold {
+			b.record(false)
+		}
+
+		now = now.Add(breakerCooldown)
+
+		require.NoError(t, b.readiness())
+		require.True(t, b.allow())
+		require.False(t, b.allow())
+		b.record(true)
+		require.True(t, b.allow())
+	})
+
+	t.Run("should open again when trial request fails", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+		for range breakerThreshold {
+			b.record(false)
+		}
+		now = now.Add(breakerCooldown)
+
+		require.True(t, b.allow())
+		b.record(false)
+
+		require.False(t, b.allow())
+		require.Error(t, b.readiness())
+	})
+
+	t.Run("should reject requests without reaching upstream when open", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 100, http.StatusBadGateway)
+		rt := newTestRetryRoundTripper()
+		for range breakerThreshold {
+			rt.breaker.record(false)
+		}
+
+		_, err := send(t, rt, http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.ErrorIs(t, err, errCircuitOpen)
+		require.Equal(t, int32(0), requests.Load())
+	})
+	t.Run("should count a request failing all attempts once", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 100, http.StatusBadGateway)
+		rt := newTestRetryRoundTripper()
+
+		for range breakerThreshold - 1 {
+			resp, err := send(t, rt, http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		}
+
+		require.Equal(t, int32((breakerThreshold-1)*maxAttempts), requests.Load())
+		require.NoError(t, rt.breaker.readiness())
+		require.Equal(t, breakerThreshold-1, rt.breaker.failures)
+	})
+
+	t.Run("should not count failures of requests with side effects", func(t *testing.T) {
+		registry, _ := newFlakyRegistry(t, 100, http.StatusBadGateway)
+		rt := newTestRetryRoundTripper()
+
+		for range breakerThreshold {
+			_, err := send(t, rt, http.MethodPost, registry.URL+"/v2/team/app/blobs/uploads/")
+			require.NoError(t, err)
+		}
+
+		require.Zero(t, rt.breaker.failures)
+		require.True(t, rt.breaker.allow())
+	})
+
+	t.Run("should retry without a breaker", func(t *testing.T) {
+		registry, requests := newFlakyRegistry(t, 1, http.StatusBadGateway)
+		rt := newTestRetryRoundTripper()
+		rt.breaker = nil
+
+		resp, err := send(t, rt, http.MethodGet, registry.URL+"/v2/team/app/manifests/latest")
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(2), requests.Load())
+	})
+}
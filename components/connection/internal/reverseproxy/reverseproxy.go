@@ -1,38 +1,59 @@
 package reverseproxy
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/kyma-project/registry-proxy/components/connection/internal/blobcache"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+	"github.com/kyma-project/registry-proxy/components/connection/internal/metrics"
 	"github.com/kyma-project/registry-proxy/components/connection/internal/server"
 
 	"go.uber.org/zap"
 )
 
 type logRoundTripper struct {
-	log       *zap.SugaredLogger
-	transport http.RoundTripper
+	log        *zap.SugaredLogger
+	transport  http.RoundTripper
+	routeClass func(*http.Request) distribution.RouteClass
+	contact    *lastContact
+	// secretHeaders are redacted in logs
+	secretHeaders []string
 }
 
 func (lrt *logRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	filteredReqHeaders := getRedactedHeaders(req.Header)
+	filteredReqHeaders := getRedactedHeaders(req.Header, lrt.secretHeaders)
 	lrt.log.Debugf("Request: %s %s %s %v\n", req.Proto, req.Host, req.URL, filteredReqHeaders)
+	start := time.Now()
 	res, err := lrt.transport.RoundTrip(req)
+	if res != nil && lrt.contact != nil {
+		lrt.contact.touch()
+	}
+	if !isProbe(req.Context()) {
+		metrics.ObserveUpstream(req.Method, lrt.routeClass(req), start, res, err)
+	}
+	if rec := accessRecordFrom(req.Context()); rec != nil {
+		rec.addUpstreamLatency(time.Since(start))
+	}
 	if err != nil {
 		lrt.log.Errorf("Error: %v\n", err)
 		return res, err
 	}
 	lrt.log.Debugf("Response status: %s\n", res.Status)
-	filteredRespHeaders := getRedactedHeaders(res.Header)
+	filteredRespHeaders := getRedactedHeaders(res.Header, lrt.secretHeaders)
 	lrt.log.Debugf("Response headers: %v", filteredRespHeaders)
 	return res, err
 }
 
-// getRedactedHeaders returns a copy of the provided headers with sensitive information redacted
-func getRedactedHeaders(headers http.Header) http.Header {
+// getRedactedHeaders returns a copy of the provided headers with values of the given secret headers redacted
+func getRedactedHeaders(headers http.Header, secretHeaders []string) http.Header {
 	filteredHeaders := make(map[string][]string)
 	for key, values := range headers {
 		if filteredHeaders[key] == nil {
@@ -40,7 +61,7 @@ func getRedactedHeaders(headers http.Header) http.Header {
 		}
 		for _, v := range values {
 			filteredValue := v
-			if key == "Authorization" || key == "Proxy-Authorization" {
+			if slices.Contains(secretHeaders, key) {
 				filteredValue = "***"
 			}
 			filteredHeaders[key] = append(filteredHeaders[key], filteredValue)
@@ -49,64 +70,314 @@ func getRedactedHeaders(headers http.Header) http.Header {
 	return filteredHeaders
 }
 
-// getModifyResponseFunc replaces host of the WWW-Authenticate header with localhost:authPort
+// getModifyResponseFunc replaces host of the realm in WWW-Authenticate headers with localhost:authPort
 // example header: Www-Authenticate: Bearer realm="http://gitlab.kyma/jwt/auth",service="container_registry"\r\n
-func getModifyResponseFunc(authPort string) func(*http.Response) error {
+func getModifyResponseFunc(authPort string, log *zap.SugaredLogger) func(*http.Response) error {
 	return func(resp *http.Response) error {
-		authenticateHeader := resp.Header.Get("WWW-Authenticate")
-		if authenticateHeader != "" {
-			wwwAuthHeader := ParseAuthSettings(authenticateHeader)
-			originalDestination := wwwAuthHeader.Params["realm"]
-			originalDestinationURL, err := url.Parse(originalDestination)
+		values := resp.Header.Values("WWW-Authenticate")
+		for i, value := range values {
+			rewritten, err := rewriteRealmHost(value, fmt.Sprintf("localhost:%s", authPort))
 			if err != nil {
-				return err
+				// the client gets the challenge as it is, which is better than no challenge at all
+				log.Warnf("couldn't rewrite realm of WWW-Authenticate header %q: %v", value, err)
+				continue
 			}
-			newDestination := fmt.Sprintf("localhost:%s", authPort)
-			authenticateHeader = strings.Replace(authenticateHeader, originalDestinationURL.Host, newDestination, 1)
-			resp.Header.Set("WWW-Authenticate", authenticateHeader)
+			values[i] = rewritten
 		}
 		return nil
 	}
 }
 
-func handler(p *httputil.ReverseProxy, targetHost, locationID, authHeader string, log *zap.SugaredLogger) func(http.ResponseWriter, *http.Request) {
-	log.Infof("Registering handler to %s\n", targetHost)
+// rewriteRealmHost points realms of all challenges to the given host, the rest of the header stays as it was sent
+func rewriteRealmHost(header, host string) (string, error) {
+	_, spans, err := parseChallenges(header)
+	if err != nil {
+		return "", err
+	}
+	b := strings.Builder{}
+	last := 0
+	for _, span := range spans {
+		if !strings.EqualFold(span.name, "realm") || span.value == "" {
+			continue
+		}
+		realmURL, err := url.Parse(span.value)
+		if err != nil {
+			return "", fmt.Errorf("invalid realm %q: %w", span.value, err)
+		}
+		if realmURL.Host == "" {
+			// e.g. realm="registry" of basic challenges isn't a location
+			continue
+		}
+		if realmURL.Scheme == "https" {
+			// the authorization container serves plain http, even if the authorization host uses https
+			realmURL.Scheme = "http"
+		}
+		realmURL.Host = host
+		b.WriteString(header[last:span.start])
+		b.WriteString(quote(realmURL.String()))
+		last = span.end
+	}
+	b.WriteString(header[last:])
+	return b.String(), nil
+}
+
+func handler(p *httputil.ReverseProxy, c *coalescer, cfg Config, locations *locations, log *zap.SugaredLogger) func(http.ResponseWriter, *http.Request) {
+	log.Infof("Registering handler to %s\n", cfg.TargetHost)
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Debugf("Asking for %s %s %s\n", r.Proto, targetHost, r.URL)
-		r.Host = targetHost
-		r.Header.Set("X-Forwarded-Host", targetHost)
+		log.Debugf("Asking for %s %s %s\n", r.Proto, cfg.TargetHost, r.URL)
+		if !enforceMethodPolicy(cfg, w, r, log) || !enforceRepositoryPolicy(cfg, w, r, log) {
+			return
+		}
+
+		r.Host = cfg.TargetHost
+		r.Header.Set("X-Forwarded-Host", cfg.TargetHost)
 
-		if locationID != "" {
-			r.Header.Set("SAP-Connectivity-SCC-Location_ID", locationID)
+		if locationID := locations.current(); locationID != "" && !cfg.usesSOCKS() {
+			// the SOCKS5 endpoint gets the location ID during authentication, the header would reach the target
+			r.Header.Set(locationHeader, locationID)
 		}
 
-		if authHeader != "" {
-			r.Header.Set("Authorization", authHeader)
+		if cfg.AuthorizationHeader != nil {
+			if authorizationHeader := cfg.AuthorizationHeader(); authorizationHeader != "" {
+				r.Header.Set("Authorization", authorizationHeader)
+			}
+		}
+
+		if cfg.Credentials != nil {
+			// the proxy logs in on its own, credentials of the client are not used
+			r.Header.Del("Authorization")
+		}
+
+		applyRequestHeaders(cfg, r)
+
+		if cfg.Authorization && cfg.Paths != nil {
+			if err := mapTokenScopes(cfg.Paths, r); err != nil {
+				log.Warnf("couldn't map scopes of token request: %v", err)
+				http.Error(w, "invalid token request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if cfg.BlobCache != nil && serveCachedBlob(p, cfg, w, r, log) {
+			return
+		}
+
+		if c.serve(w, r) {
+			return
 		}
 
 		p.ServeHTTP(w, r)
 	}
 }
 
+// chainModifyResponse runs given functions one by one and stops on the first error
+func chainModifyResponse(modifiers ...func(*http.Response) error) func(*http.Response) error {
+	if len(modifiers) == 0 {
+		return nil
+	}
+	return func(resp *http.Response) error {
+		for _, modify := range modifiers {
+			if err := modify(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Config holds the settings of the reverse proxy
+type Config struct {
+	// Address the reverse proxy server binds to
+	Address string
+	// ConnectivityProxyURL is the URL of the Connectivity Proxy all requests are forwarded to
+	ConnectivityProxyURL string
+	// TargetHost is the host of the target registry
+	TargetHost string
+	// LocationID of the Cloud Connector, optional
+	LocationID string
+	// FailoverLocationIDs are used in order after tunnel errors of the Cloud Connector of LocationID, optional
+	FailoverLocationIDs []string
+	// LocationFailback is how long failover locations are used before LocationID is tried again, 5 minutes by default
+	LocationFailback time.Duration
+	// AuthorizationPort is the node port of the authorization container, optional
+	AuthorizationPort string
+	// AuthorizationHeader returns the current header set on every forwarded request, optional
+	AuthorizationHeader func() string
+	// BlobCache serves blobs pulled before without reaching the target registry, optional
+	BlobCache *blobcache.Cache
+	// Authorization is true if the target host is the authorization server of the registry
+	Authorization bool
+	// AllowedMethods clients may use, only GET and HEAD are allowed if empty
+	AllowedMethods []string
+	// Credentials returns the current credentials used to answer authentication challenges of the target registry, optional
+	Credentials func() Credentials
+	// CredentialsDigest returns the digest of the Secret AuthorizationHeader or Credentials are read from, optional
+	CredentialsDigest func() string
+	// TargetScheme is the scheme of the target registry, https targets are reached through CONNECT tunnels, http by default
+	TargetScheme string
+	// TargetServerName overrides the name used to verify the certificate of the target host, optional
+	TargetServerName string
+	// RootCAs returns the current authorities trusted by https connections, system ones are used if nil
+	RootCAs func() *x509.CertPool
+	// Repositories limits which repositories clients may use and see in the catalog, all are available if nil
+	Repositories *distribution.RepositoryFilter
+	// AccessLog writes an entry for every request at info level if set, optional
+	AccessLog *AccessLog
+	// Headers are set on every forwarded request after StripRequestHeaders are removed, optional
+	Headers []Header
+	// StripRequestHeaders are removed from forwarded requests, optional
+	StripRequestHeaders []string
+	// StripResponseHeaders are removed from responses sent to clients, optional
+	StripResponseHeaders []string
+	// Limits queue requests to the target registry and reject them when too many are waiting, optional
+	Limits *Limits
+	// SpoolLimit is the number of bytes of transfers shared by many clients spooled to the temporary directory
+	// at the same time, it must fit into the directory. Without it, a transfer is streamed to a single client.
+	SpoolLimit int64
+	// Paths maps paths and repositories of clients to the ones of the target registry, optional
+	Paths *distribution.PathMapping
+}
+
+// injectsCredentials returns true if the proxy authorizes requests on its own instead of forwarding client credentials
+func (cfg Config) injectsCredentials() bool {
+	return cfg.AuthorizationHeader != nil || cfg.Credentials != nil
+}
+
+// targetScheme returns the scheme of the target registry
+func (cfg Config) targetScheme() string {
+	if cfg.usesTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// usesTLS returns true if the target registry is reached with https
+func (cfg Config) usesTLS() bool {
+	return cfg.TargetScheme == "https"
+}
+
+// usesSOCKS returns true if all hosts are reached through the SOCKS5 endpoint of the Connectivity Proxy
+func (cfg Config) usesSOCKS() bool {
+	return strings.HasPrefix(cfg.ConnectivityProxyURL, "socks5://")
+}
+
+// routeClass returns the route class of the request used in metrics
+func (cfg Config) routeClass(r *http.Request) distribution.RouteClass {
+	if cfg.Authorization || isTokenRequest(r) {
+		return distribution.RouteToken
+	}
+	return distribution.ParsePath(r.URL.Path).Class
+}
+
 // New creates a new reverse proxy server
-func New(reverseProxyURL, connectivityProxyURL, targetHost, locationID, authPort, authorizationHeader string, log *zap.SugaredLogger) (*server.Server, error) {
-	remote, err := url.Parse(connectivityProxyURL)
+func New(cfg Config, log *zap.SugaredLogger) (*server.Server, error) {
+	remote, err := url.Parse(cfg.ConnectivityProxyURL)
 	if err != nil {
 		return nil, err
 	}
 
+	locations := newLocations(cfg, log)
+	if len(cfg.FailoverLocationIDs) != 0 {
+		log.Infof("Setting up failover from location %q to %q", cfg.LocationID, cfg.FailoverLocationIDs)
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(remote)
-	proxy.Transport = &logRoundTripper{log: log, transport: http.DefaultTransport}
+	// every attempt to reach the target registry is a client span, the trace context is propagated to the registry
+	tunnel := newTLSTransport(remote, cfg, locations)
+	if cfg.usesSOCKS() {
+		log.Infof("Setting up SOCKS5 connections through %s", remote.Host)
+		tunnel = newSOCKSTransport(remote, cfg, locations)
+	}
+	// tunnels are bound to the location they were opened through
+	locations.onSwitch = append(locations.onSwitch, tunnel.CloseIdleConnections)
+	locations.check = newLocationCheck(remote, cfg, locations)
+	tunnelTransport := newTracingTransport(tunnel, cfg.routeClass)
+	transport := newTracingTransport(http.DefaultTransport, cfg.routeClass)
+	if cfg.usesTLS() || cfg.usesSOCKS() {
+		log.Infof("Setting up %s connections to %s", cfg.targetScheme(), cfg.TargetHost)
+		// requests are sent to the target host, the transport reaches it through the Connectivity Proxy
+		transport = tunnelTransport
+		director := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			director(r)
+			r.URL.Scheme = cfg.targetScheme()
+			r.URL.Host = cfg.TargetHost
+		}
+	}
+	if cfg.Paths != nil && !cfg.Authorization {
+		log.Infof("Setting up mapping to base path %q and repository prefix %q", cfg.Paths.BasePath(), cfg.Paths.RepositoryPrefix())
+		transport = &pathRoundTripper{transport: transport, paths: cfg.Paths, targetHost: cfg.TargetHost, scheme: cfg.targetScheme()}
+	}
+	breaker := newCircuitBreaker(log)
+	// retries after tunnel errors are sent to the next location
+	transport = newRetryRoundTripper(&locationRoundTripper{transport: transport, locations: locations}, breaker, log)
+	contact := &lastContact{}
+	secretHeaders := cfg.secretHeaders()
+	proxy.Transport = &logRoundTripper{log: log, transport: transport, routeClass: cfg.routeClass, contact: contact, secretHeaders: secretHeaders}
+	// failures of other hosts, e.g. storage of blobs, don't open the circuit of the target registry
+	redirects := &logRoundTripper{log: log, transport: newRetryRoundTripper(&locationRoundTripper{transport: tunnelTransport, locations: locations}, nil, log), routeClass: cfg.routeClass, contact: contact, secretHeaders: secretHeaders}
+	if cfg.Credentials != nil {
+		log.Infof("Setting up registry login as %q", cfg.Credentials().Username)
+		tokenTransport := newTokenRoundTripper(proxy.Transport, remote, locations, cfg.Credentials, log)
+		tokenTransport.tunnelsTLS = cfg.usesTLS()
+		tokenTransport.tunnelsAll = cfg.usesSOCKS()
+		proxy.Transport = tokenTransport
+	}
+	proxy.Transport = newRedirectRoundTripper(proxy.Transport, redirects, remote, cfg, locations, log)
 	proxy.ErrorLog = zap.NewStdLog(log.Desugar())
+	proxy.ErrorHandler = getErrorHandler(log)
+
+	modifiers := []func(*http.Response) error{getLocationResponseFunc(cfg)}
+	if cfg.Limits != nil {
+		log.Infof("Setting up limits of %d concurrent requests, %g requests and %d bytes per second, queue of %d requests",
+			cfg.Limits.MaxConcurrentRequests, cfg.Limits.RequestsPerSecond, cfg.Limits.BytesPerSecond, cfg.Limits.QueueSize)
+		limiter := newLimiter(*cfg.Limits)
+		// a logical request takes one slot, including its token requests, redirects and retries
+		proxy.Transport = &limitRoundTripper{transport: proxy.Transport, limiter: limiter}
+		if limiter.bytes != nil {
+			modifiers = append(modifiers, getBandwidthResponseFunc(limiter))
+		}
+	}
+	if cfg.AuthorizationPort != "" {
+		log.Infof("Setting up authorization host to localhost:%s", cfg.AuthorizationPort)
+		modifiers = append(modifiers, getModifyResponseFunc(cfg.AuthorizationPort, log))
+	}
+	if cfg.Paths.RepositoryPrefix() != "" && !cfg.Authorization {
+		modifiers = append(modifiers, getScopeResponseFunc(cfg.Paths))
+	}
+	if cfg.BlobCache != nil {
+		log.Infof("Setting up blob cache of %d bytes", cfg.BlobCache.MaxSize())
+		modifiers = append(modifiers, getCacheResponseFunc(cfg.BlobCache, log))
+	}
+	if cfg.Repositories != nil {
+		log.Info("Setting up repository filter")
+		modifiers = append(modifiers, getCatalogResponseFunc(cfg.Repositories))
+	}
+	if len(cfg.StripResponseHeaders) != 0 {
+		log.Infof("Setting up stripping of response headers %v", cfg.StripResponseHeaders)
+		modifiers = append(modifiers, getStripResponseFunc(cfg.StripResponseHeaders))
+	}
+	proxy.ModifyResponse = chainModifyResponse(modifiers...)
 
-	if authPort != "" {
-		log.Infof("Setting up authorization host to localhost:%s", authPort)
-		proxy.ModifyResponse = getModifyResponseFunc(authPort)
+	var proxyHandler http.Handler = http.HandlerFunc(handler(proxy, newCoalescer(proxy, os.TempDir(), cfg.SpoolLimit, log), cfg, locations, log))
+	probe := getProbeFunc(proxyHandler, !cfg.Authorization)
+	if cfg.AccessLog != nil {
+		log.Infof("Setting up access log limited to %g entries per second", cfg.AccessLog.RateLimit)
+		proxyHandler = newAccessLogger(*cfg.AccessLog, locations, cfg.routeClass, cfg.secretHeaders(), log).wrap(proxyHandler)
 	}
 
 	httpServer := &http.Server{
-		Addr:    reverseProxyURL,
-		Handler: http.HandlerFunc(handler(proxy, targetHost, locationID, authorizationHeader, log)),
+		Addr:    cfg.Address,
+		Handler: newTracingHandler(metrics.InstrumentHandler(proxyHandler, cfg.routeClass), cfg.routeClass),
+	}
+	upstream := &server.Upstream{
+		Registry:           !cfg.Authorization,
+		InjectsCredentials: cfg.injectsCredentials(),
+		LastContact:        contact.get,
+		LocationID:         locations.activeID,
+		Config:             cfg.redacted,
+		CredentialsDigest:  cfg.CredentialsDigest,
+		Probe:              probe,
 	}
-	return &server.Server{HTTPServer: httpServer, Log: log}, nil
+	return &server.Server{HTTPServer: httpServer, Log: log, Readiness: breaker.readiness, Upstream: upstream}, nil
 }
@@ -0,0 +1,192 @@
+package reverseproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion = 0x05
+	// socksNoAuth is the method of proxies not requiring authentication
+	socksNoAuth = 0x00
+	// socksLocationAuth is the method of the Connectivity Proxy carrying the location ID of the Cloud Connector
+	socksLocationAuth = 0x80
+	// socksLocationAuthVersion is the version of the sub-negotiation of socksLocationAuth
+	socksLocationAuthVersion = 0x01
+	socksConnect             = 0x01
+	socksIPv4                = 0x01
+	socksDomainName          = 0x03
+	socksIPv6                = 0x04
+	socksSucceeded           = 0x00
+	socksGeneralFailure      = 0x01
+	socksNetworkUnreachable  = 0x03
+)
+
+// socksReplies describes reply codes of RFC 1928
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// newSOCKSTransport returns a transport reaching all hosts through the SOCKS5 endpoint of the Connectivity Proxy,
+// https requests are sent over TLS established inside the SOCKS5 connection
+func newSOCKSTransport(proxyURL *url.URL, cfg Config, locations *locations) *http.Transport {
+	d := &tunnelDialer{
+		dialer:     &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		proxyAddr:  hostPort(proxyURL.Host, "1080"),
+		locations:  locations,
+		targetHost: hostname(cfg.TargetHost),
+		serverName: cfg.TargetServerName,
+		rootCAs:    cfg.RootCAs,
+	}
+	d.open = d.socksConnect
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = d.dial
+	transport.DialTLSContext = d.dialTLS
+	return transport
+}
+
+// socksConnect asks the SOCKS5 endpoint of the Connectivity Proxy to connect to the given address
+func (d *tunnelDialer) socksConnect(ctx context.Context, conn net.Conn, addr string) error {
+	// unblock reading the reply when the request is canceled
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	locationID := d.locations.dialLocation(ctx)
+	if err := socksAuthenticate(conn, locationID); err != nil {
+		d.locations.fail(locationID, err.Error())
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port of %s: %w", addr, err)
+	}
+	if len(host) > 255 {
+		return fmt.Errorf("host %s is too long for SOCKS5", host)
+	}
+	// the Connectivity Proxy resolves the host, it's usually a virtual host of the Cloud Connector
+	req := []byte{socksVersion, socksConnect, 0x00, socksDomainName, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(portNumber))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("couldn't send SOCKS5 connect request for %s: %w", addr, err)
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("couldn't read SOCKS5 connect reply for %s: %w", addr, err)
+	}
+	if reply[1] != socksSucceeded {
+		err := fmt.Errorf("SOCKS5 connect to %s failed: %s", addr, socksReply(reply[1]))
+		if reply[1] == socksGeneralFailure || reply[1] == socksNetworkUnreachable {
+			// the Cloud Connector of the location can't be reached, other replies are about the target
+			d.locations.fail(locationID, err.Error())
+		}
+		return err
+	}
+	if err := discardBoundAddress(conn, reply[3]); err != nil {
+		return fmt.Errorf("couldn't read SOCKS5 connect reply for %s: %w", addr, err)
+	}
+	if !stop() {
+		// the deadline is set or about to be set
+		return ctx.Err()
+	}
+	return nil
+}
+
+// socksAuthenticate negotiates the authentication method, the location ID can only be passed with socksLocationAuth
+func socksAuthenticate(conn net.Conn, locationID string) error {
+	methods := []byte{socksLocationAuth}
+	if locationID == "" {
+		methods = []byte{socksNoAuth, socksLocationAuth}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("couldn't send SOCKS5 greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("couldn't read SOCKS5 greeting reply: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+		return nil
+	case socksLocationAuth:
+		// the Connectivity Proxy in the cluster authenticates to the Cloud Connector on its own, so the JWT is empty
+		encoded := base64.StdEncoding.EncodeToString([]byte(locationID))
+		if len(encoded) > 255 {
+			return errors.New("location ID is too long for SOCKS5")
+		}
+		req := []byte{socksLocationAuthVersion}
+		req = binary.BigEndian.AppendUint32(req, 0)
+		req = append(req, byte(len(encoded)))
+		req = append(req, encoded...)
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("couldn't send SOCKS5 authentication: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("couldn't read SOCKS5 authentication reply: %w", err)
+		}
+		if reply[1] != socksSucceeded {
+			return fmt.Errorf("SOCKS5 authentication with location ID %q failed with status %d", locationID, reply[1])
+		}
+		return nil
+	default:
+		return fmt.Errorf("SOCKS5 proxy accepts none of the offered authentication methods %v", methods)
+	}
+}
+
+// discardBoundAddress reads the address the proxy bound for the connection, which isn't needed
+func discardBoundAddress(conn net.Conn, addressType byte) error {
+	var length int
+	switch addressType {
+	case socksIPv4:
+		length = net.IPv4len
+	case socksIPv6:
+		length = net.IPv6len
+	case socksDomainName:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		length = int(size[0])
+	default:
+		return fmt.Errorf("unknown address type %d", addressType)
+	}
+	// the address is followed by the port
+	_, err := io.CopyN(io.Discard, conn, int64(length+2))
+	return err
+}
+
+func socksReply(code byte) string {
+	if reply, ok := socksReplies[code]; ok {
+		return reply
+	}
+	return fmt.Sprintf("unknown reply %d", code)
+}
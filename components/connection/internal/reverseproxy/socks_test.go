@@ -0,0 +1,184 @@
+package reverseproxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeSOCKSProxy connects SOCKS5 clients to the given server, whatever address is requested
+type fakeSOCKSProxy struct {
+	listener net.Listener
+	// reply is the reply code of connect requests
+	reply byte
+
+	mu sync.Mutex
+	// locationID is the location ID of the last authentication, decoded
+	locationID string
+	// lastAddr is the address of the last connect request
+	lastAddr string
+}
+
+func newFakeSOCKSProxy(t *testing.T, target *httptest.Server) *fakeSOCKSProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &fakeSOCKSProxy{listener: listener}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn, target.Listener.Addr().String())
+		}
+	}()
+	return p
+}
+
+func (p *fakeSOCKSProxy) URL() string {
+	return "socks5://" + p.listener.Addr().String()
+}
+
+func (p *fakeSOCKSProxy) serve(conn net.Conn, target string) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte{socksVersion, socksLocationAuth})
+
+	// version, JWT length, JWT, location ID length, location ID
+	auth := make([]byte, 5)
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(auth[1:]))); err != nil {
+		return
+	}
+	size := make([]byte, 1)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return
+	}
+	encoded := make([]byte, size[0])
+	if _, err := io.ReadFull(conn, encoded); err != nil {
+		return
+	}
+	locationID, _ := base64.StdEncoding.DecodeString(string(encoded))
+	_, _ = conn.Write([]byte{socksLocationAuthVersion, socksSucceeded})
+
+	// version, command, reserved, domain name type, host length, host, port
+	req := make([]byte, 5)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	host := make([]byte, int(req[4])+2)
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.locationID = string(locationID)
+	p.lastAddr = net.JoinHostPort(string(host[:req[4]]), strconv.Itoa(int(binary.BigEndian.Uint16(host[req[4]:]))))
+	p.mu.Unlock()
+
+	_, _ = conn.Write([]byte{socksVersion, p.reply, 0x00, socksIPv4, 127, 0, 0, 1, 0, 0})
+	if p.reply != socksSucceeded {
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (p *fakeSOCKSProxy) last() (locationID, addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.locationID, p.lastAddr
+}
+
+func TestSOCKSTarget(t *testing.T) {
+	t.Run("should reach http target through SOCKS5 with location ID", func(t *testing.T) {
+		var header http.Header
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			_, _ = w.Write([]byte(r.Host))
+		}))
+		t.Cleanup(registry.Close)
+		cp := newFakeSOCKSProxy(t, registry)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL(),
+			TargetHost:           "registry.example:5000",
+			LocationID:           "location",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "registry.example:5000", w.Body.String())
+		locationID, addr := cp.last()
+		require.Equal(t, "location", locationID)
+		require.Equal(t, "registry.example:5000", addr)
+		// the location ID isn't sent to the target
+		require.Empty(t, header.Get("SAP-Connectivity-SCC-Location_ID"))
+	})
+
+	t.Run("should pass TLS to https target through SOCKS5", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeSOCKSProxy(t, registry)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL(),
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+			TargetServerName:     "example.com",
+			RootCAs:              trusting(registry),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "registry.example", w.Body.String())
+		_, addr := cp.last()
+		require.Equal(t, "registry.example:443", addr)
+	})
+
+	t.Run("should fail when connect is refused", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(registry.Close)
+		cp := newFakeSOCKSProxy(t, registry)
+		cp.reply = 0x04
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL(),
+			TargetHost:           "registry.example",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
@@ -0,0 +1,112 @@
+package reverseproxy
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// lastContact remembers when the upstream answered a request last time
+type lastContact struct {
+	unixNano atomic.Int64
+}
+
+func (c *lastContact) touch() {
+	c.unixNano.Store(time.Now().UnixNano())
+}
+
+// get returns when the upstream answered last time, zero if it never did
+func (c *lastContact) get() time.Time {
+	unixNano := c.unixNano.Load()
+	if unixNano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, unixNano)
+}
+
+// redactedConfig is the configuration reported by the status endpoint, secrets are never part of it
+type redactedConfig struct {
+	TargetHost           string          `json:"targetHost"`
+	TargetScheme         string          `json:"targetScheme"`
+	TargetServerName     string          `json:"targetServerName,omitempty"`
+	LocationID           string          `json:"locationID,omitempty"`
+	FailoverLocationIDs  []string        `json:"failoverLocationIDs,omitempty"`
+	ConnectivityProxyURL string          `json:"connectivityProxyURL"`
+	Authorization        bool            `json:"authorization"`
+	AuthorizationPort    string          `json:"authorizationPort,omitempty"`
+	AuthorizationHeader  bool            `json:"authorizationHeader"`
+	Username             string          `json:"username,omitempty"`
+	CustomCA             bool            `json:"customCA"`
+	AllowedMethods       []string        `json:"allowedMethods"`
+	RepositoryAllow      []string        `json:"repositoryAllow,omitempty"`
+	RepositoryDeny       []string        `json:"repositoryDeny,omitempty"`
+	BlobCacheMaxSize     int64           `json:"blobCacheMaxSize,omitempty"`
+	AccessLogRateLimit   float64         `json:"accessLogRateLimit,omitempty"`
+	Limits               *redactedLimits `json:"limits,omitempty"`
+	TargetBasePath       string          `json:"targetBasePath,omitempty"`
+	RepositoryPrefix     string          `json:"repositoryPrefix,omitempty"`
+	// AddedHeaders are names of headers set on forwarded requests, their values are never reported
+	AddedHeaders         []string `json:"addedHeaders,omitempty"`
+	StripRequestHeaders  []string `json:"stripRequestHeaders,omitempty"`
+	StripResponseHeaders []string `json:"stripResponseHeaders,omitempty"`
+}
+
+// redactedLimits are the limits reported by the status endpoint
+type redactedLimits struct {
+	MaxConcurrentRequests int     `json:"maxConcurrentRequests,omitempty"`
+	RequestsPerSecond     float64 `json:"requestsPerSecond,omitempty"`
+	BytesPerSecond        int64   `json:"bytesPerSecond,omitempty"`
+	QueueSize             int     `json:"queueSize,omitempty"`
+}
+
+// redacted returns the current configuration without credentials, only their presence and the username are reported
+func (cfg Config) redacted() any {
+	rc := redactedConfig{
+		TargetHost:           cfg.TargetHost,
+		TargetScheme:         cfg.targetScheme(),
+		TargetServerName:     cfg.TargetServerName,
+		LocationID:           cfg.LocationID,
+		FailoverLocationIDs:  cfg.FailoverLocationIDs,
+		ConnectivityProxyURL: redactURL(cfg.ConnectivityProxyURL),
+		Authorization:        cfg.Authorization,
+		AuthorizationPort:    cfg.AuthorizationPort,
+		AuthorizationHeader:  cfg.AuthorizationHeader != nil && cfg.AuthorizationHeader() != "",
+		CustomCA:             cfg.RootCAs != nil,
+		AllowedMethods:       cfg.allowedMethods(),
+		StripRequestHeaders:  cfg.StripRequestHeaders,
+		StripResponseHeaders: cfg.StripResponseHeaders,
+	}
+	for _, header := range cfg.Headers {
+		rc.AddedHeaders = append(rc.AddedHeaders, header.Name)
+	}
+	if cfg.Credentials != nil {
+		rc.Username = cfg.Credentials().Username
+	}
+	rc.RepositoryAllow, rc.RepositoryDeny = cfg.Repositories.Patterns()
+	if cfg.BlobCache != nil {
+		rc.BlobCacheMaxSize = cfg.BlobCache.MaxSize()
+	}
+	if cfg.AccessLog != nil {
+		rc.AccessLogRateLimit = cfg.AccessLog.RateLimit
+	}
+	if cfg.Limits != nil {
+		rc.Limits = &redactedLimits{
+			MaxConcurrentRequests: cfg.Limits.MaxConcurrentRequests,
+			RequestsPerSecond:     cfg.Limits.RequestsPerSecond,
+			BytesPerSecond:        cfg.Limits.BytesPerSecond,
+			QueueSize:             cfg.Limits.QueueSize,
+		}
+	}
+	rc.TargetBasePath, rc.RepositoryPrefix = cfg.Paths.BasePath(), cfg.Paths.RepositoryPrefix()
+	return rc
+}
+
+// redactURL hides the password of the URL, if it has one. URLs that can't be parsed are replaced entirely,
+// they might still contain credentials.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,146 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/common/fips"
+)
+
+// tunnelDialer opens TLS connections to hosts behind the Connectivity Proxy through HTTP CONNECT tunnels
+type tunnelDialer struct {
+	dialer    *net.Dialer
+	proxyAddr string
+	locations *locations
+	// targetHost is the host the server name override applies to
+	targetHost string
+	serverName string
+	rootCAs    func() *x509.CertPool
+	// open asks the Connectivity Proxy to open a tunnel to the given address over the connection
+	open func(ctx context.Context, conn net.Conn, addr string) error
+}
+
+// newTLSTransport returns a transport sending https requests through CONNECT tunnels of the Connectivity Proxy
+// and plain http requests directly to it
+func newTLSTransport(proxyURL *url.URL, cfg Config, locations *locations) *http.Transport {
+	d := &tunnelDialer{
+		dialer:     &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		proxyAddr:  hostPort(proxyURL.Host, "80"),
+		locations:  locations,
+		targetHost: hostname(cfg.TargetHost),
+		serverName: cfg.TargetServerName,
+		rootCAs:    cfg.RootCAs,
+	}
+	d.open = d.connect
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialTLSContext = d.dialTLS
+	return transport
+}
+
+// dial opens a tunnel to the address through the Connectivity Proxy
+func (d *tunnelDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.open(ctx, conn, addr); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *tunnelDialer) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, d.tlsConfig(addr))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// connect asks the Connectivity Proxy to open a tunnel to the given address
+func (d *tunnelDialer) connect(ctx context.Context, conn net.Conn, addr string) error {
+	// unblock reading the response when the request is canceled
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	locationID := d.locations.dialLocation(ctx)
+	if locationID != "" {
+		req.Header.Set(locationHeader, locationID)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("couldn't send CONNECT request for %s: %w", addr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return fmt.Errorf("couldn't read CONNECT response for %s: %w", addr, err)
+	}
+	tunnelErr := isTunnelError(resp)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if tunnelErr {
+			d.locations.fail(locationID, "CONNECT failed with status "+resp.Status)
+		}
+		return fmt.Errorf("CONNECT to %s failed with status %s", addr, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return fmt.Errorf("unexpected data after CONNECT response for %s", addr)
+	}
+	if !stop() {
+		// the deadline is set or about to be set
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (d *tunnelDialer) tlsConfig(addr string) *tls.Config {
+	cfg := fips.NewTLSConfig()
+	cfg.ServerName = hostname(addr)
+	if d.serverName != "" && cfg.ServerName == d.targetHost {
+		cfg.ServerName = d.serverName
+	}
+	if d.rootCAs != nil {
+		cfg.RootCAs = d.rootCAs()
+	}
+	return cfg
+}
+
+// hostname returns the host without port
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// hostPort adds the default port to the host if it has none
+func hostPort(host, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, defaultPort)
+}
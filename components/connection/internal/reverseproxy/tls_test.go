@@ -0,0 +1,178 @@
+package reverseproxy
+
+import (
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeTunnelProxy opens CONNECT tunnels to the given server, whatever address is requested
+type fakeTunnelProxy struct {
+	*httptest.Server
+	tunnels atomic.Int32
+	// lastConnect is the last CONNECT request
+	lastConnect *http.Request
+	mu          sync.Mutex
+	// downLocationID is rejected like by the Connectivity Proxy which can't reach its Cloud Connector
+	downLocationID string
+}
+
+func (p *fakeTunnelProxy) setDown(locationID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downLocationID = locationID
+}
+
+func (p *fakeTunnelProxy) isDown(locationID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return locationID != "" && locationID == p.downLocationID
+}
+
+func newFakeTunnelProxy(t *testing.T, target *httptest.Server) *fakeTunnelProxy {
+	p := &fakeTunnelProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if locationID := r.Header.Get(locationHeader); p.isDown(locationID) {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("There is no SAP Cloud Connector (SCC) connected to your subaccount with location ID " + locationID))
+			return
+		}
+		p.tunnels.Add(1)
+		p.lastConnect = r
+		upstream, err := net.Dial("tcp", target.Listener.Addr().String())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		_ = conn.Close()
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func newTLSRegistry(t *testing.T) *httptest.Server {
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	t.Cleanup(registry.Close)
+	return registry
+}
+
+func trusting(registry *httptest.Server) func() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(registry.Certificate())
+	return func() *x509.CertPool {
+		return pool
+	}
+}
+
+func TestTLSTarget(t *testing.T) {
+	t.Run("should reach https target through CONNECT tunnel", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeTunnelProxy(t, registry)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			LocationID:           "location",
+			TargetScheme:         "https",
+			// the test certificate is issued for example.com
+			TargetServerName: "example.com",
+			RootCAs:          trusting(registry),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "registry.example", w.Body.String())
+		require.Equal(t, int32(1), cp.tunnels.Load())
+		require.Equal(t, "registry.example:443", cp.lastConnect.Host)
+		require.Equal(t, "location", cp.lastConnect.Header.Get("SAP-Connectivity-SCC-Location_ID"))
+	})
+
+	t.Run("should reject certificate not matching target host", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeTunnelProxy(t, registry)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+			RootCAs:              trusting(registry),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("should reject certificate of untrusted authority", func(t *testing.T) {
+		registry := newTLSRegistry(t)
+		cp := newFakeTunnelProxy(t, registry)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+			TargetServerName:     "example.com",
+			RootCAs: func() *x509.CertPool {
+				return x509.NewCertPool()
+			},
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("should fail when tunnel is refused", func(t *testing.T) {
+		cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		t.Cleanup(cp.Close)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			TargetScheme:         "https",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+
+		require.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
+
+func TestModifyResponseFunc(t *testing.T) {
+	t.Run("should point https realm to plain http authorization container", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("WWW-Authenticate", `Bearer realm="https://auth.example/jwt/auth",service="container_registry"`)
+
+		require.NoError(t, getModifyResponseFunc("30001", zap.NewNop().Sugar())(resp))
+
+		require.Equal(t, `Bearer realm="http://localhost:30001/jwt/auth",service="container_registry"`, resp.Header.Get("WWW-Authenticate"))
+	})
+}
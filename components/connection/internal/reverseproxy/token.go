@@ -0,0 +1,254 @@
+package reverseproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultTokenExpiration is used when the authorization server doesn't return expires_in, as defined by the token spec
+	defaultTokenExpiration = 60 * time.Second
+	// maxTokenResponseSize protects the proxy from reading huge token responses
+	maxTokenResponseSize = 1 << 20
+)
+
+// Credentials are used to log in to the target registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type tokenRequestKey struct{}
+
+// isTokenRequest returns true if the request was sent by the proxy to obtain a registry token
+func isTokenRequest(r *http.Request) bool {
+	return r.Context().Value(tokenRequestKey{}) != nil
+}
+
+// tokenRoundTripper answers authentication challenges of the target registry with the stored credentials.
+// Bearer tokens are obtained from the realm of the challenge through the Connectivity Proxy and cached until they expire,
+// so following requests for the same repository are sent with the token right away.
+type tokenRoundTripper struct {
+	transport   http.RoundTripper
+	proxyURL    *url.URL
+	locations   *locations
+	credentials func() Credentials
+	log         *zap.SugaredLogger
+	// tunnelsTLS is true if the transport reaches https hosts on its own through the Connectivity Proxy
+	tunnelsTLS bool
+	// tunnelsAll is true if the transport reaches all hosts on its own through the Connectivity Proxy
+	tunnelsAll bool
+
+	mu sync.Mutex
+	// authorizations holds the Authorization header values by scope key
+	authorizations map[string]authorization
+	fetches        singleflight.Group
+}
+
+type authorization struct {
+	header    string
+	expiresAt time.Time
+}
+
+func newTokenRoundTripper(transport http.RoundTripper, proxyURL *url.URL, locations *locations, credentials func() Credentials, log *zap.SugaredLogger) *tokenRoundTripper {
+	return &tokenRoundTripper{
+		transport:      transport,
+		proxyURL:       proxyURL,
+		locations:      locations,
+		credentials:    credentials,
+		log:            log,
+		authorizations: map[string]authorization{},
+	}
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := scopeKey(req)
+	if header, ok := t.cachedAuthorization(key); ok {
+		req = withAuthorization(req, header)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body was consumed and can't be sent again
+		return resp, nil
+	}
+
+	header, err := t.authorize(req.Context(), key, strings.Join(resp.Header.Values("WWW-Authenticate"), ", "))
+	if err != nil {
+		t.log.Warnf("couldn't authorize request %s: %v", req.URL.Path, err)
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxTokenResponseSize))
+	_ = resp.Body.Close()
+
+	retry := withAuthorization(req, header)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.transport.RoundTrip(retry)
+}
+
+// scopeKey groups requests which need the same token
+func scopeKey(req *http.Request) string {
+	route := distribution.ParsePath(req.URL.Path)
+	if route.Repository == "" {
+		return string(route.Class)
+	}
+	action := "pull"
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		action = "push"
+	}
+	return route.Repository + ":" + action
+}
+
+func withAuthorization(req *http.Request, header string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", header)
+	return req
+}
+
+func (t *tokenRoundTripper) cachedAuthorization(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.authorizations[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(a.expiresAt) {
+		delete(t.authorizations, key)
+		return "", false
+	}
+	return a.header, true
+}
+
+// authorize returns the Authorization header answering the given challenge and caches it for the scope key
+func (t *tokenRoundTripper) authorize(ctx context.Context, key, challenge string) (string, error) {
+	challenges, err := ParseChallenges(challenge)
+	if err != nil {
+		return "", err
+	}
+	var a authorization
+	if bearer, ok := FindChallenge(challenges, "Bearer"); ok {
+		// concurrent requests for the same scope share a single token request
+		result, err, _ := t.fetches.Do(key+"\x00"+challenge, func() (any, error) {
+			// the token is shared, so it must not depend on the request which happened to ask for it first
+			return t.fetchToken(context.WithoutCancel(ctx), bearer)
+		})
+		if err != nil {
+			return "", err
+		}
+		a = result.(authorization)
+	} else if _, ok := FindChallenge(challenges, "Basic"); ok {
+		credentials := t.credentials()
+		a = authorization{
+			header:    "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials.Username+":"+credentials.Password)),
+			expiresAt: time.Now().Add(24 * time.Hour),
+		}
+	} else {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.authorizations[key] = a
+	return a.header, nil
+}
+
+type tokenResponse struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// fetchToken gets a bearer token from the realm through the Connectivity Proxy
+// see https://distribution.github.io/distribution/spec/auth/token/
+func (t *tokenRoundTripper) fetchToken(ctx context.Context, challenge Challenge) (authorization, error) {
+	realm, err := url.Parse(challenge.Param("realm"))
+	if err != nil || realm.Host == "" {
+		return authorization{}, fmt.Errorf("invalid realm %q", challenge.Param("realm"))
+	}
+	query := realm.Query()
+	if service := challenge.Param("service"); service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range strings.Fields(challenge.Param("scope")) {
+		query.Add("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, tokenRequestKey{}, true), http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return authorization{}, err
+	}
+	if !t.tunnelsAll && (!t.tunnelsTLS || realm.Scheme != "https") {
+		// the Connectivity Proxy forwards the request to the realm host
+		req.Host = realm.Host
+		req.URL.Scheme = t.proxyURL.Scheme
+		req.URL.Host = t.proxyURL.Host
+	}
+	if locationID := t.locations.current(); locationID != "" && !t.tunnelsAll {
+		// the SOCKS5 endpoint gets the location ID during authentication
+		req.Header.Set(locationHeader, locationID)
+	}
+	if credentials := t.credentials(); credentials.Username != "" {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	t.log.Debugf("Requesting token for scope %q from %s", challenge.Param("scope"), realm.Host)
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return authorization{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return authorization{}, fmt.Errorf("token request to %s failed with status %s", realm.Host, resp.Status)
+	}
+
+	token := tokenResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponseSize)).Decode(&token); err != nil {
+		return authorization{}, fmt.Errorf("couldn't decode token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return authorization{}, fmt.Errorf("token response from %s contains no token", realm.Host)
+	}
+
+	expiresIn := defaultTokenExpiration
+	if token.ExpiresIn > 0 {
+		expiresIn = time.Duration(token.ExpiresIn) * time.Second
+	}
+	issuedAt := time.Now()
+	if !token.IssuedAt.IsZero() && token.IssuedAt.Before(issuedAt) {
+		issuedAt = token.IssuedAt
+	}
+	return authorization{
+		header: "Bearer " + token.Token,
+		// leave some time for the request to reach the registry before the token expires
+		expiresAt: issuedAt.Add(expiresIn * 9 / 10),
+	}, nil
+}
@@ -0,0 +1,161 @@
+package reverseproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeConnectivityProxy serves both the registry and its token server, depending on the Host header
+type fakeConnectivityProxy struct {
+	*httptest.Server
+	tokenRequests atomic.Int32
+	unauthorized  atomic.Int32
+	// challenge is sent by the registry to requests without a valid Authorization header
+	challenge string
+	// lastTokenQuery is the query of the last token request
+	lastTokenQuery url.Values
+}
+
+func newFakeConnectivityProxy(t *testing.T) *fakeConnectivityProxy {
+	cp := &fakeConnectivityProxy{
+		challenge: `Bearer realm="https://auth.example/jwt/auth",service="container_registry",scope="repository:team/app:pull"`,
+	}
+	cp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "auth.example" {
+			cp.tokenRequests.Add(1)
+			cp.lastTokenQuery = r.URL.Query()
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "valid-token", "expires_in": 300})
+			return
+		}
+
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid-token", "Basic dXNlcjpzZWNyZXQ=":
+			_, _ = w.Write([]byte(testBlob))
+		default:
+			cp.unauthorized.Add(1)
+			w.Header().Set("WWW-Authenticate", cp.challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(cp.Close)
+	return cp
+}
+
+func staticCredentials(username, password string) func() Credentials {
+	return func() Credentials {
+		return Credentials{Username: username, Password: password}
+	}
+}
+
+func newTestTokenRoundTripper(t *testing.T, proxyURL string) *tokenRoundTripper {
+	u, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	return newTokenRoundTripper(http.DefaultTransport, u, newLocations(Config{}, zap.NewNop().Sugar()), staticCredentials("user", "secret"), zap.NewNop().Sugar())
+}
+
+func roundTrip(t *testing.T, rt http.RoundTripper, proxyURL, path string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, proxyURL+path, nil)
+	require.NoError(t, err)
+	req.Host = "registry.example"
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	return resp
+}
+
+func TestTokenRoundTripper(t *testing.T) {
+	t.Run("should obtain token for challenge and reuse it", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		rt := newTestTokenRoundTripper(t, cp.URL)
+
+		first := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+		second := roundTrip(t, rt, cp.URL, testBlobPath())
+
+		require.Equal(t, http.StatusOK, first.StatusCode)
+		require.Equal(t, http.StatusOK, second.StatusCode)
+		require.Equal(t, int32(1), cp.tokenRequests.Load())
+		require.Equal(t, int32(1), cp.unauthorized.Load())
+		require.Equal(t, "container_registry", cp.lastTokenQuery.Get("service"))
+		require.Equal(t, "repository:team/app:pull", cp.lastTokenQuery.Get("scope"))
+	})
+
+	t.Run("should obtain new token when cached one expires", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		rt := newTestTokenRoundTripper(t, cp.URL)
+
+		roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+		rt.authorizations["team/app:pull"] = authorization{header: "Bearer valid-token", expiresAt: time.Now().Add(-time.Second)}
+		resp := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(2), cp.tokenRequests.Load())
+	})
+
+	t.Run("should answer basic challenge", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		cp.challenge = `Basic realm="registry"`
+		rt := newTestTokenRoundTripper(t, cp.URL)
+
+		resp := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(0), cp.tokenRequests.Load())
+	})
+
+	t.Run("should use rotated credentials", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		rt := newTestTokenRoundTripper(t, cp.URL)
+		rt.credentials = staticCredentials("user", "old")
+
+		rejected := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+		rt.credentials = staticCredentials("user", "secret")
+		accepted := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+
+		require.Equal(t, http.StatusUnauthorized, rejected.StatusCode)
+		require.Equal(t, http.StatusOK, accepted.StatusCode)
+	})
+
+	t.Run("should return challenge when token can't be obtained", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		rt := newTestTokenRoundTripper(t, cp.URL)
+		rt.credentials = staticCredentials("user", "wrong")
+
+		resp := roundTrip(t, rt, cp.URL, "/v2/team/app/manifests/latest")
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Empty(t, rt.authorizations)
+	})
+}
+
+func TestCredentialsLogin(t *testing.T) {
+	t.Run("should replace client credentials with own login", func(t *testing.T) {
+		cp := newFakeConnectivityProxy(t)
+		proxy, err := New(Config{
+			ConnectivityProxyURL: cp.URL,
+			TargetHost:           "registry.example",
+			Credentials:          staticCredentials("user", "secret"),
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "Bearer client-token")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, testBlob, w.Body.String())
+		require.Equal(t, int32(1), cp.tokenRequests.Load())
+	})
+}
@@ -0,0 +1,45 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kyma-project/registry-proxy/components/connection/internal/distribution"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTracingHandler creates a server span for every request, named after the method and the route class
+func newTracingHandler(next http.Handler, routeClass func(*http.Request) distribution.RouteClass) http.Handler {
+	withImage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := distribution.ParsePath(r.URL.Path)
+		if route.Repository != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("registry.repository", route.Repository),
+				attribute.String("registry.reference", route.Reference),
+			)
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(withImage, "registry-proxy-connection",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return spanName(r, routeClass)
+		}),
+	)
+}
+
+// newTracingTransport creates a client span for every round trip and injects the W3C trace context into the request
+func newTracingTransport(transport http.RoundTripper, routeClass func(*http.Request) distribution.RouteClass) http.RoundTripper {
+	return otelhttp.NewTransport(transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return spanName(r, routeClass)
+		}),
+	)
+}
+
+// spanName keeps names of spans low-cardinality, the repository and the reference are attributes of server spans
+func spanName(r *http.Request, routeClass func(*http.Request) distribution.RouteClass) string {
+	return fmt.Sprintf("%s %s", r.Method, routeClass(r))
+}
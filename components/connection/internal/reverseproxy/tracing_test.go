@@ -0,0 +1,51 @@
+package reverseproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/common/tracing/tracingtest"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func TestTracing(t *testing.T) {
+	t.Run("should create server and client spans and propagate the trace context", func(t *testing.T) {
+		exporter, restore := tracingtest.SetupInMemory()
+		defer restore()
+
+		var traceparent string
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			_, _ = w.Write([]byte("{}"))
+		}))
+		defer registry.Close()
+
+		proxy, err := New(Config{
+			ConnectivityProxyURL: registry.URL,
+			TargetHost:           "target",
+		}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		w := pull(t, proxy.HTTPServer.Handler, "/v2/team/app/manifests/latest", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 2)
+		client, server := spans[0], spans[1]
+		require.Equal(t, "GET manifest", client.Name)
+		require.Equal(t, trace.SpanKindClient, client.SpanKind)
+		require.Equal(t, "GET manifest", server.Name)
+		require.Equal(t, trace.SpanKindServer, server.SpanKind)
+		require.Contains(t, server.Attributes, attribute.String("registry.repository", "team/app"))
+		require.Contains(t, server.Attributes, attribute.String("registry.reference", "latest"))
+
+		require.Equal(t, server.SpanContext.TraceID(), client.SpanContext.TraceID())
+		require.Equal(t, server.SpanContext.SpanID(), client.Parent.SpanID())
+		require.Contains(t, traceparent, client.SpanContext.SpanID().String())
+	})
+}
@@ -0,0 +1,116 @@
+package secretdir
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kyma-project/registry-proxy/components/common/secretdigest"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Dir holds the content of a Secret mounted as a volume.
+// Kubelet updates the volume by swapping the ..data symlink when the Secret changes,
+// Watch reloads all files then and replaces them at once, so readers never see a mix of old and new values.
+type Dir struct {
+	path  string
+	log   *zap.SugaredLogger
+	files atomic.Pointer[map[string]string]
+}
+
+// Load reads the files of the given directory, it returns nil if the directory doesn't exist
+func Load(path string, log *zap.SugaredLogger) (*Dir, error) {
+	d := &Dir{
+		path: path,
+		log:  log,
+	}
+	if _, err := d.reload(); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// Get returns the content of the file with the given name, or an empty string if there is no such file
+func (d *Dir) Get(name string) string {
+	return (*d.files.Load())[name]
+}
+
+// Digest returns the digest of the current content, which the controller compares with the one of the Secret
+func (d *Dir) Digest() string {
+	return secretdigest.Sum(*d.files.Load())
+}
+
+// Watch reloads the files on every change of the directory until the context is cancelled
+func (d *Dir) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+	if err := watcher.Add(d.path); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			d.log.Warnf("error while watching %s: %v", d.path, err)
+		case event := <-watcher.Events:
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			changed, err := d.reload()
+			if err != nil {
+				// keep the previous content, the next event brings a consistent state again
+				d.log.Warnf("couldn't reload %s: %v", d.path, err)
+				continue
+			}
+			if changed {
+				d.log.Infof("Reloaded secret from %s", d.path)
+			}
+		}
+	}
+}
+
+// reload reads all files again and returns true if their content changed
+func (d *Dir) reload() (bool, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return false, err
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		// skip kubelet's internal ..data and timestamped directories
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(d.path, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		files[entry.Name()] = string(content)
+	}
+
+	previous := d.files.Swap(&files)
+	return previous == nil || !maps.Equal(*previous, files), nil
+}
@@ -0,0 +1,73 @@
+package secretdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/common/secretdigest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// writeSecret updates the directory the same way kubelet updates a mounted Secret
+func writeSecret(t *testing.T, dir, version string, files map[string]string) {
+	dataDir := filepath.Join(dir, "..data_"+version)
+	require.NoError(t, os.Mkdir(dataDir, 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte(content), 0o644))
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			require.NoError(t, os.Symlink(filepath.Join("..data", name), link))
+		}
+	}
+	require.NoError(t, os.Symlink(filepath.Base(dataDir), filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("should return nil for missing directory", func(t *testing.T) {
+		d, err := Load(filepath.Join(t.TempDir(), "missing"), zap.NewNop().Sugar())
+		require.NoError(t, err)
+		require.Nil(t, d)
+	})
+	t.Run("should read mounted files", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "1", map[string]string{"username": "user", "password": "secret"})
+
+		d, err := Load(dir, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		require.Equal(t, "user", d.Get("username"))
+		require.Equal(t, "secret", d.Get("password"))
+		require.Empty(t, d.Get("..data"))
+		require.Equal(t, secretdigest.Sum(map[string]string{"username": "user", "password": "secret"}), d.Digest())
+	})
+}
+
+func TestWatch(t *testing.T) {
+	t.Run("should reload rotated secret", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "1", map[string]string{"authorizationHeader": "Basic old"})
+		d, err := Load(dir, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		watchErr := make(chan error)
+		go func() {
+			watchErr <- d.Watch(ctx)
+		}()
+		// give the watcher time to start
+		time.Sleep(50 * time.Millisecond)
+
+		writeSecret(t, dir, "2", map[string]string{"authorizationHeader": "Basic new"})
+
+		require.Eventually(t, func() bool {
+			return d.Get("authorizationHeader") == "Basic new"
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		require.NoError(t, <-watchErr)
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import "net/http"
+
+// ResponseWriter records the status code and the number of bytes written, e.g. for metrics and access logs
+type ResponseWriter struct {
+	http.ResponseWriter
+	// Status is the status code sent to the client, 200 until the handler sets another one
+	Status int
+	// Written is the number of bytes of the body sent to the client
+	Written int64
+
+	wroteHeader bool
+}
+
+// NewResponseWriter returns a ResponseWriter recording responses written to the given writer
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (rw *ResponseWriter) WriteHeader(status int) {
+	// informational responses are followed by the final one
+	if !rw.wroteHeader && status >= http.StatusOK {
+		rw.Status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *ResponseWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(p)
+	rw.Written += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to flush the underlying writer
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
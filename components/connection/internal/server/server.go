@@ -1,7 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -10,13 +14,63 @@ import (
 type Server struct {
 	HTTPServer *http.Server
 	Log        *zap.SugaredLogger
+	// Readiness returns an error if the server can't handle requests at the moment, optional
+	Readiness func() error
+	// Upstream describes the system the server forwards requests to, used by the readiness probe and the status endpoint, optional
+	Upstream *Upstream
+
+	draining atomic.Bool
+}
+
+// Upstream describes the system requests are forwarded to
+type Upstream struct {
+	// Registry is true if the upstream serves the registry API, otherwise any response below 500 means it's healthy
+	Registry bool
+	// InjectsCredentials is true if the server authenticates to the upstream on its own,
+	// so rejected requests mean its credentials are wrong instead of a client having to log in
+	InjectsCredentials bool
+	// LastContact returns when the upstream answered a request last time, zero if it never did
+	LastContact func() time.Time
+	// LocationID returns the location ID of the Cloud Connector currently used, empty if there is none
+	LocationID func() string
+	// Config returns the configuration of the server with secrets redacted
+	Config func() any
+	// CredentialsDigest returns the digest of the loaded authorization Secret, empty if there is none
+	CredentialsDigest func() string
+	// Probe sends the readiness probe to the upstream without the limits, retries, logs and metrics of client requests
+	// and returns the status code of the response, the server is probed over HTTP if nil
+	Probe func(ctx context.Context) (int, error)
 }
 
 // Serve wraps the default ListenAndServe method and enriches it with error handling and multi-threading support
 func (s *Server) Serve(stop chan bool) {
 	err := s.HTTPServer.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		stop <- true
 		s.Log.Errorf("error while serving: %v", err)
 	}
 }
+
+// Drain marks the server as going to shut down, so it's not reported as ready anymore while it still serves requests
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Draining returns true if the server is going to shut down
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Shutdown stops accepting new connections and waits up to the timeout for in-flight requests,
+// connections still active after the timeout are closed
+func (s *Server) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.HTTPServer.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.Log.Warnf("in-flight requests didn't finish within %s, closing their connections", timeout)
+		return s.HTTPServer.Close()
+	}
+	return err
+}
@@ -9,6 +9,15 @@ import (
 type RegistryProxySpec struct {
 	// Details of the default used proxy
 	Proxy RegistryProxySpecProxy `json:"proxy,omitempty"`
+
+	// Tracing configures export of OpenTelemetry traces of the controller and connections
+	Tracing RegistryProxySpecTracing `json:"tracing,omitempty"`
+}
+
+type RegistryProxySpecTracing struct {
+	// Endpoint is the URL of the OTLP gRPC endpoint spans are exported to, with protocol.
+	// Tracing is disabled if it's not set.
+	Endpoint string `json:"endpoint,omitempty"`
 }
 
 type RegistryProxySpecProxy struct {
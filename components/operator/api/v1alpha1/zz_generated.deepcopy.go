@@ -72,6 +72,7 @@ func (in *RegistryProxyList) DeepCopyObject() runtime.Object {
 func (in *RegistryProxySpec) DeepCopyInto(out *RegistryProxySpec) {
 	*out = *in
 	out.Proxy = in.Proxy
+	out.Tracing = in.Tracing
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryProxySpec.
@@ -99,6 +100,21 @@ func (in *RegistryProxySpecProxy) DeepCopy() *RegistryProxySpecProxy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryProxySpecTracing) DeepCopyInto(out *RegistryProxySpecTracing) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryProxySpecTracing.
+func (in *RegistryProxySpecTracing) DeepCopy() *RegistryProxySpecTracing {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryProxySpecTracing)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegistryProxyStatus) DeepCopyInto(out *RegistryProxyStatus) {
 	*out = *in
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"github.com/kyma-project/manager-toolkit/installation/chart"
 	"github.com/kyma-project/registry-proxy/components/common/cache"
 	"github.com/kyma-project/registry-proxy/components/common/fips"
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
 	controller "github.com/kyma-project/registry-proxy/components/operator"
 	"github.com/kyma-project/registry-proxy/components/operator/api/v1alpha1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
@@ -80,6 +82,13 @@ func main() {
 	klog.SetLogger(ctrl.Log)
 	setupLog = ctrl.Log.WithName("setup")
 
+	// spans are exported only if the OTLP endpoint is configured with the standard OTEL_EXPORTER_OTLP_ENDPOINT env
+	shutdownTracing, err := tracing.Setup(context.Background(), "registry-proxy-operator")
+	if err != nil {
+		setupLog.Error(err, "unable to setup tracing")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -182,4 +191,7 @@ func main() {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush spans")
+	}
 }
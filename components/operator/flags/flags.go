@@ -38,6 +38,11 @@ func (fb *Builder) WithProxyLocationID(locationID string) *Builder {
 	return fb
 }
 
+func (fb *Builder) WithTracingEndpoint(endpoint string) *Builder {
+	fb.With("global.tracing.endpoint", endpoint)
+	return fb
+}
+
 func (fb *Builder) WithImageRegistryProxy(image string) *Builder {
 	fb.With("global.images.registry_proxy", image)
 	return fb
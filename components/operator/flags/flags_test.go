@@ -34,6 +34,9 @@ func Test_flagsBuilder_Build(t *testing.T) {
 					"locationID": "loc-id",
 					"url":        "http://proxy.proxy",
 				},
+				"tracing": map[string]interface{}{
+					"endpoint": "http://otel-collector:4317",
+				},
 			},
 		}
 
@@ -43,7 +46,8 @@ func Test_flagsBuilder_Build(t *testing.T) {
 			WithIstioInstalled(true).
 			WithImageRegistryProxy("rp-im").
 			WithProxyURL("http://proxy.proxy").
-			WithProxyLocationID("loc-id").Build()
+			WithProxyLocationID("loc-id").
+			WithTracingEndpoint("http://otel-collector:4317").Build()
 
 		require.NoError(t, err)
 		require.Equal(t, expectedFlags, flags)
@@ -9,11 +9,13 @@ import (
 	"strings"
 
 	"github.com/kyma-project/registry-proxy/components/common/cache"
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
 
 	"github.com/kyma-project/manager-toolkit/installation/chart"
 	"github.com/kyma-project/registry-proxy/components/operator/api/v1alpha1"
 	"github.com/kyma-project/registry-proxy/components/operator/flags"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -24,6 +26,9 @@ import (
 
 const (
 	chartPath = "/module-chart"
+
+	// tracerName is the instrumentation scope of reconcile spans
+	tracerName = "github.com/kyma-project/registry-proxy/components/operator/fsm"
 )
 
 var (
@@ -77,6 +82,11 @@ func (m *StateMachine) stateFnName() string {
 func (m *StateMachine) Reconcile(ctx context.Context) (ctrl.Result, error) {
 	var err error
 	var result *ctrl.Result
+	ctx, span := tracing.StartSpan(ctx, tracerName, "Reconcile",
+		attribute.String("k8s.namespace.name", m.State.RegistryProxy.Namespace),
+		attribute.String("name", m.State.RegistryProxy.Name),
+	)
+	defer func() { tracing.End(span, err) }()
 loop:
 	for m.nextFn != nil && err == nil {
 		select {
@@ -85,11 +95,14 @@ loop:
 			break loop
 
 		default:
-			m.Log.Info(fmt.Sprintf("switching state: %s", m.stateFnName()))
-			m.nextFn, result, err = m.nextFn(ctx, m)
-			if updateErr := updateProxyStatus(ctx, m); updateErr != nil {
+			stateName := m.stateFnName()
+			m.Log.Info(fmt.Sprintf("switching state: %s", stateName))
+			stateCtx, stateSpan := tracing.StartSpan(ctx, tracerName, stateName)
+			m.nextFn, result, err = m.nextFn(stateCtx, m)
+			if updateErr := updateProxyStatus(stateCtx, m); updateErr != nil {
 				err = updateErr
 			}
+			tracing.End(stateSpan, err)
 		}
 	}
 	if result == nil {
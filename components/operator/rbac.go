@@ -9,6 +9,7 @@ package operator
 
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=list;get;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=services,verbs=list;get;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="admissionregistration.k8s.io",resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=list;get;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="apiextensions.k8s.io",resources=customresourcedefinitions,verbs=list;get;watch;create;update;patch;delete
 
 // +kubebuilder:rbac:groups="apps",resources=daemonsets,verbs=list;get;watch;create;update;patch;delete
@@ -33,6 +33,7 @@ func sFnApplyResources(_ context.Context, m *fsm.StateMachine) (fsm.StateFn, *ct
 	m.State.FlagsBuilder.WithIstioInstalled(m.IstioReadiness.Get())
 
 	updateProxy(m.State.FlagsBuilder, m.State.RegistryProxy.Spec.Proxy)
+	updateTracing(m.State.FlagsBuilder, m.State.RegistryProxy.Spec.Tracing)
 	updateImages(m.State.FlagsBuilder)
 
 	flags, err := m.State.FlagsBuilder.Build()
@@ -81,6 +82,12 @@ func updateProxy(fb *flags.Builder, proxy v1alpha1.RegistryProxySpecProxy) {
 	}
 }
 
+func updateTracing(fb *flags.Builder, tracing v1alpha1.RegistryProxySpecTracing) {
+	if tracing.Endpoint != "" {
+		fb.WithTracingEndpoint(tracing.Endpoint)
+	}
+}
+
 func updateImages(fb *flags.Builder) {
 	updateImageIfOverride("IMAGE_REGISTRY_PROXY", fb.WithImageRegistryProxy)
 	updateImageIfOverride("IMAGE_CONNECTION", fb.WithImageConnection)
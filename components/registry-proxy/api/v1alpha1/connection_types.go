@@ -3,12 +3,15 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ConnectionSpec defines the desired state of Connection.
+// +kubebuilder:validation:XValidation:message="cache.persistentVolumeClaim can't be shared by more than one replica",rule="!has(self.cache) || !has(self.cache.persistentVolumeClaim) || (!has(self.autoscaling) && (!has(self.replicas) || self.replicas == 1))"
 type ConnectionSpec struct {
 	// Details of the used proxy
+	// +kubebuilder:validation:XValidation:message="Use only one of locationID or locationIDs",rule="!(has(self.locationID) && has(self.locationIDs))"
 	Proxy ConnectionSpecProxy `json:"proxy,omitempty"`
 
 	// +kubebuilder:validation:Required
@@ -25,15 +28,104 @@ type ConnectionSpec struct {
 	// NodePort is the port on which the service is exposed on each node.
 	// If not specified, a random port will be assigned.
 	NodePort int32 `json:"nodePort,omitempty,omitzero"`
+
+	// Cache configures an on-disk cache of image layers pulled through the connection
+	Cache *ConnectionSpecCache `json:"cache,omitempty"`
+
+	// AccessLog enables a JSON log entry for every request with the pulled repository and reference
+	AccessLog *ConnectionSpecAccessLog `json:"accessLog,omitempty"`
+
+	// Headers adds headers to requests forwarded to the target registry and strips headers of requests and responses
+	Headers ConnectionSpecHeaders `json:"headers,omitempty"`
+
+	// Coalescing configures how transfers shared by concurrent identical requests are passed to their clients
+	Coalescing *ConnectionSpecCoalescing `json:"coalescing,omitempty"`
+
+	// Limits protect the Cloud Connector tunnel from bursts of requests, e.g. during large rollouts.
+	// They apply to every replica, so the target registry receives up to the number of replicas times the limits.
+	Limits *ConnectionSpecLimits `json:"limits,omitempty"`
+
+	// Shutdown configures how long terminated pods of the connection finish in-flight requests
+	Shutdown *ConnectionSpecShutdown `json:"shutdown,omitempty"`
+
+	// Replicas is the number of pods of the connection, it's ignored if Autoscaling is set
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Autoscaling scales pods of the connection with a HorizontalPodAutoscaler
+	// +kubebuilder:validation:XValidation:message="minReplicas must not be greater than maxReplicas",rule="!has(self.minReplicas) || self.minReplicas <= self.maxReplicas"
+	// +kubebuilder:validation:XValidation:message="Set at least one of targetCPUUtilization or targetInFlightRequests",rule="has(self.targetCPUUtilization) || has(self.targetInFlightRequests)"
+	Autoscaling *ConnectionSpecAutoscaling `json:"autoscaling,omitempty"`
+}
+
+// DesiredReplicas returns the number of pods requested for the connection, autoscaling starts from the minimum
+func (s ConnectionSpec) DesiredReplicas() int32 {
+	if s.Autoscaling != nil {
+		return max(s.Autoscaling.MinReplicas, 1)
+	}
+	return max(s.Replicas, 1)
+}
+
+type ConnectionSpecHeaders struct {
+	// Add lists headers set on every request forwarded to the target registry, replacing values sent by clients
+	Add []ConnectionSpecHeader `json:"add,omitempty"`
+
+	// StripRequest lists headers removed from requests before they're forwarded to the target registry
+	// +kubebuilder:validation:items:Pattern=`^[!#$%&'*+.^_|~0-9A-Za-z-]+$`
+	StripRequest []string `json:"stripRequest,omitempty"`
+
+	// StripResponse lists headers removed from responses of the target registry before they're sent to clients
+	// +kubebuilder:validation:items:Pattern=`^[!#$%&'*+.^_|~0-9A-Za-z-]+$`
+	StripResponse []string `json:"stripResponse,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:message="Use exactly one of value or secretKeyRef",rule="has(self.value) != has(self.secretKeyRef)"
+type ConnectionSpecHeader struct {
+	// Name of the header
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[!#$%&'*+.^_|~0-9A-Za-z-]+$`
+	Name string `json:"name"`
+
+	// Value of the header
+	Value string `json:"value,omitempty"`
+
+	// SecretKeyRef selects a key of a Secret holding the value of the header, the value is redacted in logs.
+	// The connection reloads the value when the Secret changes, without restarting.
+	SecretKeyRef *ConnectionSpecSecretKeyRef `json:"secretKeyRef,omitempty"`
+}
+
+type ConnectionSpecSecretKeyRef struct {
+	// Name of the Secret
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key of the value in the Secret
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
 }
 
 type ConnectionSpecProxy struct {
 	// URL of the Connectivity Proxy, with protocol
 	URL string `json:"url,omitempty"`
 
+	// Protocol used to reach the target through the Connectivity Proxy, its HTTP proxy or its SOCKS5 endpoint.
+	// With socks5, the location ID is passed in the SOCKS5 authentication and https targets are passed through.
+	// +kubebuilder:validation:Enum=http;socks5
+	// +kubebuilder:default=http
+	Protocol string `json:"protocol,omitempty"`
+
 	// Location ID of the connection
 	// used to set the SAP-Connectivity-SCC-Location_ID header on every forwarded request
 	LocationID string `json:"locationID,omitempty"`
+
+	// LocationIDs of Cloud Connectors in the order of preference, the connection switches to the next one
+	// when the current one can't be reached and back to the first one after 5 minutes
+	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:items:MinLength=1
+	LocationIDs []string `json:"locationIDs,omitempty"`
 }
 
 type ConnectionSpecTarget struct {
@@ -41,11 +133,73 @@ type ConnectionSpecTarget struct {
 	// +kubebuilder:validation:MinLength=1
 	Host string `json:"host"`
 
+	// Scheme used to reach the target registry, https connections are tunneled through the Connectivity Proxy
+	// +kubebuilder:validation:Enum=http;https
+	// +kubebuilder:default=http
+	Scheme string `json:"scheme,omitempty"`
+
+	// TLS configures verification of the target registry if the https scheme is used
+	TLS ConnectionSpecTargetTLS `json:"tls,omitempty"`
+
+	// BasePath is the path the target registry serves the registry API under, e.g. /artifactory/api/docker/docker-remote
+	// +kubebuilder:validation:Pattern=`^/[^\s?#]*$`
+	BasePath string `json:"basePath,omitempty"`
+
+	// RepositoryPrefix is prepended to names of repositories requested by clients, e.g. team/app is requested as prod/team/app
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`
+	RepositoryPrefix string `json:"repositoryPrefix,omitempty"`
+
 	// TODO: replace with AtMostOneOf in the future when it'll be available in kubebuilder: https://github.com/kubernetes-sigs/controller-tools/issues/461
 
 	// Authorization defines the authorization method for the connection
-	// +kubebuilder:validation:XValidation:message="Use host or headerSecret",rule="(!has(self.host) && !has(self.headerSecret)) || (has(self.host) && !has(self.headerSecret)) || (!has(self.host) && has(self.headerSecret))"
+	// +kubebuilder:validation:XValidation:message="Use only one of host, headerSecret or credentialsSecret",rule="[has(self.host), has(self.headerSecret), has(self.credentialsSecret)].filter(x, x).size() <= 1"
 	Authorization ConnectionSpecTargetAuthorization `json:"authorization,omitempty"`
+
+	// Permissions of clients using the connection, by default images can only be pulled
+	Permissions ConnectionSpecTargetPermissions `json:"permissions,omitempty"`
+
+	// Repositories limits which repositories of the target registry are available through the connection
+	Repositories ConnectionSpecTargetRepositories `json:"repositories,omitempty"`
+}
+
+// ConnectionSpecTargetRepositories holds glob patterns of repository names,
+// "*" matches any characters except "/", "**" matches any characters and "?" matches a single character except "/"
+type ConnectionSpecTargetRepositories struct {
+	// Allow lists patterns of available repositories, all repositories are available if empty
+	// +kubebuilder:validation:items:Pattern=`^[^,\s]+$`
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny lists patterns of hidden repositories, it takes precedence over Allow
+	// +kubebuilder:validation:items:Pattern=`^[^,\s]+$`
+	Deny []string `json:"deny,omitempty"`
+}
+
+type ConnectionSpecTargetTLS struct {
+	// ServerName overrides the name used for SNI and to verify the certificate of the target registry, Host is used by default
+	ServerName string `json:"serverName,omitempty"`
+
+	// CABundle references PEM encoded certificates of authorities trusted in addition to system ones
+	// +kubebuilder:validation:XValidation:message="Use only one of secretName or configMapName",rule="!(has(self.secretName) && has(self.configMapName))"
+	CABundle ConnectionSpecTargetCABundle `json:"caBundle,omitempty"`
+}
+
+type ConnectionSpecTargetCABundle struct {
+	// SecretName is the name of the Secret containing the CA bundle
+	SecretName string `json:"secretName,omitempty"`
+
+	// ConfigMapName is the name of the ConfigMap containing the CA bundle
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// Key of the CA bundle in the Secret or ConfigMap, "ca.crt" by default
+	Key string `json:"key,omitempty"`
+}
+
+type ConnectionSpecTargetPermissions struct {
+	// Push allows uploading images (POST, PUT and PATCH requests)
+	Push bool `json:"push,omitempty"`
+
+	// Delete allows deleting manifests and blobs (DELETE requests)
+	Delete bool `json:"delete,omitempty"`
 }
 
 type ConnectionSpecTargetAuthorization struct {
@@ -54,6 +208,98 @@ type ConnectionSpecTargetAuthorization struct {
 
 	// Name of the secret containing authorization header to be used for the connection
 	HeaderSecret string `json:"headerSecret,omitempty"`
+
+	// Name of the secret containing username and password keys,
+	// used by the connection to log in to the registry and obtain tokens on its own
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// SecretName returns the name of the secret used by the authorization method, if any
+func (a ConnectionSpecTargetAuthorization) SecretName() string {
+	if a.HeaderSecret != "" {
+		return a.HeaderSecret
+	}
+	return a.CredentialsSecret
+}
+
+type ConnectionSpecCache struct {
+	// Size is the maximum size of the cache.
+	// Least recently used layers are evicted when it is exceeded.
+	// +kubebuilder:validation:Required
+	Size resource.Quantity `json:"size"`
+
+	// PersistentVolumeClaim is the name of the claim used to store the cache.
+	// If not specified, an emptyDir volume is used.
+	// It can only be used by a single replica without autoscaling.
+	PersistentVolumeClaim string `json:"persistentVolumeClaim,omitempty"`
+}
+
+type ConnectionSpecAccessLog struct {
+	// RateLimit is the maximum number of entries written per second.
+	// Entries above the limit are dropped and their number is reported in the next entry.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=100
+	RateLimit int32 `json:"rateLimit,omitempty"`
+}
+
+type ConnectionSpecCoalescing struct {
+	// SpoolSize is the disk space transfers shared by more than one client are spooled in, 1Gi by default.
+	// Small responses, e.g. manifests, are shared in memory. With 0, larger transfers are streamed to a single client
+	// and other clients send their own requests.
+	SpoolSize *resource.Quantity `json:"spoolSize,omitempty"`
+}
+
+type ConnectionSpecLimits struct {
+	// MaxConcurrentRequests is the maximum number of requests a replica sends to the target registry at the same time
+	// +kubebuilder:validation:Minimum=1
+	MaxConcurrentRequests int32 `json:"maxConcurrentRequests,omitempty"`
+
+	// RequestsPerSecond is the maximum number of requests a replica sends to the target registry per second.
+	// Fractions, e.g. 500m, allow fewer than one request per second.
+	RequestsPerSecond *resource.Quantity `json:"requestsPerSecond,omitempty"`
+
+	// Bandwidth is the maximum number of bytes per second read from the target registry by all requests of a replica together
+	Bandwidth *resource.Quantity `json:"bandwidth,omitempty"`
+
+	// QueueSize is the maximum number of requests waiting for the limits in a replica.
+	// Requests above it are rejected with the 429 status code.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=100
+	QueueSize int32 `json:"queueSize,omitempty"`
+}
+
+type ConnectionSpecShutdown struct {
+	// DrainPeriod is how long a terminated pod reports itself as not ready before it stops accepting requests,
+	// 5s by default
+	// +kubebuilder:validation:XValidation:message="drainPeriod must be between 0s and 5m",rule="duration(self) >= duration('0s') && duration(self) <= duration('5m')"
+	DrainPeriod *metav1.Duration `json:"drainPeriod,omitempty"`
+
+	// Timeout is how long in-flight requests, e.g. large blob downloads, have to finish after the drain period,
+	// 60s by default
+	// +kubebuilder:validation:XValidation:message="timeout must be between 0s and 1h",rule="duration(self) >= duration('0s') && duration(self) <= duration('1h')"
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+type ConnectionSpecAutoscaling struct {
+	// MinReplicas is the lower limit of pods
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of pods
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilization is the average CPU utilization of pods, in percent of their CPU requests
+	// +kubebuilder:validation:Minimum=1
+	TargetCPUUtilization int32 `json:"targetCPUUtilization,omitempty"`
+
+	// TargetInFlightRequests is the average number of requests handled by a pod at the same time.
+	// The registry_proxy_connection_in_flight_requests metric of pods must be served by the custom metrics API,
+	// e.g. by the Prometheus Adapter.
+	// +kubebuilder:validation:Minimum=1
+	TargetInFlightRequests int32 `json:"targetInFlightRequests,omitempty"`
 }
 
 // ConnectionStatus defines the observed state of ConnectionStatus.
@@ -64,10 +310,33 @@ type ConnectionStatus struct {
 	// URL of the Connectivity Proxy
 	ProxyURL string `json:"proxyURL,omitempty,omitzero"`
 
+	// CredentialsVersion is the resourceVersion of the authorization secret loaded by the connection.
+	// The connection reloads the secret when it changes, without restarting. Kubelet updates the mounted
+	// secret with a delay, so the version is reported once all ready pods of the connection use its content.
+	CredentialsVersion string `json:"credentialsVersion,omitempty"`
+
+	// Upstream is the state of the target registry reported by the connection
+	Upstream *ConnectionUpstreamStatus `json:"upstream,omitempty"`
+
 	// Conditions associated with CustomStatus.
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ConnectionUpstreamStatus is the state of the target registry reported by the status endpoint of the connection
+type ConnectionUpstreamStatus struct {
+	// State of the target registry, one of Healthy, AuthRequired, AuthFailed, TunnelDown or TargetMissing
+	State string `json:"state,omitempty"`
+
+	// Message explains the state
+	Message string `json:"message,omitempty"`
+
+	// LastContact is the last time the target registry answered a request of the connection
+	LastContact *metav1.Time `json:"lastContact,omitempty"`
+
+	// LocationID of the Cloud Connector the connection currently uses
+	LocationID string `json:"locationID,omitempty"`
+}
+
 type ConditionType string
 
 const (
@@ -83,7 +352,17 @@ const (
 	ConditionReasonDeploymentCreated ConditionReason = "DeploymentCreated"
 	ConditionReasonDeploymentUpdated ConditionReason = "DeploymentUpdated"
 	ConditionReasonDeploymentFailed  ConditionReason = "DeploymentFailed"
+	ConditionReasonResourceUpdated   ConditionReason = "ResourceUpdated"
+	ConditionReasonResourceConflict  ConditionReason = "ResourceConflict"
 	ConditionReasonInvalidProxyURL   ConditionReason = "InvalidProxyURL"
+	ConditionReasonSecretNotFound    ConditionReason = "SecretNotFound"
+	ConditionReasonInvalidSecret     ConditionReason = "InvalidSecret"
+	ConditionReasonInvalidTarget     ConditionReason = "InvalidTarget"
+	ConditionReasonInvalidHeaders    ConditionReason = "InvalidHeaders"
+	ConditionReasonInvalidCache      ConditionReason = "InvalidCache"
+	ConditionReasonInvalidLimits     ConditionReason = "InvalidLimits"
+	ConditionReasonInvalidCoalescing ConditionReason = "InvalidCoalescing"
+	ConditionReasonNodePortConflict  ConditionReason = "NodePortConflict"
 	ConditionReasonResourcesDeployed ConditionReason = "ConnectionResourcesDeployed"
 	ConditionReasonResourcesNotReady ConditionReason = "ConnectionResourcesNotReady"
 	ConditionReasonEstablished       ConditionReason = "ConnectionEstablished"
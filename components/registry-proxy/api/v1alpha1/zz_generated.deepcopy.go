@@ -72,13 +72,44 @@ func (in *ConnectionList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConnectionSpec) DeepCopyInto(out *ConnectionSpec) {
 	*out = *in
-	out.Proxy = in.Proxy
-	out.Target = in.Target
+	in.Proxy.DeepCopyInto(&out.Proxy)
+	in.Target.DeepCopyInto(&out.Target)
 	if in.Resources != nil {
 		in, out := &in.Resources, &out.Resources
 		*out = new(v1.ResourceRequirements)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(ConnectionSpecCache)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AccessLog != nil {
+		in, out := &in.AccessLog, &out.AccessLog
+		*out = new(ConnectionSpecAccessLog)
+		**out = **in
+	}
+	in.Headers.DeepCopyInto(&out.Headers)
+	if in.Coalescing != nil {
+		in, out := &in.Coalescing, &out.Coalescing
+		*out = new(ConnectionSpecCoalescing)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(ConnectionSpecLimits)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Shutdown != nil {
+		in, out := &in.Shutdown, &out.Shutdown
+		*out = new(ConnectionSpecShutdown)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(ConnectionSpecAutoscaling)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpec.
@@ -91,9 +122,157 @@ func (in *ConnectionSpec) DeepCopy() *ConnectionSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecAccessLog) DeepCopyInto(out *ConnectionSpecAccessLog) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecAccessLog.
+func (in *ConnectionSpecAccessLog) DeepCopy() *ConnectionSpecAccessLog {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecAccessLog)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecAutoscaling) DeepCopyInto(out *ConnectionSpecAutoscaling) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecAutoscaling.
+func (in *ConnectionSpecAutoscaling) DeepCopy() *ConnectionSpecAutoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecAutoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecCache) DeepCopyInto(out *ConnectionSpecCache) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecCache.
+func (in *ConnectionSpecCache) DeepCopy() *ConnectionSpecCache {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecCoalescing) DeepCopyInto(out *ConnectionSpecCoalescing) {
+	*out = *in
+	if in.SpoolSize != nil {
+		in, out := &in.SpoolSize, &out.SpoolSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecCoalescing.
+func (in *ConnectionSpecCoalescing) DeepCopy() *ConnectionSpecCoalescing {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecCoalescing)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecHeader) DeepCopyInto(out *ConnectionSpecHeader) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(ConnectionSpecSecretKeyRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecHeader.
+func (in *ConnectionSpecHeader) DeepCopy() *ConnectionSpecHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecHeaders) DeepCopyInto(out *ConnectionSpecHeaders) {
+	*out = *in
+	if in.Add != nil {
+		in, out := &in.Add, &out.Add
+		*out = make([]ConnectionSpecHeader, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StripRequest != nil {
+		in, out := &in.StripRequest, &out.StripRequest
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StripResponse != nil {
+		in, out := &in.StripResponse, &out.StripResponse
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecHeaders.
+func (in *ConnectionSpecHeaders) DeepCopy() *ConnectionSpecHeaders {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecHeaders)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecLimits) DeepCopyInto(out *ConnectionSpecLimits) {
+	*out = *in
+	if in.RequestsPerSecond != nil {
+		in, out := &in.RequestsPerSecond, &out.RequestsPerSecond
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Bandwidth != nil {
+		in, out := &in.Bandwidth, &out.Bandwidth
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecLimits.
+func (in *ConnectionSpecLimits) DeepCopy() *ConnectionSpecLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConnectionSpecProxy) DeepCopyInto(out *ConnectionSpecProxy) {
 	*out = *in
+	if in.LocationIDs != nil {
+		in, out := &in.LocationIDs, &out.LocationIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecProxy.
@@ -106,10 +285,53 @@ func (in *ConnectionSpecProxy) DeepCopy() *ConnectionSpecProxy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecSecretKeyRef) DeepCopyInto(out *ConnectionSpecSecretKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecSecretKeyRef.
+func (in *ConnectionSpecSecretKeyRef) DeepCopy() *ConnectionSpecSecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecSecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecShutdown) DeepCopyInto(out *ConnectionSpecShutdown) {
+	*out = *in
+	if in.DrainPeriod != nil {
+		in, out := &in.DrainPeriod, &out.DrainPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecShutdown.
+func (in *ConnectionSpecShutdown) DeepCopy() *ConnectionSpecShutdown {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecShutdown)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConnectionSpecTarget) DeepCopyInto(out *ConnectionSpecTarget) {
 	*out = *in
+	out.TLS = in.TLS
 	out.Authorization = in.Authorization
+	out.Permissions = in.Permissions
+	in.Repositories.DeepCopyInto(&out.Repositories)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecTarget.
@@ -137,9 +359,85 @@ func (in *ConnectionSpecTargetAuthorization) DeepCopy() *ConnectionSpecTargetAut
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecTargetCABundle) DeepCopyInto(out *ConnectionSpecTargetCABundle) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecTargetCABundle.
+func (in *ConnectionSpecTargetCABundle) DeepCopy() *ConnectionSpecTargetCABundle {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecTargetCABundle)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecTargetPermissions) DeepCopyInto(out *ConnectionSpecTargetPermissions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecTargetPermissions.
+func (in *ConnectionSpecTargetPermissions) DeepCopy() *ConnectionSpecTargetPermissions {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecTargetPermissions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecTargetRepositories) DeepCopyInto(out *ConnectionSpecTargetRepositories) {
+	*out = *in
+	if in.Allow != nil {
+		in, out := &in.Allow, &out.Allow
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Deny != nil {
+		in, out := &in.Deny, &out.Deny
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecTargetRepositories.
+func (in *ConnectionSpecTargetRepositories) DeepCopy() *ConnectionSpecTargetRepositories {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecTargetRepositories)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionSpecTargetTLS) DeepCopyInto(out *ConnectionSpecTargetTLS) {
+	*out = *in
+	out.CABundle = in.CABundle
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionSpecTargetTLS.
+func (in *ConnectionSpecTargetTLS) DeepCopy() *ConnectionSpecTargetTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionSpecTargetTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConnectionStatus) DeepCopyInto(out *ConnectionStatus) {
 	*out = *in
+	if in.Upstream != nil {
+		in, out := &in.Upstream, &out.Upstream
+		*out = new(ConnectionUpstreamStatus)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -158,3 +456,22 @@ func (in *ConnectionStatus) DeepCopy() *ConnectionStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionUpstreamStatus) DeepCopyInto(out *ConnectionUpstreamStatus) {
+	*out = *in
+	if in.LastContact != nil {
+		in, out := &in.LastContact, &out.LastContact
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionUpstreamStatus.
+func (in *ConnectionUpstreamStatus) DeepCopy() *ConnectionUpstreamStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionUpstreamStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/go-logr/logr"
 	"github.com/kyma-project/registry-proxy/components/common/cache"
 	"github.com/kyma-project/registry-proxy/components/common/fips"
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
 	controller "github.com/kyma-project/registry-proxy/components/registry-proxy"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/imagerewrite"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/resources/connectivityproxy"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/validation"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/webhookcert"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -21,15 +27,18 @@ import (
 
 	"github.com/kyma-project/manager-toolkit/logging/logger"
 	securityclientv1 "istio.io/client-go/pkg/apis/security/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -94,6 +103,13 @@ func main() {
 	klog.SetLogger(ctrl.Log)
 	setupLog = ctrl.Log.WithName("setup")
 
+	// spans are exported only if the OTLP endpoint is configured with the standard OTEL_EXPORTER_OTLP_ENDPOINT env
+	shutdownTracing, err := tracing.Setup(context.Background(), "registry-proxy-controller")
+	if err != nil {
+		setupLog.Error(err, "unable to setup tracing")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -109,8 +125,11 @@ func main() {
 		tlsOpts = append(tlsOpts, disableHTTP2)
 	}
 
+	// the certificate of the webhook server is created by the controller, see webhookcert.Ensure
+	webhookCertDir := filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
 	webhookServer := webhook.NewServer(webhook.Options{
 		TLSOpts: tlsOpts,
+		CertDir: webhookCertDir,
 	})
 
 	// Metrics endpoint is enabled in 'config/default/kustomization.yaml'. The Metrics options configure the server.
@@ -149,6 +168,13 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
+
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// only metadata of secrets is watched, their content is read directly when the authorization is validated
+				DisableFor: []client.Object{&corev1.Secret{}},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -164,16 +190,44 @@ func main() {
 	boolCache := cache.NewInMemoryBoolCache()
 
 	if err = (&controller.RegistryProxyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    reconcilerLogger.WithContext(),
-		Cache:  boolCache,
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Log:      reconcilerLogger.WithContext(),
+		Cache:    boolCache,
+		Recorder: mgr.GetEventRecorderFor("registry-proxy-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Connection")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	webhooks := os.Getenv("WEBHOOK_ENABLED") == "true"
+	if webhooks {
+		// the cache of the manager isn't started yet, the certificate is read and stored directly
+		directClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		if err := webhookcert.Ensure(context.Background(), directClient, os.Getenv("POD_NAMESPACE"), webhookCertDir); err != nil {
+			setupLog.Error(err, "unable to set up webhook certificate")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register(imagerewrite.Path, &webhook.Admission{Handler: &imagerewrite.PodMutator{
+			Client:  mgr.GetClient(),
+			Decoder: admission.NewDecoder(scheme),
+			Log:     reconcilerLogger.WithContext().Named("image-rewrite"),
+		}})
+		err = ctrl.NewWebhookManagedBy(mgr).
+			For(&v1alpha1.Connection{}).
+			WithValidator(&validation.ConnectionValidator{Client: mgr.GetClient()}).
+			Complete()
+		if err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Connection")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -182,10 +236,19 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if webhooks {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush spans")
+	}
 }
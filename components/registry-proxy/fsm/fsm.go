@@ -3,23 +3,35 @@ package fsm
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/kyma-project/registry-proxy/components/common/cache"
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
 
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	securityclientv1 "istio.io/client-go/pkg/apis/security/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// tracerName is the instrumentation scope of reconcile spans
+	tracerName = "github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	// statusTimeout limits how long a reconcile waits for the status endpoint of the connection
+	statusTimeout = 5 * time.Second
+)
+
 type StateFn func(context.Context, *StateMachine) (StateFn, *ctrl.Result, error)
 
 type SystemState struct {
@@ -31,6 +43,10 @@ type SystemState struct {
 	Service               *corev1.Service
 	PeerAuthentication    *securityclientv1.PeerAuthentication
 	AuthorizationNodePort int32
+	// CredentialsVersion and CredentialsDigest identify the current content of the authorization Secret,
+	// the version is reported in the status once the connection reports it loaded the same digest
+	CredentialsVersion string
+	CredentialsDigest  string
 }
 
 func (s *SystemState) saveStatusSnapshot() {
@@ -49,6 +65,10 @@ type StateMachine struct {
 	Client client.Client
 	Scheme *apimachineryruntime.Scheme
 	Cache  cache.BoolCache
+	// Recorder emits events on the Connection, no events are emitted if nil
+	Recorder record.EventRecorder
+	// HTTPClient reads the status endpoint of the connection, the upstream status isn't reported if nil
+	HTTPClient *http.Client
 }
 
 func (m *StateMachine) stateFnName() string {
@@ -63,9 +83,22 @@ func (m *StateMachine) stateFnName() string {
 	return shortName
 }
 
+// Eventf emits an event on the Connection, state functions emit events for transitions they make
+func (m *StateMachine) Eventf(eventType, reason, messageFmt string, args ...any) {
+	if m.Recorder == nil {
+		return
+	}
+	m.Recorder.Eventf(&m.State.Connection, eventType, reason, messageFmt, args...)
+}
+
 func (m *StateMachine) Reconcile(ctx context.Context) (ctrl.Result, error) {
 	var err error
 	var result *ctrl.Result
+	ctx, span := tracing.StartSpan(ctx, tracerName, "Reconcile",
+		attribute.String("k8s.namespace.name", m.State.Connection.Namespace),
+		attribute.String("name", m.State.Connection.Name),
+	)
+	defer func() { tracing.End(span, err) }()
 loop:
 	for m.nextFn != nil && err == nil {
 		select {
@@ -74,11 +107,14 @@ loop:
 			break loop
 
 		default:
-			m.Log.Info(fmt.Sprintf("switching state: %s", m.stateFnName()))
-			m.nextFn, result, err = m.nextFn(ctx, m)
-			if updateErr := updateProxyStatus(ctx, m); updateErr != nil {
+			stateName := m.stateFnName()
+			m.Log.Info(fmt.Sprintf("switching state: %s", stateName))
+			stateCtx, stateSpan := tracing.StartSpan(ctx, tracerName, stateName)
+			m.nextFn, result, err = m.nextFn(stateCtx, m)
+			if updateErr := updateProxyStatus(stateCtx, m); updateErr != nil {
 				err = updateErr
 			}
+			tracing.End(stateSpan, err)
 		}
 	}
 	if result == nil {
@@ -97,16 +133,18 @@ type StateMachineReconciler interface {
 	Reconcile(ctx context.Context) (ctrl.Result, error)
 }
 
-func New(client client.Client, instance *v1alpha1.Connection, startState StateFn /*recorder record.EventRecorder,*/, scheme *apimachineryruntime.Scheme, log *zap.SugaredLogger, cache cache.BoolCache) StateMachineReconciler {
+func New(client client.Client, instance *v1alpha1.Connection, startState StateFn, recorder record.EventRecorder, scheme *apimachineryruntime.Scheme, log *zap.SugaredLogger, cache cache.BoolCache) StateMachineReconciler {
 	sm := StateMachine{
 		nextFn: startState,
 		State: SystemState{
 			Connection: *instance,
 		},
-		Log:    log,
-		Client: client,
-		Scheme: scheme,
-		Cache:  cache,
+		Log:        log,
+		Client:     client,
+		Scheme:     scheme,
+		Cache:      cache,
+		Recorder:   recorder,
+		HTTPClient: &http.Client{Timeout: statusTimeout},
 	}
 	sm.State.saveStatusSnapshot()
 	return &sm
@@ -117,7 +155,6 @@ func updateProxyStatus(ctx context.Context, m *StateMachine) error {
 	if !reflect.DeepEqual(s.Connection.Status, s.statusSnapshot) {
 		m.Log.Debug(fmt.Sprintf("updating registry proxy status to '%+v'", s.Connection.Status))
 		err := m.Client.Status().Update(ctx, &s.Connection)
-		//emitEvent(r, s)
 		s.saveStatusSnapshot()
 		return err
 	}
@@ -0,0 +1,75 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/common/cache"
+	"github.com/kyma-project/registry-proxy/components/common/tracing/tracingtest"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func sFnTestFirst(_ context.Context, _ *StateMachine) (StateFn, *ctrl.Result, error) {
+	return sFnTestSecond, nil, nil
+}
+
+func sFnTestSecond(_ context.Context, _ *StateMachine) (StateFn, *ctrl.Result, error) {
+	return nil, nil, errors.New("test error")
+}
+
+func TestReconcileTracing(t *testing.T) {
+	t.Run("should create a span for every state function", func(t *testing.T) {
+		exporter, restore := tracingtest.SetupInMemory()
+		defer restore()
+
+		scheme := runtime.NewScheme()
+		require.NoError(t, v1alpha1.AddToScheme(scheme))
+		connection := &v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "maslo"},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(connection).Build()
+
+		m := New(fakeClient, connection, sFnTestFirst, nil, scheme, zap.NewNop().Sugar(), cache.NewInMemoryBoolCache())
+		_, err := m.Reconcile(context.Background())
+		require.Error(t, err)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 3)
+		require.Equal(t, "sFnTestFirst", spans[0].Name)
+		require.Equal(t, "sFnTestSecond", spans[1].Name)
+		require.Equal(t, codes.Error, spans[1].Status.Code)
+		require.Equal(t, "Reconcile", spans[2].Name)
+		require.Equal(t, codes.Error, spans[2].Status.Code)
+		require.Equal(t, spans[2].SpanContext.SpanID(), spans[0].Parent.SpanID())
+		require.Equal(t, spans[2].SpanContext.SpanID(), spans[1].Parent.SpanID())
+	})
+}
+
+func TestEventf(t *testing.T) {
+	t.Run("should emit event on the Connection", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(1)
+		m := &StateMachine{Recorder: recorder}
+
+		m.Eventf("Normal", "ServiceCreated", "Service %s created", "connection")
+
+		require.Equal(t, "Normal ServiceCreated Service connection created", <-recorder.Events)
+	})
+
+	t.Run("should not emit events without recorder", func(t *testing.T) {
+		m := &StateMachine{}
+
+		require.NotPanics(t, func() {
+			m.Eventf("Normal", "ServiceCreated", "Service %s created", "connection")
+		})
+	})
+}
@@ -0,0 +1,31 @@
+package imagerewrite
+
+import "strings"
+
+// splitImage returns the registry host of an image reference and the remaining repository with its tag or digest,
+// the host is empty for images of Docker Hub, e.g. nginx:1.27 or library/nginx
+func splitImage(image string) (host, repository string) {
+	first, rest, ok := strings.Cut(image, "/")
+	// the first component is a host only if it looks like one, see https://pkg.go.dev/github.com/distribution/reference
+	if !ok || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "", image
+	}
+	return first, rest
+}
+
+// rewriteImage returns the image pulled through the connection listening on the node port,
+// false is returned if the image isn't stored in the target registry of the connection
+func rewriteImage(image string, target connectionTarget) (string, bool) {
+	host, repository := splitImage(image)
+	if host == "" || !strings.EqualFold(host, target.host) {
+		return image, false
+	}
+	if target.repositoryPrefix != "" {
+		// clients use names without the prefix, images outside of it aren't available through the connection
+		var ok bool
+		if repository, ok = strings.CutPrefix(repository, target.repositoryPrefix+"/"); !ok {
+			return image, false
+		}
+	}
+	return target.registry + "/" + repository, true
+}
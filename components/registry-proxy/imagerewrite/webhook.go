@@ -0,0 +1,132 @@
+package imagerewrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// Path of the webhook in the webhook server, it's referenced by the MutatingWebhookConfiguration of the chart
+	Path = "/mutate-pods"
+
+	// OriginalImagesAnnotation holds images of rewritten containers as they were before, as a JSON object of container names to images
+	OriginalImagesAnnotation = "registry-proxy.kyma-project.io/original-images"
+)
+
+// connectionTarget is the target registry of a Connection and the address its images are pulled from on nodes
+type connectionTarget struct {
+	host             string
+	repositoryPrefix string
+	// registry is localhost:<nodePort>
+	registry string
+}
+
+// PodMutator rewrites images of containers and init containers stored in the target registry of a Connection
+// in the namespace of the Pod to the node port of the Connection, so manifests can keep the original images
+type PodMutator struct {
+	Client  client.Reader
+	Decoder admission.Decoder
+	Log     *zap.SugaredLogger
+}
+
+func (m *PodMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := m.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	connections := &v1alpha1.ConnectionList{}
+	if err := m.Client.List(ctx, connections, client.InNamespace(req.Namespace)); err != nil {
+		m.Log.Errorf("unable to list Connections in namespace %s: %v", req.Namespace, err)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	targets := connectionTargets(connections.Items)
+	if len(targets) == 0 {
+		return admission.Allowed("no Connection in the namespace")
+	}
+
+	original := map[string]string{}
+	if value := pod.Annotations[OriginalImagesAnnotation]; value != "" {
+		// the webhook may be called again for the same pod, images rewritten before are kept
+		if err := json.Unmarshal([]byte(value), &original); err != nil {
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("invalid %s annotation: %w", OriginalImagesAnnotation, err))
+		}
+	}
+	rewritten := rewriteContainers(pod.Spec.InitContainers, targets, original)
+	rewritten += rewriteContainers(pod.Spec.Containers, targets, original)
+	if rewritten == 0 {
+		return admission.Allowed("no image of a Connection")
+	}
+
+	value, err := json.Marshal(original)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[OriginalImagesAnnotation] = string(value)
+
+	marshaledPod, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	m.Log.Debugf("rewrote %d images of pod %s/%s", rewritten, req.Namespace, podName(pod))
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+}
+
+// rewriteContainers rewrites images of the containers, records their original images and returns how many were rewritten
+func rewriteContainers(containers []corev1.Container, targets []connectionTarget, original map[string]string) int {
+	rewritten := 0
+	for i := range containers {
+		for _, target := range targets {
+			image, ok := rewriteImage(containers[i].Image, target)
+			if !ok {
+				continue
+			}
+			original[containers[i].Name] = containers[i].Image
+			containers[i].Image = image
+			rewritten++
+			break
+		}
+	}
+	return rewritten
+}
+
+// connectionTargets returns targets of Connections exposed on a node port, sorted by name of the Connection,
+// so the same Connection is used if several of them reach the same registry
+func connectionTargets(connections []v1alpha1.Connection) []connectionTarget {
+	slices.SortFunc(connections, func(a, b v1alpha1.Connection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	var targets []connectionTarget
+	for _, c := range connections {
+		if c.Status.NodePort == 0 || c.DeletionTimestamp != nil {
+			continue
+		}
+		targets = append(targets, connectionTarget{
+			host:             c.Spec.Target.Host,
+			repositoryPrefix: strings.Trim(c.Spec.Target.RepositoryPrefix, "/"),
+			registry:         fmt.Sprintf("localhost:%d", c.Status.NodePort),
+		})
+	}
+	return targets
+}
+
+// podName returns the name of the pod, pods of controllers get it only after admission
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
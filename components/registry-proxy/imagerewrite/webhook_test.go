@@ -0,0 +1,146 @@
+package imagerewrite
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func Test_rewriteImage(t *testing.T) {
+	target := connectionTarget{host: "myregistry.example.com:5000", registry: "localhost:32000"}
+	prefixed := connectionTarget{host: "artifactory.example.com", repositoryPrefix: "prod", registry: "localhost:32001"}
+
+	tests := []struct {
+		name   string
+		image  string
+		target connectionTarget
+		want   string
+		wantOK bool
+	}{
+		{name: "tag", image: "myregistry.example.com:5000/team/app:1.0", target: target, want: "localhost:32000/team/app:1.0", wantOK: true},
+		{name: "digest", image: "myregistry.example.com:5000/app@sha256:abc", target: target, want: "localhost:32000/app@sha256:abc", wantOK: true},
+		{name: "host in upper case", image: "MyRegistry.example.com:5000/app", target: target, want: "localhost:32000/app", wantOK: true},
+		{name: "other registry", image: "ghcr.io/team/app:1.0", target: target, wantOK: false},
+		{name: "host without port", image: "myregistry.example.com/app", target: target, wantOK: false},
+		{name: "docker hub", image: "nginx:1.27", target: target, wantOK: false},
+		{name: "repository prefix", image: "artifactory.example.com/prod/team-a/app:1.0", target: prefixed, want: "localhost:32001/team-a/app:1.0", wantOK: true},
+		{name: "outside of repository prefix", image: "artifactory.example.com/staging/team-a/app:1.0", target: prefixed, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rewriteImage(tt.image, tt.target)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPodMutator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	connection := func(name, namespace, host string, nodePort int32) client.Object {
+		return &v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       v1alpha1.ConnectionSpec{Target: v1alpha1.ConnectionSpecTarget{Host: host}},
+			Status:     v1alpha1.ConnectionStatus{NodePort: nodePort},
+		}
+	}
+	newMutator := func(objs ...client.Object) *PodMutator {
+		return &PodMutator{
+			Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+			Decoder: admission.NewDecoder(scheme),
+			Log:     zap.NewNop().Sugar(),
+		}
+	}
+	request := func(t *testing.T, pod *corev1.Pod) admission.Request {
+		raw, err := json.Marshal(pod)
+		require.NoError(t, err)
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: "team",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "app-", Namespace: "team", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "myregistry.example.com:5000/init:1.0"}},
+				Containers: []corev1.Container{
+					{Name: "app", Image: "myregistry.example.com:5000/team/app:1.0"},
+					{Name: "sidecar", Image: "ghcr.io/team/sidecar:1.0"},
+				},
+			},
+		}
+	}
+
+	t.Run("should rewrite images of the target registry and record original images", func(t *testing.T) {
+		m := newMutator(connection("my-connection", "team", "myregistry.example.com:5000", 32000))
+
+		resp := m.Handle(context.Background(), request(t, newPod(nil)))
+
+		require.True(t, resp.Allowed)
+		require.ElementsMatch(t, []jsonpatch.JsonPatchOperation{
+			{Operation: "replace", Path: "/spec/initContainers/0/image", Value: "localhost:32000/init:1.0"},
+			{Operation: "replace", Path: "/spec/containers/0/image", Value: "localhost:32000/team/app:1.0"},
+			{Operation: "add", Path: "/metadata/annotations", Value: map[string]any{
+				OriginalImagesAnnotation: `{"app":"myregistry.example.com:5000/team/app:1.0","init":"myregistry.example.com:5000/init:1.0"}`,
+			}},
+		}, resp.Patches)
+	})
+
+	t.Run("should keep original images of earlier invocations", func(t *testing.T) {
+		m := newMutator(connection("my-connection", "team", "myregistry.example.com:5000", 32000))
+		pod := newPod(map[string]string{OriginalImagesAnnotation: `{"other":"myregistry.example.com:5000/other:1.0"}`})
+
+		resp := m.Handle(context.Background(), request(t, pod))
+
+		require.True(t, resp.Allowed)
+		require.Contains(t, resp.Patches, jsonpatch.JsonPatchOperation{
+			Operation: "replace",
+			Path:      "/metadata/annotations/registry-proxy.kyma-project.io~1original-images",
+			Value:     `{"app":"myregistry.example.com:5000/team/app:1.0","init":"myregistry.example.com:5000/init:1.0","other":"myregistry.example.com:5000/other:1.0"}`,
+		})
+	})
+
+	t.Run("should use the first Connection by name", func(t *testing.T) {
+		m := newMutator(
+			connection("b-connection", "team", "myregistry.example.com:5000", 32001),
+			connection("a-connection", "team", "myregistry.example.com:5000", 32000),
+		)
+
+		resp := m.Handle(context.Background(), request(t, newPod(nil)))
+
+		require.Contains(t, resp.Patches, jsonpatch.JsonPatchOperation{Operation: "replace", Path: "/spec/containers/0/image", Value: "localhost:32000/team/app:1.0"})
+	})
+
+	t.Run("should not change pods without images of Connections", func(t *testing.T) {
+		m := newMutator(
+			connection("other-namespace", "other", "myregistry.example.com:5000", 32000),
+			connection("without-node-port", "team", "myregistry.example.com:5000", 0),
+			connection("other-registry", "team", "other.example.com", 32002),
+		)
+
+		resp := m.Handle(context.Background(), request(t, newPod(nil)))
+
+		require.True(t, resp.Allowed)
+		require.Empty(t, resp.Patches)
+	})
+}
@@ -11,12 +11,18 @@ import (
 	"go.uber.org/zap"
 	securityclientv1 "istio.io/client-go/pkg/apis/security/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // RegistryProxyReconciler reconciles a Connection object
@@ -25,6 +31,8 @@ type RegistryProxyReconciler struct {
 	Scheme *runtime.Scheme
 	Log    *zap.SugaredLogger
 	Cache  cache.BoolCache
+	// Recorder emits events on Connections for transitions of the state machine
+	Recorder record.EventRecorder
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -41,7 +49,7 @@ func (r *RegistryProxyReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	sm := fsm.New(r.Client, &connection, state.StartState(), r.Scheme, log, r.Cache)
+	sm := fsm.New(r.Client, &connection, state.StartState(), r.Recorder, r.Scheme, log, r.Cache)
 	return sm.Reconcile(ctx)
 }
 
@@ -52,7 +60,11 @@ func (r *RegistryProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		WithEventFilter(buildPredicates()).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
 		Owns(&corev1.Pod{}).
+		// only metadata of secrets is cached, their content is read by the connection pods
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.connectionsForSecret), builder.OnlyMetadata).
 		Named("connection")
 
 	if os.Getenv("ISTIO_INSTALLED") == "true" {
@@ -61,6 +73,23 @@ func (r *RegistryProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return controller.Complete(r)
 }
 
+// connectionsForSecret returns requests for all Connections using the given secret for authorization
+func (r *RegistryProxyReconciler) connectionsForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	connections := &v1alpha1.ConnectionList{}
+	if err := r.List(ctx, connections, client.InNamespace(secret.GetNamespace())); err != nil {
+		r.Log.Errorf("unable to list Connections for Secret %s/%s: %v", secret.GetNamespace(), secret.GetName(), err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, connection := range connections.Items {
+		if connection.Spec.Target.Authorization.SecretName() == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&connection)})
+		}
+	}
+	return requests
+}
+
 func buildPredicates() predicate.Funcs {
 	// Predicate to skip reconciliation when the object is being deleted
 	return predicate.Funcs{
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestConnectionsForSecret(t *testing.T) {
+	t.Run("should enqueue connections using the secret", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		require.NoError(t, v1alpha1.AddToScheme(scheme))
+		connection := func(name, namespace string, authorization v1alpha1.ConnectionSpecTargetAuthorization) *v1alpha1.Connection {
+			return &v1alpha1.Connection{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: v1alpha1.ConnectionSpec{
+					Target: v1alpha1.ConnectionSpecTarget{Host: "dummy", Authorization: authorization},
+				},
+			}
+		}
+		r := &RegistryProxyReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				connection("header", "maslo", v1alpha1.ConnectionSpecTargetAuthorization{HeaderSecret: "auth"}),
+				connection("credentials", "maslo", v1alpha1.ConnectionSpecTargetAuthorization{CredentialsSecret: "auth"}),
+				connection("other-secret", "maslo", v1alpha1.ConnectionSpecTargetAuthorization{HeaderSecret: "other"}),
+				connection("other-namespace", "kielbasa", v1alpha1.ConnectionSpecTargetAuthorization{HeaderSecret: "auth"}),
+			).Build(),
+			Log: zap.NewNop().Sugar(),
+		}
+
+		requests := r.connectionsForSecret(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: "maslo"},
+		})
+
+		require.ElementsMatch(t, []reconcile.Request{
+			{NamespacedName: types.NamespacedName{Name: "header", Namespace: "maslo"}},
+			{NamespacedName: types.NamespacedName{Name: "credentials", Namespace: "maslo"}},
+		}, requests)
+	})
+}
@@ -26,13 +26,18 @@ type ConnectivityProxyServers struct {
 }
 
 type ConnectivityProxyServerProxy struct {
-	Http ConnectivityProxyHttp `json:"http,omitempty"`
+	Http   ConnectivityProxyHttp   `json:"http,omitempty"`
+	Socks5 ConnectivityProxySocks5 `json:"socks5,omitempty"`
 }
 
 type ConnectivityProxyHttp struct {
 	Port int `json:"port,omitempty"`
 }
 
+type ConnectivityProxySocks5 struct {
+	Port int `json:"port,omitempty"`
+}
+
 // +kubebuilder:object:root=true
 
 // ConnectivityProxyList contains a list of ConnectivityProxy.
@@ -101,6 +101,7 @@ func (in *ConnectivityProxyList) DeepCopyObject() runtime.Object {
 func (in *ConnectivityProxyServerProxy) DeepCopyInto(out *ConnectivityProxyServerProxy) {
 	*out = *in
 	out.Http = in.Http
+	out.Socks5 = in.Socks5
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectivityProxyServerProxy.
@@ -129,6 +130,21 @@ func (in *ConnectivityProxyServers) DeepCopy() *ConnectivityProxyServers {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectivityProxySocks5) DeepCopyInto(out *ConnectivityProxySocks5) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectivityProxySocks5.
+func (in *ConnectivityProxySocks5) DeepCopy() *ConnectivityProxySocks5 {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectivityProxySocks5)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConnectivityProxySpec) DeepCopyInto(out *ConnectivityProxySpec) {
 	*out = *in
@@ -1,10 +1,16 @@
 package resources
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kyma-project/registry-proxy/components/common/tracing"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -24,6 +30,22 @@ const (
 	probesPort                     = 8081
 	registryProxyAuthorizationPort = 8082
 	authorizationProbesPort        = 8083
+	authorizationVolumeName        = "authorization"
+	credentialsVolumeName          = "credentials"
+	blobCacheVolumeName            = "blob-cache"
+	blobCacheMountPath             = "/cache"
+	tmpVolumeName                  = "tmp"
+	caBundleVolumeName             = "ca-bundle"
+	headersVolumeName              = "headers"
+	defaultCABundleKey             = "ca.crt"
+	// defaultSpoolSize is the disk space shared transfers are spooled in if coalescing doesn't set it
+	defaultSpoolSize = "1Gi"
+	// preStopSleepSeconds gives endpoints time to be updated before the connection receives the termination signal
+	preStopSleepSeconds = 5
+	// defaultShutdownDrainPeriod is the time the connection reports itself as not ready before it stops accepting requests
+	defaultShutdownDrainPeriod = 5 * time.Second
+	// defaultShutdownTimeout is the time in-flight requests, e.g. large blob downloads, have to finish
+	defaultShutdownTimeout = 60 * time.Second
 	// TODO: move to some common resources package?
 	RegistryContainerName      = "registry"
 	AuthorizationContainerName = "authorization"
@@ -35,6 +57,11 @@ type deployment struct {
 	authorizationNodePort int32
 }
 
+// StatusURL returns the URL of the status endpoint of the registry container in the pod
+func StatusURL(pod *corev1.Pod) string {
+	return fmt.Sprintf("http://%s/status", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(probesPort)))
+}
+
 func NewDeployment(connection *v1alpha1.Connection, proxyURL string, authorizationNodePort int32) *appsv1.Deployment {
 	d := &deployment{
 		connection:            connection,
@@ -61,54 +88,314 @@ func (d *deployment) construct() *appsv1.Deployment {
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: podLabels,
+					Labels:      podLabels,
+					Annotations: podAnnotations(),
 				},
 				Spec: corev1.PodSpec{
-					Containers: d.containers(),
+					Containers:                    d.containers(),
+					Volumes:                       d.volumes(),
+					TerminationGracePeriodSeconds: ptr.To(d.terminationGracePeriodSeconds()),
+					TopologySpreadConstraints:     topologySpreadConstraints(podSelectorLabels),
 				},
 			},
-			Replicas: ptr.To[int32](1),
+			Replicas: d.replicas(),
+		},
+	}
+	return deployment
+}
+
+// replicas returns the number of pods of the deployment, it's left to the HorizontalPodAutoscaler if autoscaling is used
+func (d *deployment) replicas() *int32 {
+	if d.connection.Spec.Autoscaling != nil {
+		return nil
+	}
+	return ptr.To(d.connection.Spec.DesiredReplicas())
+}
+
+// topologySpreadConstraints prefer spreading pods over nodes and zones, so a node drain or a zone outage
+// doesn't stop image pulls; pods are still scheduled if there aren't enough nodes
+func topologySpreadConstraints(podSelectorLabels map[string]string) []corev1.TopologySpreadConstraint {
+	constraints := []corev1.TopologySpreadConstraint{}
+	for _, topologyKey := range []string{corev1.LabelHostname, corev1.LabelTopologyZone} {
+		constraints = append(constraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       topologyKey,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: podSelectorLabels,
+			},
+		})
+	}
+	return constraints
+}
+
+// podAnnotations lets Prometheus scrape the metrics of the registry container
+func podAnnotations() map[string]string {
+	return map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   strconv.Itoa(probesPort),
+		"prometheus.io/path":   "/metrics",
+	}
+}
+
+// terminationGracePeriodSeconds covers the preStop hook and the whole shutdown of the connection with a small margin
+func (d *deployment) terminationGracePeriodSeconds() int64 {
+	drainPeriod, timeout := d.shutdown()
+	return preStopSleepSeconds + int64(math.Ceil((drainPeriod + timeout).Seconds())) + 5
+}
+
+// shutdown returns the drain period and the shutdown timeout of the connection
+func (d *deployment) shutdown() (time.Duration, time.Duration) {
+	drainPeriod, timeout := defaultShutdownDrainPeriod, defaultShutdownTimeout
+	if shutdown := d.connection.Spec.Shutdown; shutdown != nil {
+		if shutdown.DrainPeriod != nil {
+			drainPeriod = shutdown.DrainPeriod.Duration
+		}
+		if shutdown.Timeout != nil {
+			timeout = shutdown.Timeout.Duration
+		}
+	}
+	return drainPeriod, timeout
+}
+
+// shutdownEnvs configures the graceful shutdown of the connection
+func (d *deployment) shutdownEnvs() []corev1.EnvVar {
+	drainPeriod, timeout := d.shutdown()
+	return []corev1.EnvVar{
+		{
+			Name:  "SHUTDOWN_DRAIN_PERIOD",
+			Value: drainPeriod.String(),
+		},
+		{
+			Name:  "SHUTDOWN_TIMEOUT",
+			Value: timeout.String(),
 		},
 	}
+}
+
+func (d *deployment) volumes() []corev1.Volume {
+	var volumes []corev1.Volume
 	if d.connection.Spec.Target.Authorization.HeaderSecret != "" {
-		deployment.Spec.Template.Spec.Volumes = []corev1.Volume{
-			{
-				Name: "authorization",
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: d.connection.Spec.Target.Authorization.HeaderSecret,
-					},
+		volumes = append(volumes, corev1.Volume{
+			Name: authorizationVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: d.connection.Spec.Target.Authorization.HeaderSecret,
 				},
 			},
+		})
+	}
+	if d.connection.Spec.Target.Authorization.CredentialsSecret != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: credentialsVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: d.connection.Spec.Target.Authorization.CredentialsSecret,
+				},
+			},
+		})
+	}
+	if caBundle := d.connection.Spec.Target.TLS.CABundle; caBundle.SecretName != "" || caBundle.ConfigMapName != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name:         caBundleVolumeName,
+			VolumeSource: d.caBundleVolumeSource(),
+		})
+	}
+	if d.hasSecretHeaders() {
+		volumes = append(volumes, corev1.Volume{
+			Name:         headersVolumeName,
+			VolumeSource: d.headersVolumeSource(),
+		})
+	}
+	if d.connection.Spec.Cache != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name:         blobCacheVolumeName,
+			VolumeSource: d.blobCacheVolumeSource(),
+		})
+	}
+	// the image has no /tmp, which is needed to spool large upstream transfers shared by many clients
+	if spoolSize := d.spoolSize(); spoolSize != nil {
+		// the spool size counts only transfers being spooled, leave the same amount of space for the ones being removed
+		sizeLimit := spoolSize.DeepCopy()
+		sizeLimit.Add(*spoolSize)
+		volumes = append(volumes, corev1.Volume{
+			Name: tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					SizeLimit: &sizeLimit,
+				},
+			},
+		})
+	}
+	return volumes
+}
+
+// spoolSize returns the disk space transfers shared by many clients are spooled in, nil if they aren't spooled
+func (d *deployment) spoolSize() *resource.Quantity {
+	coalescing := d.connection.Spec.Coalescing
+	if coalescing == nil || coalescing.SpoolSize == nil {
+		return ptr.To(resource.MustParse(defaultSpoolSize))
+	}
+	if coalescing.SpoolSize.Sign() <= 0 {
+		return nil
+	}
+	return coalescing.SpoolSize
+}
+
+// caBundleVolumeSource mounts the CA bundle under the name expected by the connection, whatever its key is
+func (d *deployment) caBundleVolumeSource() corev1.VolumeSource {
+	caBundle := d.connection.Spec.Target.TLS.CABundle
+	key := caBundle.Key
+	if key == "" {
+		key = defaultCABundleKey
+	}
+	items := []corev1.KeyToPath{{Key: key, Path: defaultCABundleKey}}
+	if caBundle.SecretName != "" {
+		return corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: caBundle.SecretName,
+				Items:      items,
+			},
 		}
 	}
-	return deployment
+	return corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: caBundle.ConfigMapName},
+			Items:                items,
+		},
+	}
+}
+
+// headersVolumeSource projects values of headers from all referenced Secrets into one volume, a file per header
+func (d *deployment) headersVolumeSource() corev1.VolumeSource {
+	var sources []corev1.VolumeProjection
+	for i, header := range d.connection.Spec.Headers.Add {
+		if header.SecretKeyRef == nil {
+			continue
+		}
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: header.SecretKeyRef.Name},
+				Items:                []corev1.KeyToPath{{Key: header.SecretKeyRef.Key, Path: headerFile(i)}},
+			},
+		})
+	}
+	return corev1.VolumeSource{
+		Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+	}
+}
+
+func (d *deployment) hasSecretHeaders() bool {
+	for _, header := range d.connection.Spec.Headers.Add {
+		if header.SecretKeyRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// headerFile returns the name of the file holding the value of the header with the given index
+func headerFile(index int) string {
+	return fmt.Sprintf("header-%d", index)
+}
+
+func (d *deployment) blobCacheVolumeSource() corev1.VolumeSource {
+	cache := d.connection.Spec.Cache
+	if cache.PersistentVolumeClaim != "" {
+		return corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: cache.PersistentVolumeClaim,
+			},
+		}
+	}
+	// cache size limits only verified blobs, leave the same amount of space for downloads in progress
+	sizeLimit := cache.Size.DeepCopy()
+	sizeLimit.Add(cache.Size)
+	return corev1.VolumeSource{
+		EmptyDir: &corev1.EmptyDirVolumeSource{
+			SizeLimit: &sizeLimit,
+		},
+	}
 }
 
 func (d *deployment) containers() []corev1.Container {
 	containers := make([]corev1.Container, 0)
 	envs := d.envs()
 	registryContainer := d.container(RegistryContainerName, registryProxyPort, probesPort, envs)
-	if d.connection.Spec.Target.Authorization.HeaderSecret != "" {
-		registryContainer.VolumeMounts = []corev1.VolumeMount{
-			{
-				Name:      "authorization",
-				MountPath: "/secrets/authorization",
-				ReadOnly:  true,
-			},
-		}
-	}
+	registryContainer.VolumeMounts = d.registryVolumeMounts()
 	containers = append(containers, registryContainer)
 
 	if d.authorizationNodePort != 0 {
 		authorizationEnvs := d.authEnvs()
 		authorizationContainer := d.container(AuthorizationContainerName, registryProxyAuthorizationPort, authorizationProbesPort, authorizationEnvs)
+		// headers of the Connection apply to token requests too, e.g. an API key required by a gateway in front of the registry
+		authorizationContainer.VolumeMounts = append(d.caBundleVolumeMounts(), d.headersVolumeMounts()...)
 		containers = append(containers, authorizationContainer)
 	}
 
 	return containers
 }
 
+func (d *deployment) registryVolumeMounts() []corev1.VolumeMount {
+	var volumeMounts []corev1.VolumeMount
+	if d.connection.Spec.Target.Authorization.HeaderSecret != "" {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      authorizationVolumeName,
+			MountPath: "/secrets/authorization",
+			ReadOnly:  true,
+		})
+	}
+	if d.connection.Spec.Target.Authorization.CredentialsSecret != "" {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      credentialsVolumeName,
+			MountPath: "/secrets/credentials",
+			ReadOnly:  true,
+		})
+	}
+	volumeMounts = append(volumeMounts, d.caBundleVolumeMounts()...)
+	volumeMounts = append(volumeMounts, d.headersVolumeMounts()...)
+	if d.connection.Spec.Cache != nil {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      blobCacheVolumeName,
+			MountPath: blobCacheMountPath,
+		})
+	}
+	if d.spoolSize() != nil {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      tmpVolumeName,
+			MountPath: "/tmp",
+		})
+	}
+	return volumeMounts
+}
+
+func (d *deployment) headersVolumeMounts() []corev1.VolumeMount {
+	if !d.hasSecretHeaders() {
+		return nil
+	}
+	return []corev1.VolumeMount{
+		{
+			Name:      headersVolumeName,
+			MountPath: "/secrets/headers",
+			ReadOnly:  true,
+		},
+	}
+}
+
+func (d *deployment) caBundleVolumeMounts() []corev1.VolumeMount {
+	caBundle := d.connection.Spec.Target.TLS.CABundle
+	if caBundle.SecretName == "" && caBundle.ConfigMapName == "" {
+		return nil
+	}
+	return []corev1.VolumeMount{
+		{
+			Name:      caBundleVolumeName,
+			MountPath: "/secrets/ca",
+			ReadOnly:  true,
+		},
+	}
+}
+
 func (d *deployment) container(name string, port, probePort int32, envs []corev1.EnvVar) corev1.Container {
 	container := corev1.Container{
 		Name:  name,
@@ -123,6 +410,14 @@ func (d *deployment) container(name string, port, probePort int32, envs []corev1
 		ImagePullPolicy: corev1.PullIfNotPresent,
 		Resources:       d.resourceConfiguration(),
 		Env:             envs,
+		Lifecycle: &corev1.Lifecycle{
+			// the image has no shell, so the sleep action is used instead of an exec hook
+			PreStop: &corev1.LifecycleHandler{
+				Sleep: &corev1.SleepAction{
+					Seconds: preStopSleepSeconds,
+				},
+			},
+		},
 		Ports: []corev1.ContainerPort{
 			{
 				ContainerPort: port,
@@ -214,14 +509,13 @@ func (d *deployment) envs() []corev1.EnvVar {
 			Name:  "TARGET_HOST",
 			Value: d.connection.Spec.Target.Host,
 		},
+		{
+			Name:  "ALLOWED_METHODS",
+			Value: strings.Join(d.allowedMethods(), ","),
+		},
 	}
 
-	if locationID := getLocationID(&d.connection.Spec.Proxy); locationID != "" {
-		envVariables = append(envVariables, corev1.EnvVar{
-			Name:  "LOCATION_ID",
-			Value: locationID,
-		})
-	}
+	envVariables = append(envVariables, locationEnvs(&d.connection.Spec.Proxy)...)
 
 	if d.connection.Spec.LogLevel != "" {
 		envVariables = append(envVariables, corev1.EnvVar{
@@ -237,9 +531,131 @@ func (d *deployment) envs() []corev1.EnvVar {
 		})
 	}
 
+	if d.connection.Spec.Target.Scheme == "https" {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "TARGET_SCHEME",
+			Value: d.connection.Spec.Target.Scheme,
+		})
+	}
+
+	if serverName := d.connection.Spec.Target.TLS.ServerName; serverName != "" {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "TARGET_SERVER_NAME",
+			Value: serverName,
+		})
+	}
+
+	if basePath := d.connection.Spec.Target.BasePath; basePath != "" {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "TARGET_BASE_PATH",
+			Value: basePath,
+		})
+	}
+
+	envVariables = append(envVariables, d.repositoryPrefixEnvs()...)
+
+	if allow := d.connection.Spec.Target.Repositories.Allow; len(allow) != 0 {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "REPOSITORY_ALLOW",
+			Value: strings.Join(allow, ","),
+		})
+	}
+
+	if deny := d.connection.Spec.Target.Repositories.Deny; len(deny) != 0 {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "REPOSITORY_DENY",
+			Value: strings.Join(deny, ","),
+		})
+	}
+
+	if accessLog := d.connection.Spec.AccessLog; accessLog != nil {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "ACCESS_LOG_RATE_LIMIT",
+			Value: strconv.Itoa(int(max(accessLog.RateLimit, 1))),
+		})
+	}
+
+	envVariables = append(envVariables, d.headerEnvs()...)
+	envVariables = append(envVariables, d.limitEnvs()...)
+	envVariables = append(envVariables, d.shutdownEnvs()...)
+	envVariables = append(envVariables, tracingEnvs()...)
+
+	if d.connection.Spec.Cache != nil {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "BLOB_CACHE_DIR",
+			Value: blobCacheMountPath,
+		}, corev1.EnvVar{
+			Name:  "BLOB_CACHE_MAX_SIZE",
+			Value: strconv.FormatInt(d.connection.Spec.Cache.Size.Value(), 10),
+		})
+	}
+	if spoolSize := d.spoolSize(); spoolSize != nil {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "SPOOL_LIMIT",
+			Value: strconv.FormatInt(spoolSize.Value(), 10),
+		})
+	}
+
 	return envVariables
 }
 
+// addedHeader is the format of headers in the ADD_HEADERS env of the connection
+type addedHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	// File is the name of the file in the headers volume holding the value
+	File string `json:"file,omitempty"`
+}
+
+// headerEnvs configures headers added to requests and stripped from requests and responses
+func (d *deployment) headerEnvs() []corev1.EnvVar {
+	var envVariables []corev1.EnvVar
+	headers := d.connection.Spec.Headers
+	if len(headers.Add) != 0 {
+		added := make([]addedHeader, 0, len(headers.Add))
+		for i, header := range headers.Add {
+			if header.SecretKeyRef != nil {
+				// the value isn't part of the deployment, the connection reads it from the volume
+				added = append(added, addedHeader{Name: header.Name, File: headerFile(i)})
+				continue
+			}
+			added = append(added, addedHeader{Name: header.Name, Value: header.Value})
+		}
+		value, _ := json.Marshal(added)
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "ADD_HEADERS",
+			Value: string(value),
+		})
+	}
+
+	if len(headers.StripRequest) != 0 {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "STRIP_REQUEST_HEADERS",
+			Value: strings.Join(headers.StripRequest, ","),
+		})
+	}
+
+	if len(headers.StripResponse) != 0 {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "STRIP_RESPONSE_HEADERS",
+			Value: strings.Join(headers.StripResponse, ","),
+		})
+	}
+	return envVariables
+}
+
+// allowedMethods returns the HTTP methods the registry container forwards to the target registry
+func (d *deployment) allowedMethods() []string {
+	methods := []string{"GET", "HEAD"}
+	if d.connection.Spec.Target.Permissions.Push {
+		methods = append(methods, "POST", "PUT", "PATCH")
+	}
+	if d.connection.Spec.Target.Permissions.Delete {
+		methods = append(methods, "DELETE")
+	}
+	return methods
+}
+
 func (d *deployment) authEnvs() []corev1.EnvVar {
 	envVariables := []corev1.EnvVar{
 		{
@@ -250,14 +666,18 @@ func (d *deployment) authEnvs() []corev1.EnvVar {
 			Name:  "TARGET_HOST",
 			Value: d.connection.Spec.Target.Authorization.Host,
 		},
+		{
+			Name:  "AUTHORIZATION_PROXY",
+			Value: "true",
+		},
+		{
+			// tokens may be requested with POST, see https://distribution.github.io/distribution/spec/auth/oauth/
+			Name:  "ALLOWED_METHODS",
+			Value: "GET,HEAD,POST",
+		},
 	}
 
-	if locationID := getLocationID(&d.connection.Spec.Proxy); locationID != "" {
-		envVariables = append(envVariables, corev1.EnvVar{
-			Name:  "LOCATION_ID",
-			Value: locationID,
-		})
-	}
+	envVariables = append(envVariables, locationEnvs(&d.connection.Spec.Proxy)...)
 
 	if d.connection.Spec.LogLevel != "" {
 		envVariables = append(envVariables, corev1.EnvVar{
@@ -266,16 +686,86 @@ func (d *deployment) authEnvs() []corev1.EnvVar {
 		})
 	}
 
+	// scopes of token requests name repositories of clients, the prefix is added before they reach the authorization server
+	envVariables = append(envVariables, d.repositoryPrefixEnvs()...)
+
+	// the authorization host is reached the same way as the target, the server name override applies only to the target
+	if d.connection.Spec.Target.Scheme == "https" {
+		envVariables = append(envVariables, corev1.EnvVar{
+			Name:  "TARGET_SCHEME",
+			Value: d.connection.Spec.Target.Scheme,
+		})
+	}
+
+	envVariables = append(envVariables, d.headerEnvs()...)
+	envVariables = append(envVariables, d.shutdownEnvs()...)
+	envVariables = append(envVariables, tracingEnvs()...)
+
 	return envVariables
 }
 
-func getLocationID(proxy *v1alpha1.ConnectionSpecProxy) string {
-	if proxy.LocationID != "" {
-		return proxy.LocationID
+func (d *deployment) repositoryPrefixEnvs() []corev1.EnvVar {
+	prefix := d.connection.Spec.Target.RepositoryPrefix
+	if prefix == "" {
+		return nil
+	}
+	return []corev1.EnvVar{{Name: "REPOSITORY_PREFIX", Value: prefix}}
+}
+
+// limitEnvs passes the limits of requests to the target registry, the connection applies no limit for missing envs
+func (d *deployment) limitEnvs() []corev1.EnvVar {
+	limits := d.connection.Spec.Limits
+	if limits == nil {
+		return nil
+	}
+	var envs []corev1.EnvVar
+	if limits.MaxConcurrentRequests > 0 {
+		envs = append(envs, corev1.EnvVar{Name: "LIMIT_MAX_CONCURRENT_REQUESTS", Value: strconv.Itoa(int(limits.MaxConcurrentRequests))})
+	}
+	if limits.RequestsPerSecond != nil && limits.RequestsPerSecond.Sign() > 0 {
+		// the connection parses fractions, e.g. 0.5 for 500m
+		envs = append(envs, corev1.EnvVar{Name: "LIMIT_REQUESTS_PER_SECOND", Value: strconv.FormatFloat(limits.RequestsPerSecond.AsApproximateFloat64(), 'f', -1, 64)})
+	}
+	if limits.Bandwidth != nil && limits.Bandwidth.Value() > 0 {
+		envs = append(envs, corev1.EnvVar{Name: "LIMIT_BYTES_PER_SECOND", Value: strconv.FormatInt(limits.Bandwidth.Value(), 10)})
+	}
+	if limits.QueueSize > 0 {
+		envs = append(envs, corev1.EnvVar{Name: "LIMIT_QUEUE_SIZE", Value: strconv.Itoa(int(limits.QueueSize))})
+	}
+	return envs
+}
+
+// tracingEnvs passes the OTLP endpoint of the controller to connections, so their spans are exported to the same place
+func tracingEnvs() []corev1.EnvVar {
+	endpoint := os.Getenv(tracing.EndpointEnv)
+	if endpoint == "" {
+		return nil
+	}
+	return []corev1.EnvVar{{Name: tracing.EndpointEnv, Value: endpoint}}
+}
+
+// locationEnvs passes the location ID to use first and the ones the connection fails over to in order
+func locationEnvs(proxy *v1alpha1.ConnectionSpecProxy) []corev1.EnvVar {
+	locationIDs := getLocationIDs(proxy)
+	if len(locationIDs) == 0 {
+		return nil
+	}
+	envs := []corev1.EnvVar{{Name: "LOCATION_ID", Value: locationIDs[0]}}
+	if len(locationIDs) > 1 {
+		envs = append(envs, corev1.EnvVar{Name: "FAILOVER_LOCATION_IDS", Value: strings.Join(locationIDs[1:], ",")})
+	}
+	return envs
+}
+
+func getLocationIDs(proxy *v1alpha1.ConnectionSpecProxy) []string {
+	if len(proxy.LocationIDs) != 0 {
+		return proxy.LocationIDs
+	} else if proxy.LocationID != "" {
+		return []string{proxy.LocationID}
 	} else if os.Getenv("PROXY_LOCATION_ID") != "" {
-		return os.Getenv("PROXY_LOCATION_ID")
+		return []string{os.Getenv("PROXY_LOCATION_ID")}
 	}
-	return ""
+	return nil
 }
 
 func (d *deployment) podRunAsUserUID() *int64 {
@@ -1,7 +1,9 @@
 package resources
 
 import (
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/kyma-project/registry-proxy/components/common/container"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
@@ -11,6 +13,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 )
 
 func TestNewDeployment(t *testing.T) {
@@ -27,10 +30,241 @@ func TestNewDeployment(t *testing.T) {
 		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
 		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "PROXY_URL", Value: "http://test-proxy-url"})
 		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "TARGET_HOST", Value: "dummy"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "ALLOWED_METHODS", Value: "GET,HEAD"})
 
 		require.Equal(t, defaultResources(), regContainer.Resources)
 	})
 
+	t.Run("create deployment with push and delete permissions", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Permissions = v1alpha1.ConnectionSpecTargetPermissions{
+			Push:   true,
+			Delete: true,
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "ALLOWED_METHODS", Value: "GET,HEAD,POST,PUT,PATCH,DELETE"})
+	})
+
+	t.Run("create deployment with repository filter", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Repositories = v1alpha1.ConnectionSpecTargetRepositories{
+			Allow: []string{"team/**", "base/alpine"},
+			Deny:  []string{"team/internal-*"},
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "REPOSITORY_ALLOW", Value: "team/**,base/alpine"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "REPOSITORY_DENY", Value: "team/internal-*"})
+	})
+
+	t.Run("create deployment with access log", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.AccessLog = &v1alpha1.ConnectionSpecAccessLog{RateLimit: 50}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "ACCESS_LOG_RATE_LIMIT", Value: "50"})
+	})
+
+	t.Run("create deployment with custom headers", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Headers = v1alpha1.ConnectionSpecHeaders{
+			Add: []v1alpha1.ConnectionSpecHeader{
+				{Name: "X-Tenant", Value: "acme"},
+				{Name: "X-Api-Key", SecretKeyRef: &v1alpha1.ConnectionSpecSecretKeyRef{Name: "gateway", Key: "apiKey"}},
+			},
+			StripRequest:  []string{"Cookie"},
+			StripResponse: []string{"X-Internal-Trace", "Server"},
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "ADD_HEADERS", Value: `[{"name":"X-Tenant","value":"acme"},{"name":"X-Api-Key","file":"header-1"}]`})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "STRIP_REQUEST_HEADERS", Value: "Cookie"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "STRIP_RESPONSE_HEADERS", Value: "X-Internal-Trace,Server"})
+		require.Contains(t, regContainer.VolumeMounts, corev1.VolumeMount{Name: "headers", MountPath: "/secrets/headers", ReadOnly: true})
+		require.Contains(t, d.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "headers",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{{
+						Secret: &corev1.SecretProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "gateway"},
+							Items:                []corev1.KeyToPath{{Key: "apiKey", Path: "header-1"}},
+						},
+					}},
+				},
+			},
+		})
+	})
+
+	t.Run("create deployment with custom headers on token requests", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "auth.example.com"
+		rp.Spec.Headers = v1alpha1.ConnectionSpecHeaders{
+			Add: []v1alpha1.ConnectionSpecHeader{
+				{Name: "X-Api-Key", SecretKeyRef: &v1alpha1.ConnectionSpecSecretKeyRef{Name: "gateway", Key: "apiKey"}},
+			},
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 30001)
+
+		authContainer := container.Get(d.Spec.Template.Spec.Containers, AuthorizationContainerName)
+		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "ADD_HEADERS", Value: `[{"name":"X-Api-Key","file":"header-0"}]`})
+		require.Contains(t, authContainer.VolumeMounts, corev1.VolumeMount{Name: "headers", MountPath: "/secrets/headers", ReadOnly: true})
+	})
+
+	t.Run("create deployment with graceful shutdown", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "example.com"
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 30001)
+
+		require.Equal(t, ptr.To[int64](75), d.Spec.Template.Spec.TerminationGracePeriodSeconds)
+		for _, c := range d.Spec.Template.Spec.Containers {
+			require.Equal(t, &corev1.SleepAction{Seconds: 5}, c.Lifecycle.PreStop.Sleep)
+			require.Contains(t, c.Env, corev1.EnvVar{Name: "SHUTDOWN_DRAIN_PERIOD", Value: "5s"})
+			require.Contains(t, c.Env, corev1.EnvVar{Name: "SHUTDOWN_TIMEOUT", Value: "1m0s"})
+		}
+	})
+
+	t.Run("create deployment with configured shutdown", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Shutdown = &v1alpha1.ConnectionSpecShutdown{
+			DrainPeriod: &metav1.Duration{Duration: 0},
+			Timeout:     &metav1.Duration{Duration: 10*time.Minute + 500*time.Millisecond},
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 30001)
+
+		require.Equal(t, ptr.To[int64](611), d.Spec.Template.Spec.TerminationGracePeriodSeconds)
+		c := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, c.Env, corev1.EnvVar{Name: "SHUTDOWN_DRAIN_PERIOD", Value: "0s"})
+		require.Contains(t, c.Env, corev1.EnvVar{Name: "SHUTDOWN_TIMEOUT", Value: "10m0.5s"})
+	})
+
+	t.Run("create deployment with https target", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "auth.example.com"
+		rp.Spec.Target.Scheme = "https"
+		rp.Spec.Target.TLS = v1alpha1.ConnectionSpecTargetTLS{
+			ServerName: "registry.internal",
+			CABundle: v1alpha1.ConnectionSpecTargetCABundle{
+				ConfigMapName: "registry-ca",
+				Key:           "bundle.pem",
+			},
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 30001)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "TARGET_SCHEME", Value: "https"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "TARGET_SERVER_NAME", Value: "registry.internal"})
+		authContainer := container.Get(d.Spec.Template.Spec.Containers, AuthorizationContainerName)
+		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "TARGET_SCHEME", Value: "https"})
+		require.NotContains(t, authContainer.Env, corev1.EnvVar{Name: "TARGET_SERVER_NAME", Value: "registry.internal"})
+
+		caMount := corev1.VolumeMount{Name: "ca-bundle", MountPath: "/secrets/ca", ReadOnly: true}
+		require.Contains(t, regContainer.VolumeMounts, caMount)
+		require.Contains(t, authContainer.VolumeMounts, caMount)
+		require.Contains(t, d.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "registry-ca"},
+					Items:                []corev1.KeyToPath{{Key: "bundle.pem", Path: "ca.crt"}},
+				},
+			},
+		})
+	})
+
+	t.Run("create deployment with CA bundle secret", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Scheme = "https"
+		rp.Spec.Target.TLS.CABundle.SecretName = "registry-ca"
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Contains(t, d.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: "registry-ca",
+					Items:      []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+				},
+			},
+		})
+	})
+
+	t.Run("create deployment with metrics scraping annotations", func(t *testing.T) {
+		rp := minimalConnection()
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Equal(t, map[string]string{
+			"prometheus.io/scrape": "true",
+			"prometheus.io/port":   "8081",
+			"prometheus.io/path":   "/metrics",
+		}, d.Spec.Template.Annotations)
+	})
+
+	t.Run("create deployment with credentials secret", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.CredentialsSecret = "registry-credentials"
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Len(t, d.Spec.Template.Spec.Containers, 1)
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.VolumeMounts, corev1.VolumeMount{Name: "credentials", MountPath: "/secrets/credentials", ReadOnly: true})
+		require.Equal(t, "credentials", d.Spec.Template.Spec.Volumes[0].Name)
+		require.Equal(t, "registry-credentials", d.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+	})
+
+	t.Run("create deployment with writable tmp directory for spooling", func(t *testing.T) {
+		rp := minimalConnection()
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Equal(t, []corev1.VolumeMount{{Name: "tmp", MountPath: "/tmp"}}, regContainer.VolumeMounts)
+		require.Len(t, d.Spec.Template.Spec.Volumes, 1)
+		tmpVolume := d.Spec.Template.Spec.Volumes[0]
+		require.Equal(t, "tmp", tmpVolume.Name)
+		require.Equal(t, "2Gi", tmpVolume.EmptyDir.SizeLimit.String())
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "SPOOL_LIMIT", Value: "1073741824"})
+	})
+
+	t.Run("create deployment with configured spool size", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Coalescing = &v1alpha1.ConnectionSpecCoalescing{SpoolSize: ptr.To(resource.MustParse("512Mi"))}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Equal(t, "1Gi", d.Spec.Template.Spec.Volumes[0].EmptyDir.SizeLimit.String())
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "SPOOL_LIMIT", Value: "536870912"})
+	})
+
+	t.Run("create deployment without spooling", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Coalescing = &v1alpha1.ConnectionSpecCoalescing{SpoolSize: ptr.To(resource.MustParse("0"))}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Empty(t, regContainer.VolumeMounts)
+		require.Empty(t, d.Spec.Template.Spec.Volumes)
+		require.False(t, hasEnv(regContainer, "SPOOL_LIMIT"))
+	})
+
 	t.Run("create deployment with Resources", func(t *testing.T) {
 		rp := minimalConnection()
 
@@ -75,6 +309,18 @@ func TestNewDeployment(t *testing.T) {
 		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "LOCATION_ID", Value: "module-target-location"})
 	})
 
+	t.Run("create deployment with tracing endpoint from module CR", func(t *testing.T) {
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://otel-collector:4317")
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "example.com"
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 30001)
+
+		for _, c := range d.Spec.Template.Spec.Containers {
+			require.Contains(t, c.Env, corev1.EnvVar{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "http://otel-collector:4317"})
+		}
+	})
+
 	t.Run("create deployment with authorizationHost and locationID", func(t *testing.T) {
 		t.Setenv("PROXY_LOCATION_ID", "module-target-location")
 		rp := minimalConnection()
@@ -99,10 +345,140 @@ func TestNewDeployment(t *testing.T) {
 		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "PROXY_URL", Value: "http://test-proxy-url"})
 		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "TARGET_HOST", Value: "example.com"})
 		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "LOCATION_ID", Value: "target-location"})
+		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "AUTHORIZATION_PROXY", Value: "true"})
+		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "ALLOWED_METHODS", Value: "GET,HEAD,POST"})
+		require.NotContains(t, regContainer.Env, corev1.EnvVar{Name: "AUTHORIZATION_PROXY", Value: "true"})
 
 		require.Equal(t, defaultResources(), regContainer.Resources)
 		require.Equal(t, defaultResources(), authContainer.Resources)
 	})
+
+	t.Run("create deployment with limits", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Limits = &v1alpha1.ConnectionSpecLimits{
+			MaxConcurrentRequests: 20,
+			RequestsPerSecond:     ptr.To(resource.MustParse("500m")),
+			Bandwidth:             ptr.To(resource.MustParse("10Mi")),
+			QueueSize:             100,
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "LIMIT_MAX_CONCURRENT_REQUESTS", Value: "20"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "LIMIT_REQUESTS_PER_SECOND", Value: "0.5"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "LIMIT_BYTES_PER_SECOND", Value: "10485760"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "LIMIT_QUEUE_SIZE", Value: "100"})
+	})
+
+	t.Run("create deployment with failover locationIDs", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "example.com"
+		rp.Spec.Proxy.LocationIDs = []string{"primary", "backup-1", "backup-2"}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 123)
+
+		for _, c := range d.Spec.Template.Spec.Containers {
+			require.Contains(t, c.Env, corev1.EnvVar{Name: "LOCATION_ID", Value: "primary"})
+			require.Contains(t, c.Env, corev1.EnvVar{Name: "FAILOVER_LOCATION_IDS", Value: "backup-1,backup-2"})
+		}
+	})
+
+	t.Run("create deployment with base path and repository prefix", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.Host = "example.com"
+		rp.Spec.Target.BasePath = "/artifactory/api/docker/docker-remote"
+		rp.Spec.Target.RepositoryPrefix = "prod"
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 123)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "TARGET_BASE_PATH", Value: "/artifactory/api/docker/docker-remote"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "REPOSITORY_PREFIX", Value: "prod"})
+
+		authContainer := container.Get(d.Spec.Template.Spec.Containers, AuthorizationContainerName)
+		require.Contains(t, authContainer.Env, corev1.EnvVar{Name: "REPOSITORY_PREFIX", Value: "prod"})
+		require.NotContains(t, authContainer.Env, corev1.EnvVar{Name: "TARGET_BASE_PATH", Value: "/artifactory/api/docker/docker-remote"})
+	})
+
+	t.Run("create deployment with cache", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Cache = &v1alpha1.ConnectionSpecCache{
+			Size: resource.MustParse("1Gi"),
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "BLOB_CACHE_DIR", Value: "/cache"})
+		require.Contains(t, regContainer.Env, corev1.EnvVar{Name: "BLOB_CACHE_MAX_SIZE", Value: "1073741824"})
+		require.Contains(t, regContainer.VolumeMounts, corev1.VolumeMount{Name: "blob-cache", MountPath: "/cache"})
+
+		require.Len(t, d.Spec.Template.Spec.Volumes, 2)
+		cacheVolume := d.Spec.Template.Spec.Volumes[0]
+		require.Equal(t, "blob-cache", cacheVolume.Name)
+		require.NotNil(t, cacheVolume.EmptyDir)
+		require.Equal(t, int64(2*1024*1024*1024), cacheVolume.EmptyDir.SizeLimit.Value())
+	})
+
+	t.Run("create deployment with cache on persistent volume claim and header secret", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Target.Authorization.HeaderSecret = "auth-secret"
+		rp.Spec.Cache = &v1alpha1.ConnectionSpecCache{
+			Size:                  resource.MustParse("10Gi"),
+			PersistentVolumeClaim: "cache-claim",
+		}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		regContainer := container.Get(d.Spec.Template.Spec.Containers, RegistryContainerName)
+		require.Len(t, regContainer.VolumeMounts, 3)
+
+		require.Len(t, d.Spec.Template.Spec.Volumes, 3)
+		require.Equal(t, "authorization", d.Spec.Template.Spec.Volumes[0].Name)
+		require.Equal(t, "auth-secret", d.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+		require.Equal(t, "blob-cache", d.Spec.Template.Spec.Volumes[1].Name)
+		require.Equal(t, "cache-claim", d.Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName)
+	})
+
+	t.Run("create deployment with replicas spread over nodes and zones", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Replicas = 3
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Equal(t, ptr.To[int32](3), d.Spec.Replicas)
+		constraints := d.Spec.Template.Spec.TopologySpreadConstraints
+		require.Len(t, constraints, 2)
+		require.Equal(t, corev1.LabelHostname, constraints[0].TopologyKey)
+		require.Equal(t, corev1.LabelTopologyZone, constraints[1].TopologyKey)
+		require.Equal(t, corev1.ScheduleAnyway, constraints[0].WhenUnsatisfiable)
+		require.Equal(t, d.Spec.Selector, constraints[0].LabelSelector)
+	})
+
+	t.Run("create deployment with one replica by default", func(t *testing.T) {
+		rp := minimalConnection()
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Equal(t, ptr.To[int32](1), d.Spec.Replicas)
+	})
+
+	t.Run("leave replicas to the autoscaler", func(t *testing.T) {
+		rp := minimalConnection()
+		rp.Spec.Replicas = 3
+		rp.Spec.Autoscaling = &v1alpha1.ConnectionSpecAutoscaling{MinReplicas: 2, MaxReplicas: 5, TargetCPUUtilization: 80}
+
+		d := NewDeployment(rp, rp.Spec.Proxy.URL, 0)
+
+		require.Nil(t, d.Spec.Replicas)
+	})
+}
+
+func hasEnv(c *corev1.Container, name string) bool {
+	return slices.ContainsFunc(c.Env, func(env corev1.EnvVar) bool {
+		return env.Name == name
+	})
 }
 
 func minimalConnection() *v1alpha1.Connection {
@@ -0,0 +1,81 @@
+package resources
+
+import (
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// InFlightRequestsMetric is the metric of the connection the HorizontalPodAutoscaler reads from the custom metrics API
+const InFlightRequestsMetric = "registry_proxy_connection_in_flight_requests"
+
+type horizontalPodAutoscaler struct {
+	connection *v1alpha1.Connection
+}
+
+// NewHorizontalPodAutoscaler returns the autoscaler of the deployment, nil if the connection doesn't use autoscaling
+func NewHorizontalPodAutoscaler(connection *v1alpha1.Connection) *autoscalingv2.HorizontalPodAutoscaler {
+	if connection.Spec.Autoscaling == nil {
+		return nil
+	}
+	h := &horizontalPodAutoscaler{
+		connection: connection,
+	}
+	return h.construct()
+}
+
+func (h *horizontalPodAutoscaler) construct() *autoscalingv2.HorizontalPodAutoscaler {
+	autoscaling := h.connection.Spec.Autoscaling
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      h.connection.Name,
+			Namespace: h.connection.Namespace,
+			Labels:    labels(h.connection, "horizontal-pod-autoscaler"),
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       h.connection.Name,
+			},
+			MinReplicas: ptr.To(h.connection.Spec.DesiredReplicas()),
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics:     h.metrics(),
+		},
+	}
+}
+
+func (h *horizontalPodAutoscaler) metrics() []autoscalingv2.MetricSpec {
+	autoscaling := h.connection.Spec.Autoscaling
+	metrics := []autoscalingv2.MetricSpec{}
+	if autoscaling.TargetCPUUtilization != 0 {
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: ptr.To(autoscaling.TargetCPUUtilization),
+				},
+			},
+		})
+	}
+	if autoscaling.TargetInFlightRequests != 0 {
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{
+					Name: InFlightRequestsMetric,
+				},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: resource.NewQuantity(int64(autoscaling.TargetInFlightRequests), resource.DecimalSI),
+				},
+			},
+		})
+	}
+	return metrics
+}
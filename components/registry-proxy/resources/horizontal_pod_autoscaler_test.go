@@ -0,0 +1,44 @@
+package resources
+
+import (
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+)
+
+func TestNewHorizontalPodAutoscaler(t *testing.T) {
+	t.Run("skip horizontalPodAutoscaler without autoscaling", func(t *testing.T) {
+		c := minimalConnection()
+
+		require.Nil(t, NewHorizontalPodAutoscaler(c))
+	})
+
+	t.Run("create horizontalPodAutoscaler", func(t *testing.T) {
+		c := minimalConnection()
+		c.Spec.Autoscaling = &v1alpha1.ConnectionSpecAutoscaling{
+			MinReplicas:            2,
+			MaxReplicas:            5,
+			TargetCPUUtilization:   80,
+			TargetInFlightRequests: 20,
+		}
+
+		h := NewHorizontalPodAutoscaler(c)
+
+		require.NotNil(t, h)
+		require.Equal(t, "test-c-name", h.GetName())
+		require.Equal(t, "test-c-namespace", h.GetNamespace())
+		require.Equal(t, autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "test-c-name"}, h.Spec.ScaleTargetRef)
+		require.Equal(t, ptr.To[int32](2), h.Spec.MinReplicas)
+		require.Equal(t, int32(5), h.Spec.MaxReplicas)
+		require.Len(t, h.Spec.Metrics, 2)
+		require.Equal(t, corev1.ResourceCPU, h.Spec.Metrics[0].Resource.Name)
+		require.Equal(t, ptr.To[int32](80), h.Spec.Metrics[0].Resource.Target.AverageUtilization)
+		require.Equal(t, InFlightRequestsMetric, h.Spec.Metrics[1].Pods.Metric.Name)
+		require.True(t, resource.MustParse("20").Equal(*h.Spec.Metrics[1].Pods.Target.AverageValue))
+	})
+}
@@ -0,0 +1,39 @@
+package resources
+
+import (
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+type podDisruptionBudget struct {
+	connection *v1alpha1.Connection
+}
+
+func NewPodDisruptionBudget(connection *v1alpha1.Connection) *policyv1.PodDisruptionBudget {
+	p := &podDisruptionBudget{
+		connection: connection,
+	}
+	return p.construct()
+}
+
+func (p *podDisruptionBudget) construct() *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.connection.Name,
+			Namespace: p.connection.Namespace,
+			Labels:    labels(p.connection, "pod-disruption-budget"),
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			// pods are evicted one by one, a connection with one replica can still be drained
+			MaxUnavailable: ptr.To(intstr.FromInt32(1)),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					v1alpha1.LabelApp: p.connection.Name,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package resources
+
+import (
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestNewPodDisruptionBudget(t *testing.T) {
+	t.Run("create podDisruptionBudget", func(t *testing.T) {
+		c := minimalConnection()
+
+		p := NewPodDisruptionBudget(c)
+
+		require.NotNil(t, p)
+		require.Equal(t, "test-c-name", p.GetName())
+		require.Equal(t, "test-c-namespace", p.GetNamespace())
+		require.Equal(t, intstr.FromInt32(1), *p.Spec.MaxUnavailable)
+		require.Equal(t, map[string]string{v1alpha1.LabelApp: "test-c-name"}, p.Spec.Selector.MatchLabels)
+	})
+}
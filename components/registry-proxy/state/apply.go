@@ -0,0 +1,183 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// fieldManager owns fields of resources applied for Connections
+	fieldManager = "registry-proxy-controller"
+	// legacyFieldManager owns fields of resources created and updated by older versions of the controller,
+	// it's derived by the API server from the name of the binary
+	legacyFieldManager = "manager"
+)
+
+type applyResult int
+
+const (
+	resourceUnchanged applyResult = iota
+	resourceCreated
+	// resourceUpdated means the resource didn't match the Connection, because the Connection or the resource was changed
+	resourceUpdated
+)
+
+// applyResource applies the resource owned by the Connection with server-side apply and updates it with the state
+// returned by the API server. Fields owned by other managers with different values are taken over, the conflict is
+// returned separately from errors which stop the apply.
+func applyResource(ctx context.Context, m *fsm.StateMachine, obj client.Object) (applyResult, error, error) {
+	if err := controllerutil.SetControllerReference(&m.State.Connection, obj, m.Scheme); err != nil {
+		return resourceUnchanged, nil, err
+	}
+	gvk, err := apiutil.GVKForObject(obj, m.Scheme)
+	if err != nil {
+		return resourceUnchanged, nil, err
+	}
+	// the apply configuration must contain the kind of the resource
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	current := obj.DeepCopyObject().(client.Object)
+	err = m.Client.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if client.IgnoreNotFound(err) != nil {
+		return resourceUnchanged, nil, err
+	}
+	exists := err == nil
+	if exists {
+		if err := upgradeManagedFields(ctx, m, current); err != nil {
+			return resourceUnchanged, nil, err
+		}
+	}
+
+	applied := obj.DeepCopyObject().(client.Object)
+	var conflict error
+	err = m.Client.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager))
+	if errors.IsConflict(err) {
+		m.Log.Warnf("taking over fields of %s %s/%s: %v", gvk.Kind, obj.GetNamespace(), obj.GetName(), err)
+		conflict = err
+		err = m.Client.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	}
+	if err != nil {
+		return resourceUnchanged, nil, err
+	}
+
+	switch {
+	case !exists:
+		return resourceCreated, conflict, nil
+	case changed(current, obj, applied):
+		return resourceUpdated, conflict, nil
+	default:
+		return resourceUnchanged, conflict, nil
+	}
+}
+
+// changed compares the fields of the resources applied for the Connection. The status, e.g. of a rolling Deployment,
+// and metadata written by others or changed by every apply are ignored, the cached resource may lag behind them.
+// Resources are compared as JSON, as istio resources contain protobuf messages.
+func changed(before, after, applied client.Object) bool {
+	beforeFields, err := appliedFields(before, applied)
+	if err != nil {
+		return true
+	}
+	afterFields, err := appliedFields(after, applied)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(beforeFields, afterFields)
+}
+
+// appliedFields returns the content of the resource without the status and with only the metadata which is applied
+func appliedFields(obj, applied client.Object) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+	delete(fields, "status")
+	fields["metadata"] = map[string]any{
+		"labels":          appliedValues(obj.GetLabels(), applied.GetLabels()),
+		"annotations":     appliedValues(obj.GetAnnotations(), applied.GetAnnotations()),
+		"ownerReferences": obj.GetOwnerReferences(),
+	}
+	return fields, nil
+}
+
+// appliedValues returns values of the applied keys, keys added by others are skipped
+func appliedValues(values, applied map[string]string) map[string]string {
+	picked := map[string]string{}
+	for key := range applied {
+		if value, ok := values[key]; ok {
+			picked[key] = value
+		}
+	}
+	return picked
+}
+
+// upgradeManagedFields moves fields set by updates of older versions of the controller to the apply field manager,
+// otherwise fields removed from resources rendered for the Connection would stay, as the old manager still owns them
+func upgradeManagedFields(ctx context.Context, m *fsm.StateMachine, obj client.Object) error {
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(obj, sets.New(legacyFieldManager), fieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	return m.Client.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// reportApply reports in the ConnectionDeployed condition and an event that an existing resource didn't match
+// the Connection or had fields owned by someone else, and returns true in such a case
+func reportApply(m *fsm.StateMachine, obj client.Object, result applyResult, conflict error, updatedReason v1alpha1.ConditionReason) bool {
+	// the kind isn't kept in typed objects returned by the API server
+	gvk, _ := apiutil.GVKForObject(obj, m.Scheme)
+	switch {
+	case conflict != nil:
+		updateConditionWithEvent(m, corev1.EventTypeWarning,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonResourceConflict,
+			fmt.Sprintf("%s %s fields changed by someone else were taken over: %s", gvk.Kind, obj.GetName(), conflictFields(conflict)),
+		)
+		return true
+	case result == resourceUpdated:
+		updateConditionWithEvent(m, corev1.EventTypeNormal,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			updatedReason,
+			fmt.Sprintf("%s %s updated", gvk.Kind, obj.GetName()),
+		)
+		return true
+	default:
+		return false
+	}
+}
+
+// conflictFields lists fields of an apply conflict with their managers in one line, unlike the error message
+func conflictFields(conflict error) string {
+	status, ok := conflict.(errors.APIStatus)
+	if !ok || status.Status().Details == nil || len(status.Status().Details.Causes) == 0 {
+		return conflict.Error()
+	}
+	var fields []string
+	for _, cause := range status.Status().Details.Causes {
+		fields = append(fields, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+	}
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_changed(t *testing.T) {
+	deployment := func() *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "connection",
+				Namespace:       "maslo",
+				Labels:          map[string]string{"app": "connection"},
+				ResourceVersion: "1",
+			},
+			Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](1)},
+		}
+	}
+	applied := deployment()
+
+	t.Run("when only the status differs should report no change", func(t *testing.T) {
+		before := deployment()
+		after := deployment()
+		after.ResourceVersion = "2"
+		after.Generation = 1
+		after.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, ReadyReplicas: 1, Replicas: 1}
+
+		require.False(t, changed(before, after, applied))
+	})
+
+	t.Run("when others added metadata should report no change", func(t *testing.T) {
+		before := deployment()
+		after := deployment()
+		after.Labels["team"] = "a"
+		after.Annotations = map[string]string{"deployment.kubernetes.io/revision": "2"}
+
+		require.False(t, changed(before, after, applied))
+	})
+
+	t.Run("when the spec differs should report the change", func(t *testing.T) {
+		before := deployment()
+		before.Spec.Replicas = ptr.To[int32](3)
+
+		require.True(t, changed(before, deployment(), applied))
+	})
+
+	t.Run("when applied labels were removed should report the change", func(t *testing.T) {
+		before := deployment()
+		before.Labels = nil
+
+		require.True(t, changed(before, deployment(), applied))
+	})
+}
@@ -16,8 +16,8 @@ func sFnConnectivityProxyURL(ctx context.Context, m *fsm.StateMachine) (fsm.Stat
 	if m.State.Connection.Spec.Proxy.URL != "" {
 		m.State.ProxyURL = m.State.Connection.Spec.Proxy.URL
 	} else {
-		if os.Getenv("PROXY_URL") != "" {
-			// proxy URL provided from RP CR
+		if os.Getenv("PROXY_URL") != "" && m.State.Connection.Spec.Proxy.Protocol != "socks5" {
+			// proxy URL provided from RP CR, which is always the HTTP proxy
 			m.State.ProxyURL = os.Getenv("PROXY_URL")
 		} else {
 			// get Connectivity Proxy URL from Connectivity Proxy CR, which is mandatory here (no proxy URL in the RP CR)
@@ -51,13 +51,18 @@ func getReverseProxyURL(ctx context.Context, m *fsm.StateMachine) (string, error
 		return "", err
 	}
 
-	proxyPort, found, err := unstructured.NestedFieldCopy(connectivityProxy.Object, "spec", "config", "servers", "proxy", "http", "port")
+	// the Connectivity Proxy serves its HTTP proxy and SOCKS5 endpoint on separate ports, the scheme selects the protocol
+	protocol := "http"
+	if m.State.Connection.Spec.Proxy.Protocol == "socks5" {
+		protocol = "socks5"
+	}
+	proxyPort, found, err := unstructured.NestedFieldCopy(connectivityProxy.Object, "spec", "config", "servers", "proxy", protocol, "port")
 	if err != nil {
 		return "", fmt.Errorf("failed to get proxy port from connectivity proxy: %v", err)
 	}
 	if !found {
-		return "", fmt.Errorf("proxy http port was not specified in the connectivity proxy")
+		return "", fmt.Errorf("proxy %s port was not specified in the connectivity proxy", protocol)
 	}
-	proxyURL := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", connectivityProxyKey.Name, connectivityProxyKey.Namespace, proxyPort.(int64))
+	proxyURL := fmt.Sprintf("%s://%s.%s.svc.cluster.local:%d", protocol, connectivityProxyKey.Name, connectivityProxyKey.Namespace, proxyPort.(int64))
 	return proxyURL, nil
 }
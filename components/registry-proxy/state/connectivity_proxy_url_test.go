@@ -48,6 +48,55 @@ func TestGetReverseProxyURL(t *testing.T) {
 		require.Equal(t, "http://connectivity-proxy.kyma-system.svc.cluster.local:8080", proxyURL)
 	})
 
+	t.Run("Connectivity proxy exists, socks5 protocol", func(t *testing.T) {
+		scheme := minimalScheme(t)
+
+		connectivityProxy := minimalConnectivityProxy(8080)
+		connectivityProxy.Object["spec"].(map[string]interface{})["config"].(map[string]interface{})["servers"].(map[string]interface{})["proxy"].(map[string]interface{})["socks5"] = map[string]interface{}{
+			"port": int64(20004),
+		}
+
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(connectivityProxy).Build()
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Proxy: v1alpha1.ConnectionSpecProxy{Protocol: "socks5"},
+					},
+				},
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		proxyURL, err := getReverseProxyURL(context.Background(), &m)
+		require.Nil(t, err)
+		require.Equal(t, "socks5://connectivity-proxy.kyma-system.svc.cluster.local:20004", proxyURL)
+	})
+
+	t.Run("Connectivity proxy exists, but socks5 port is missing", func(t *testing.T) {
+		scheme := minimalScheme(t)
+
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(minimalConnectivityProxy(8080)).Build()
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Proxy: v1alpha1.ConnectionSpecProxy{Protocol: "socks5"},
+					},
+				},
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		proxyURL, err := getReverseProxyURL(context.Background(), &m)
+		require.ErrorContains(t, err, "proxy socks5 port was not specified in the connectivity proxy")
+		require.Equal(t, "", proxyURL)
+	})
+
 	t.Run("Conenctivity proxy exists, but http proxy is missing", func(t *testing.T) {
 		scheme := minimalScheme(t)
 
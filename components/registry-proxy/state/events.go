@@ -0,0 +1,64 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	// eventReasonReady and eventReasonNotReady report changes of the status of the ConnectionReady condition,
+	// its reason and message change with every count of ready replicas
+	eventReasonReady    = "Ready"
+	eventReasonNotReady = "NotReady"
+	// eventReasonNodePortAssigned is the reason of the event emitted when the Connection gets its node port
+	eventReasonNodePortAssigned = "NodePortAssigned"
+)
+
+// updateConditionWithEvent updates the condition of the Connection and emits an event with its reason and message,
+// unless the condition already had them, so a transition seen in every reconciliation is reported once
+func updateConditionWithEvent(m *fsm.StateMachine, eventType string, conditionType v1alpha1.ConditionType, status metav1.ConditionStatus, reason v1alpha1.ConditionReason, message string) {
+	previous := meta.FindStatusCondition(m.State.Connection.Status.Conditions, string(conditionType))
+	unchanged := previous != nil && previous.Status == status && previous.Reason == string(reason) && previous.Message == message
+	m.State.Connection.UpdateCondition(conditionType, status, reason, message)
+	if !unchanged {
+		m.Eventf(eventType, string(reason), "%s", message)
+	}
+}
+
+// readyStatus returns the status of the ConnectionReady condition, Unknown if it isn't set yet
+func readyStatus(connection *v1alpha1.Connection) metav1.ConditionStatus {
+	condition := meta.FindStatusCondition(connection.Status.Conditions, string(v1alpha1.ConditionConnectionReady))
+	if condition == nil {
+		return metav1.ConditionUnknown
+	}
+	return condition.Status
+}
+
+// emitReadinessChange emits an event when the ConnectionReady condition changed its status from the given one
+func emitReadinessChange(m *fsm.StateMachine, previous metav1.ConditionStatus) {
+	condition := meta.FindStatusCondition(m.State.Connection.Status.Conditions, string(v1alpha1.ConditionConnectionReady))
+	if condition == nil || condition.Status == previous {
+		return
+	}
+	switch condition.Status {
+	case metav1.ConditionTrue:
+		m.Eventf(corev1.EventTypeNormal, eventReasonReady, "%s", condition.Message)
+	case metav1.ConditionFalse:
+		m.Eventf(corev1.EventTypeWarning, eventReasonNotReady, "%s", condition.Message)
+	}
+}
+
+// emitResourceEvent emits an event for a resource of the Connection with the reason prefixed by its kind,
+// e.g. PodDisruptionBudgetCreated
+func emitResourceEvent(m *fsm.StateMachine, obj client.Object, action string) {
+	// the kind isn't kept in typed objects returned by the API server
+	gvk, _ := apiutil.GVKForObject(obj, m.Scheme)
+	m.Eventf(corev1.EventTypeNormal, gvk.Kind+action, "%s %s %s", gvk.Kind, obj.GetName(), strings.ToLower(action))
+}
@@ -0,0 +1,43 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// requireEvents checks the recorder got exactly the given events since the last check
+func requireEvents(t *testing.T, recorder *record.FakeRecorder, want ...string) {
+	t.Helper()
+	var got []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			got = append(got, event)
+		default:
+			require.Equal(t, want, got)
+			return
+		}
+	}
+}
+
+func TestUpdateConditionWithEvent(t *testing.T) {
+	t.Run("should emit event once per change of the condition", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(3)
+		m := &fsm.StateMachine{Recorder: recorder}
+
+		updateConditionWithEvent(m, corev1.EventTypeNormal, v1alpha1.ConditionConnectionDeployed, metav1.ConditionUnknown, v1alpha1.ConditionReasonDeploymentUpdated, "Deployment connection updated")
+		updateConditionWithEvent(m, corev1.EventTypeNormal, v1alpha1.ConditionConnectionDeployed, metav1.ConditionUnknown, v1alpha1.ConditionReasonDeploymentUpdated, "Deployment connection updated")
+		updateConditionWithEvent(m, corev1.EventTypeWarning, v1alpha1.ConditionConnectionDeployed, metav1.ConditionFalse, v1alpha1.ConditionReasonDeploymentFailed, "Deployment connection apply failed: boom")
+
+		requireEvents(t, recorder,
+			"Normal DeploymentUpdated Deployment connection updated",
+			"Warning DeploymentFailed Deployment connection apply failed: boom")
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionFalse, v1alpha1.ConditionReasonDeploymentFailed, "Deployment connection apply failed: boom")
+	})
+}
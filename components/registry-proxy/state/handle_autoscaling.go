@@ -0,0 +1,58 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/resources"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sFnHandleAutoscaling applies the HorizontalPodAutoscaler of the Connection or removes it when autoscaling is turned off
+func sFnHandleAutoscaling(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	hpa := resources.NewHorizontalPodAutoscaler(&m.State.Connection)
+	if hpa == nil {
+		return deleteAutoscaler(ctx, m)
+	}
+
+	result, conflict, err := applyResource(ctx, m, hpa)
+	if err != nil {
+		m.Log.Errorf("failed to apply HorizontalPodAutoscaler %s/%s: %v", hpa.GetNamespace(), hpa.GetName(), err)
+		return stopWithEventualError(err)
+	}
+
+	if result == resourceCreated {
+		emitResourceEvent(m, hpa, "Created")
+		return requeueAfter(time.Minute)
+	}
+	if reportApply(m, hpa, result, conflict, v1alpha1.ConditionReasonResourceUpdated) {
+		return requeueAfter(time.Minute)
+	}
+
+	return nextState(sFnHandlePodStatus)
+}
+
+// deleteAutoscaler removes the HorizontalPodAutoscaler left after autoscaling was turned off, so it doesn't
+// keep scaling the deployment which replicas are set by the Connection again
+func deleteAutoscaler(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := m.Client.Get(ctx, client.ObjectKeyFromObject(&m.State.Connection), hpa)
+	if client.IgnoreNotFound(err) != nil {
+		return stopWithEventualError(err)
+	}
+	// autoscalers created by someone else aren't touched
+	if err == nil && metav1.IsControlledBy(hpa, &m.State.Connection) {
+		if err := m.Client.Delete(ctx, hpa); client.IgnoreNotFound(err) != nil {
+			m.Log.Errorf("failed to delete HorizontalPodAutoscaler %s/%s: %v", hpa.GetNamespace(), hpa.GetName(), err)
+			return stopWithEventualError(err)
+		}
+		emitResourceEvent(m, hpa, "Deleted")
+	}
+
+	return nextState(sFnHandlePodStatus)
+}
@@ -0,0 +1,144 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func Test_sFnHandleAutoscaling(t *testing.T) {
+	autoscalingConnection := func() v1alpha1.Connection {
+		connection := minimalDeploymentConnection()
+		connection.Spec.Autoscaling = &v1alpha1.ConnectionSpecAutoscaling{MinReplicas: 2, MaxReplicas: 4, TargetCPUUtilization: 80}
+		return connection
+	}
+
+	t.Run("when autoscaling is turned on should create HorizontalPodAutoscaler and requeue", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		recorder := record.NewFakeRecorder(2)
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: autoscalingConnection()},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+
+		next, result, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+		require.Equal(t, &ctrl.Result{RequeueAfter: time.Minute}, result)
+		require.Nil(t, next)
+
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, hpa))
+		require.Equal(t, ptr.To[int32](2), hpa.Spec.MinReplicas)
+		require.Equal(t, int32(4), hpa.Spec.MaxReplicas)
+		requireEvents(t, recorder, "Normal HorizontalPodAutoscalerCreated HorizontalPodAutoscaler connection created")
+	})
+
+	t.Run("when autoscaling is changed should update HorizontalPodAutoscaler and requeue", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		recorder := record.NewFakeRecorder(2)
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: autoscalingConnection()},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+		_, _, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+
+		next, result, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandlePodStatus, next)
+
+		m.State.Connection.Spec.Autoscaling.MaxReplicas = 6
+
+		next, result, err = sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+		require.Equal(t, &ctrl.Result{RequeueAfter: time.Minute}, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionUnknown, v1alpha1.ConditionReasonResourceUpdated, "HorizontalPodAutoscaler connection updated")
+		requireEvents(t, recorder,
+			"Normal HorizontalPodAutoscalerCreated HorizontalPodAutoscaler connection created",
+			"Normal ResourceUpdated HorizontalPodAutoscaler connection updated")
+	})
+
+	t.Run("when autoscaling is turned off should delete HorizontalPodAutoscaler", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		recorder := record.NewFakeRecorder(2)
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: autoscalingConnection()},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+		_, _, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+
+		m.State.Connection.Spec.Autoscaling = nil
+
+		next, result, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandlePodStatus, next)
+
+		err = fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, &autoscalingv2.HorizontalPodAutoscaler{})
+		require.True(t, apierrors.IsNotFound(err))
+		requireEvents(t, recorder,
+			"Normal HorizontalPodAutoscalerCreated HorizontalPodAutoscaler connection created",
+			"Normal HorizontalPodAutoscalerDeleted HorizontalPodAutoscaler connection deleted")
+	})
+
+	t.Run("when autoscaling is turned off should keep HorizontalPodAutoscaler created by someone else", func(t *testing.T) {
+		connection := minimalDeploymentConnection()
+		otherOwner := minimalDeploymentConnection()
+		otherOwner.UID = "other"
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "maslo"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 3},
+		}
+		scheme := minimalScheme(t)
+		require.NoError(t, controllerutil.SetControllerReference(&otherOwner, hpa, scheme))
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(hpa).Build()
+
+		m := fsm.StateMachine{
+			State:  fsm.SystemState{Connection: connection},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		next, result, err := sFnHandleAutoscaling(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandlePodStatus, next)
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, &autoscalingv2.HorizontalPodAutoscaler{}))
+	})
+}
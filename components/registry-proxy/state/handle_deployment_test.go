@@ -14,11 +14,16 @@ import (
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func Test_sFnHandleDeployment(t *testing.T) {
@@ -38,6 +43,8 @@ func Test_sFnHandleDeployment(t *testing.T) {
 			},
 		}).Build()
 
+		recorder := record.NewFakeRecorder(1)
+
 		m := fsm.StateMachine{
 			State: fsm.SystemState{
 				Connection: v1alpha1.Connection{
@@ -55,9 +62,10 @@ func Test_sFnHandleDeployment(t *testing.T) {
 					},
 				},
 			},
-			Log:    zap.NewNop().Sugar(),
-			Client: fakeClient,
-			Scheme: scheme,
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
 		}
 		next, result, err := sFnHandleDeployment(context.Background(), &m)
 
@@ -66,6 +74,7 @@ func Test_sFnHandleDeployment(t *testing.T) {
 		require.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, *result)
 		require.Nil(t, next)
 		require.False(t, updateWasCalled)
+		requireEvents(t, recorder, "Normal DeploymentCreated Deployment connection created")
 
 		requireContainsCondition(t, m.State.Connection.Status,
 			v1alpha1.ConditionConnectionDeployed,
@@ -131,10 +140,10 @@ func Test_sFnHandleDeployment(t *testing.T) {
 		require.False(t, createOrUpdateWasCalled)
 
 	})
-	t.Run("when deployment does not exist on kubernetes and create fails should stop processing", func(t *testing.T) {
+	t.Run("when deployment does not exist on kubernetes and apply fails should stop processing", func(t *testing.T) {
 		scheme := minimalScheme(t)
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects().WithInterceptorFuncs(interceptor.Funcs{
-			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("funny error message")
 			},
 		}).Build()
@@ -170,36 +179,14 @@ func Test_sFnHandleDeployment(t *testing.T) {
 			v1alpha1.ConditionConnectionDeployed,
 			metav1.ConditionFalse,
 			v1alpha1.ConditionReasonDeploymentFailed,
-			"Deployment connection create failed: funny error message")
+			"Deployment connection apply failed: funny error message")
 	})
 	t.Run("when deployment exists on kubernetes but we do not need changes should keep it without changes and go to the next state", func(t *testing.T) {
-		connection := v1alpha1.Connection{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "connection",
-				Namespace: "maslo",
-			},
-			Spec: v1alpha1.ConnectionSpec{
-				Proxy: v1alpha1.ConnectionSpecProxy{
-					URL: "http://test-proxy-url",
-				},
-				Target: v1alpha1.ConnectionSpecTarget{
-					Host: "dummy",
-				},
-			},
-		}
-		deployment := resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0)
+		connection := minimalDeploymentConnection()
 		scheme := minimalScheme(t)
-		createOrUpdateWasCalled := false
-		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).WithInterceptorFuncs(interceptor.Funcs{
-			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
-				createOrUpdateWasCalled = true
-				return nil
-			},
-			Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
-				createOrUpdateWasCalled = true
-				return nil
-			},
-		}).Build()
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyDeployment(t, fakeClient, scheme, connection)
+		deployment := getDeployment(t, fakeClient)
 
 		m := fsm.StateMachine{
 			State: fsm.SystemState{
@@ -216,46 +203,29 @@ func Test_sFnHandleDeployment(t *testing.T) {
 		require.Nil(t, err)
 		require.Nil(t, result)
 		require.NotNil(t, next)
-		requireEqualFunc(t, sFnHandlePodStatus, next)
-		require.False(t, createOrUpdateWasCalled)
+		requireEqualFunc(t, sFnHandlePodDisruptionBudget, next)
 		require.Empty(t, m.State.Connection.Status.Conditions)
 		require.NotNil(t, m.State.Deployment)
+		require.Equal(t, deployment.ResourceVersion, getDeployment(t, fakeClient).ResourceVersion)
 	})
 	t.Run("when deployment exists on kubernetes and we need changes should update it and go to the next state", func(t *testing.T) {
-		connection := v1alpha1.Connection{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "connection",
-				Namespace: "maslo",
-			},
-			Spec: v1alpha1.ConnectionSpec{
-				Proxy: v1alpha1.ConnectionSpecProxy{
-					URL: "http://test-proxy-url",
-				},
-				Target: v1alpha1.ConnectionSpecTarget{
-					Host: "dummy",
-				},
-			},
-		}
-		deployment := resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0)
+		connection := minimalDeploymentConnection()
 		scheme := minimalScheme(t)
-		createWasCalled := false
-		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).WithInterceptorFuncs(interceptor.Funcs{
-			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
-				createWasCalled = true
-				return nil
-			},
-		}).Build()
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyDeployment(t, fakeClient, scheme, connection)
 
 		connection.Spec.Target.Host = "fresh"
+		recorder := record.NewFakeRecorder(1)
 
 		m := fsm.StateMachine{
 			State: fsm.SystemState{
 				Connection: connection,
 				ProxyURL:   connection.Spec.Proxy.URL,
 			},
-			Log:    zap.NewNop().Sugar(),
-			Client: fakeClient,
-			Scheme: scheme,
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
 		}
 
 		next, result, err := sFnHandleDeployment(context.Background(), &m)
@@ -269,39 +239,97 @@ func Test_sFnHandleDeployment(t *testing.T) {
 			metav1.ConditionUnknown,
 			v1alpha1.ConditionReasonDeploymentUpdated,
 			"Deployment connection updated")
-		require.False(t, createWasCalled)
-		updatedDeployment := &appsv1.Deployment{}
-		getErr := fakeClient.Get(context.Background(), client.ObjectKey{
-			Name:      "connection",
-			Namespace: "maslo",
-		}, updatedDeployment)
-		require.NoError(t, getErr)
+		requireEvents(t, recorder, "Normal DeploymentUpdated Deployment connection updated")
 		// deployment should have updated some specific fields
-		require.Contains(t, updatedDeployment.Spec.Template.Spec.Containers[0].Env,
+		require.Contains(t, getDeployment(t, fakeClient).Spec.Template.Spec.Containers[0].Env,
 			corev1.EnvVar{Name: "TARGET_HOST", Value: "fresh"})
 	})
-	t.Run("when deployment exists on kubernetes and update fails should stop processing", func(t *testing.T) {
-		connection := v1alpha1.Connection{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "connection",
-				Namespace: "maslo",
+	t.Run("when fields of the deployment were removed by someone else should restore them", func(t *testing.T) {
+		connection := minimalDeploymentConnection()
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyDeployment(t, fakeClient, scheme, connection)
+
+		drifted := getDeployment(t, fakeClient)
+		drifted.Spec.Template.Spec.Containers[0].Lifecycle = nil
+		drifted.Spec.Template.Spec.Volumes = nil
+		drifted.Spec.Template.Spec.Containers[0].VolumeMounts = nil
+		require.NoError(t, fakeClient.Update(context.Background(), drifted, client.FieldOwner("kubectl-edit")))
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connection,
+				ProxyURL:   connection.Spec.Proxy.URL,
 			},
-			Spec: v1alpha1.ConnectionSpec{
-				Proxy: v1alpha1.ConnectionSpecProxy{
-					URL: "http://test-proxy-url",
-				},
-				Target: v1alpha1.ConnectionSpecTarget{
-					Host: "dummy",
-				},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		next, result, err := sFnHandleDeployment(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, *result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonDeploymentUpdated,
+			"Deployment connection updated")
+		wanted := resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0)
+		restored := getDeployment(t, fakeClient)
+		require.Equal(t, wanted.Spec.Template.Spec.Containers[0].Lifecycle, restored.Spec.Template.Spec.Containers[0].Lifecycle)
+		// quantities are compared by value, the restored ones are parsed from their serialized form
+		require.True(t, equality.Semantic.DeepEqual(wanted.Spec.Template.Spec.Volumes, restored.Spec.Template.Spec.Volumes))
+		require.Equal(t, wanted.Spec.Template.Spec.Containers[0].VolumeMounts, restored.Spec.Template.Spec.Containers[0].VolumeMounts)
+	})
+	t.Run("when fields of the deployment were changed by someone else should take them over and report the conflict", func(t *testing.T) {
+		connection := minimalDeploymentConnection()
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyDeployment(t, fakeClient, scheme, connection)
+
+		drifted := getDeployment(t, fakeClient)
+		drifted.Spec.Replicas = ptr.To[int32](3)
+		drifted.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To[int64](30)
+		require.NoError(t, fakeClient.Update(context.Background(), drifted, client.FieldOwner("kubectl-edit")))
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connection,
+				ProxyURL:   connection.Spec.Proxy.URL,
 			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
 		}
-		deployment := resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0)
+
+		next, result, err := sFnHandleDeployment(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, *result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonResourceConflict,
+			"Deployment connection fields changed by someone else were taken over: "+
+				".spec.replicas (conflict with \"kubectl-edit\" using apps/v1), "+
+				".spec.template.spec.terminationGracePeriodSeconds (conflict with \"kubectl-edit\" using apps/v1)")
+		restored := getDeployment(t, fakeClient)
+		require.Equal(t, ptr.To[int32](1), restored.Spec.Replicas)
+		require.Equal(t, resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0).Spec.Template.Spec.TerminationGracePeriodSeconds, restored.Spec.Template.Spec.TerminationGracePeriodSeconds)
+	})
+	t.Run("when deployment exists on kubernetes and apply fails should stop processing", func(t *testing.T) {
+		connection := minimalDeploymentConnection()
 		scheme := minimalScheme(t)
-		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).WithInterceptorFuncs(interceptor.Funcs{
-			Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyDeployment(t, fakeClient, scheme, connection)
+		failingClient := interceptor.NewClient(fakeClient, interceptor.Funcs{
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("sad error message")
 			},
-		}).Build()
+		})
 
 		connection.Spec.Target.Host = "fresh"
 
@@ -311,7 +339,7 @@ func Test_sFnHandleDeployment(t *testing.T) {
 				ProxyURL:   connection.Spec.Proxy.URL,
 			},
 			Log:    zap.NewNop().Sugar(),
-			Client: fakeClient,
+			Client: failingClient,
 			Scheme: scheme,
 		}
 
@@ -324,6 +352,70 @@ func Test_sFnHandleDeployment(t *testing.T) {
 			v1alpha1.ConditionConnectionDeployed,
 			metav1.ConditionFalse,
 			v1alpha1.ConditionReasonDeploymentFailed,
-			"Deployment connection update failed: sad error message")
+			"Deployment connection apply failed: sad error message")
+	})
+	t.Run("when deployment was updated by an older controller should remove fields not rendered anymore", func(t *testing.T) {
+		connection := minimalDeploymentConnection()
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithReturnManagedFields().Build()
+		legacy := resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, legacy, scheme))
+		legacy.Spec.Template.Spec.Containers[0].Env = append(legacy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "REMOVED", Value: "true"})
+		require.NoError(t, fakeClient.Create(context.Background(), legacy, client.FieldOwner(legacyFieldManager)))
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connection,
+				ProxyURL:   connection.Spec.Proxy.URL,
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		_, _, err := sFnHandleDeployment(context.Background(), &m)
+
+		require.NoError(t, err)
+		upgraded := getDeployment(t, fakeClient)
+		require.NotContains(t, upgraded.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "REMOVED", Value: "true"})
+		for _, entry := range upgraded.ManagedFields {
+			require.NotEqual(t, legacyFieldManager, entry.Manager)
+		}
 	})
 }
+
+func minimalDeploymentConnection() v1alpha1.Connection {
+	return v1alpha1.Connection{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "connection",
+			Namespace: "maslo",
+		},
+		Spec: v1alpha1.ConnectionSpec{
+			Proxy: v1alpha1.ConnectionSpecProxy{
+				URL: "http://test-proxy-url",
+			},
+			Target: v1alpha1.ConnectionSpecTarget{
+				Host: "dummy",
+			},
+		},
+	}
+}
+
+// applyDeployment applies the deployment of the connection like the controller does
+func applyDeployment(t *testing.T, c client.Client, scheme *k8sruntime.Scheme, connection v1alpha1.Connection) {
+	m := fsm.StateMachine{
+		State:  fsm.SystemState{Connection: connection, ProxyURL: connection.Spec.Proxy.URL},
+		Log:    zap.NewNop().Sugar(),
+		Client: c,
+		Scheme: scheme,
+	}
+	result, _, err := applyResource(context.Background(), &m, resources.NewDeployment(&connection, connection.Spec.Proxy.URL, 0))
+	require.NoError(t, err)
+	require.Equal(t, resourceCreated, result)
+}
+
+func getDeployment(t *testing.T, c client.Client) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, deployment))
+	return deployment
+}
@@ -19,6 +19,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func Test_sFnHandlePeerAuthentication(t *testing.T) {
@@ -114,10 +115,10 @@ func Test_sFnHandlePeerAuthentication(t *testing.T) {
 		require.Nil(t, next)
 		require.False(t, createOrUpdateWasCalled)
 	})
-	t.Run("when PeerAuthentication does not exist on kubernetes and create fails should stop processing", func(t *testing.T) {
+	t.Run("when PeerAuthentication does not exist on kubernetes and apply fails should stop processing", func(t *testing.T) {
 		scheme := minimalScheme(t)
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
-			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("funny error message")
 			},
 		}).Build()
@@ -198,7 +199,38 @@ func Test_sFnHandlePeerAuthentication(t *testing.T) {
 		require.NoError(t, getErr)
 		require.Equal(t, updatedPA.Spec.Mtls.Mode, apisecurityv1.PeerAuthentication_MutualTLS_PERMISSIVE)
 	})
-	t.Run("when PeerAuthentication exists on kubernetes and update fails should stop processing", func(t *testing.T) {
+	t.Run("when PeerAuthentication exists on kubernetes and we do not need changes should go to the next state", func(t *testing.T) {
+		connection := v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "connection",
+				Namespace: "maslo",
+			},
+			Spec: v1alpha1.ConnectionSpec{
+				Target: v1alpha1.ConnectionSpecTarget{
+					Host: "dummy",
+				},
+			},
+		}
+		scheme := minimalScheme(t)
+		pa := resources.NewPeerAuthentication(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, pa, scheme))
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pa).Build()
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connection,
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+		next, result, err := sFnHandlePeerAuthentication(context.Background(), &m)
+		require.Nil(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandleStatus, next)
+		require.Empty(t, m.State.Connection.Status.Conditions)
+	})
+	t.Run("when PeerAuthentication exists on kubernetes and apply fails should stop processing", func(t *testing.T) {
 		connection := v1alpha1.Connection{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "connection",
@@ -217,7 +249,7 @@ func Test_sFnHandlePeerAuthentication(t *testing.T) {
 		pa.Spec.Mtls.Mode = apisecurityv1.PeerAuthentication_MutualTLS_STRICT
 		scheme := minimalScheme(t)
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pa).WithInterceptorFuncs(interceptor.Funcs{
-			Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("sad error message")
 			},
 		}).Build()
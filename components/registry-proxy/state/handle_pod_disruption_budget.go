@@ -0,0 +1,31 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/resources"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// sFnHandlePodDisruptionBudget applies the PodDisruptionBudget keeping replicas of the Connection available during node drains
+func sFnHandlePodDisruptionBudget(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	pdb := resources.NewPodDisruptionBudget(&m.State.Connection)
+	result, conflict, err := applyResource(ctx, m, pdb)
+	if err != nil {
+		m.Log.Errorf("failed to apply PodDisruptionBudget %s/%s: %v", pdb.GetNamespace(), pdb.GetName(), err)
+		return stopWithEventualError(err)
+	}
+
+	if result == resourceCreated {
+		emitResourceEvent(m, pdb, "Created")
+		return requeueAfter(time.Minute)
+	}
+	if reportApply(m, pdb, result, conflict, v1alpha1.ConditionReasonResourceUpdated) {
+		return requeueAfter(time.Minute)
+	}
+
+	return nextState(sFnHandleAutoscaling)
+}
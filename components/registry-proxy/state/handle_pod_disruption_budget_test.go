@@ -0,0 +1,124 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_sFnHandlePodDisruptionBudget(t *testing.T) {
+	t.Run("when PodDisruptionBudget does not exist should create it and requeue", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		recorder := record.NewFakeRecorder(1)
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: minimalDeploymentConnection()},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+
+		next, result, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.NoError(t, err)
+		require.Equal(t, &ctrl.Result{RequeueAfter: time.Minute}, result)
+		require.Nil(t, next)
+		requireEvents(t, recorder, "Normal PodDisruptionBudgetCreated PodDisruptionBudget connection created")
+
+		pdb := &policyv1.PodDisruptionBudget{}
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, pdb))
+		require.Equal(t, ptr.To(intstr.FromInt32(1)), pdb.Spec.MaxUnavailable)
+		require.True(t, metav1.IsControlledBy(pdb, &m.State.Connection))
+	})
+
+	t.Run("when PodDisruptionBudget is up to date should go to the next state", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		m := fsm.StateMachine{
+			State:  fsm.SystemState{Connection: minimalDeploymentConnection()},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+		_, _, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.NoError(t, err)
+
+		next, result, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandleAutoscaling, next)
+		require.Empty(t, m.State.Connection.Status.Conditions)
+	})
+
+	t.Run("when PodDisruptionBudget was changed should restore it and requeue", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		recorder := record.NewFakeRecorder(2)
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: minimalDeploymentConnection()},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+		_, _, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.NoError(t, err)
+		requireEvents(t, recorder, "Normal PodDisruptionBudgetCreated PodDisruptionBudget connection created")
+
+		pdb := &policyv1.PodDisruptionBudget{}
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, pdb))
+		pdb.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(0))
+		require.NoError(t, fakeClient.Update(context.Background(), pdb, client.FieldOwner("kubectl-edit")))
+
+		next, result, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.NoError(t, err)
+		require.Equal(t, &ctrl.Result{RequeueAfter: time.Minute}, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionUnknown, v1alpha1.ConditionReasonResourceConflict,
+			"PodDisruptionBudget connection fields changed by someone else were taken over: .spec.maxUnavailable (conflict with \"kubectl-edit\" using policy/v1)")
+		requireEvents(t, recorder, "Warning ResourceConflict PodDisruptionBudget connection fields changed by someone else were taken over: "+
+			".spec.maxUnavailable (conflict with \"kubectl-edit\" using policy/v1)")
+
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, pdb))
+		require.Equal(t, ptr.To(intstr.FromInt32(1)), pdb.Spec.MaxUnavailable)
+	})
+
+	t.Run("when PodDisruptionBudget can't be applied should stop processing", func(t *testing.T) {
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, client client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				return errors.New("typical error message")
+			},
+		}).Build()
+
+		m := fsm.StateMachine{
+			State:  fsm.SystemState{Connection: minimalDeploymentConnection()},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		next, result, err := sFnHandlePodDisruptionBudget(context.Background(), &m)
+		require.EqualError(t, err, "typical error message")
+		require.Nil(t, result)
+		require.Nil(t, next)
+	})
+}
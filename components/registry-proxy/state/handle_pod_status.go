@@ -2,28 +2,33 @@ package state
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/resources"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// sFnHandlePodStatus checks healthz/readyz probes of the latest pod and updates the conditions in the CR
+// sFnHandlePodStatus checks healthz/readyz probes of all pods of the Connection and updates the conditions in the CR
 func sFnHandlePodStatus(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
 	podList := &corev1.PodList{}
 	matchLabels := client.MatchingLabels{}
 	matchLabels[v1alpha1.LabelApp] = m.State.Connection.Name
-	err := m.Client.List(ctx, podList, matchLabels)
+	err := m.Client.List(ctx, podList, client.InNamespace(m.State.Connection.Namespace), matchLabels)
 	if err != nil {
 		return nil, nil, err
 	}
+	pods := activePods(podList)
+	defer emitReadinessChange(m, readyStatus(&m.State.Connection))
 	// check pod's healthz and readyz
-	if len(podList.Items) < 1 {
+	if len(pods) < 1 {
 		// no pod exists, reset conditions and retry
 		m.State.Connection.UpdateCondition(
 			v1alpha1.ConditionConnectionDeployed,
@@ -40,12 +45,17 @@ func sFnHandlePodStatus(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn,
 		return requeueAfter(time.Minute)
 	}
 
-	pod := GetLatestPod(podList)
-	err = handleLivenessStatus(&m.State.Connection, pod.Status.Phase)
+	desired := int32(len(pods))
+	if m.State.Deployment != nil && m.State.Deployment.Spec.Replicas != nil {
+		desired = *m.State.Deployment.Spec.Replicas
+	}
+
+	handleUpstreamStatus(ctx, m, pods)
+	err = handleLivenessStatus(&m.State.Connection, pods, desired)
 	if err != nil {
 		return stopWithEventualError(err)
 	}
-	err = handleReadinessStatus(&m.State.Connection, pod.Status.Conditions)
+	err = handleReadinessStatus(&m.State.Connection, pods, desired)
 	if err != nil {
 		return stopWithEventualError(err)
 	}
@@ -53,18 +63,57 @@ func sFnHandlePodStatus(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn,
 	return nextState(sFnHandlePeerAuthentication)
 }
 
-func handleLivenessStatus(rp *v1alpha1.Connection, phase corev1.PodPhase) error {
-	if phase == corev1.PodRunning {
+// upstreamStatusPod returns the newest ready pod, which serves clients while a new pod of a rollout isn't ready yet,
+// or the newest pod if none is ready, so the reason of failed readiness is reported
+func upstreamStatusPod(pods []corev1.Pod) *corev1.Pod {
+	ready := []corev1.Pod{}
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	if len(ready) > 0 {
+		return GetLatestPod(&corev1.PodList{Items: ready})
+	}
+	return GetLatestPod(&corev1.PodList{Items: pods})
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	condition := getCondition(pod.Status.Conditions, corev1.PodReady)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+// activePods skips pods being terminated, e.g. replaced by a rollout or evicted from a drained node
+func activePods(podList *corev1.PodList) []corev1.Pod {
+	pods := []corev1.Pod{}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// handleLivenessStatus reports the Connection deployed as long as any of its pods is running
+func handleLivenessStatus(rp *v1alpha1.Connection, pods []corev1.Pod, desired int32) error {
+	running := 0
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning {
+			running++
+		}
+	}
+	if running > 0 {
 		rp.UpdateCondition(
 			v1alpha1.ConditionConnectionDeployed,
 			metav1.ConditionTrue,
 			v1alpha1.ConditionReasonResourcesDeployed,
-			"Reverse-proxy ready",
+			fmt.Sprintf("Reverse-proxy ready: %d/%d pods running", min(int32(running), desired), desired),
 		)
 		return nil
 	}
+	pod := GetLatestPod(&corev1.PodList{Items: pods})
 	//nolint: staticcheck
-	err := fmt.Errorf("Reverse-proxy not ready: pod is in phase %s", phase)
+	err := fmt.Errorf("Reverse-proxy not ready: pod is in phase %s", pod.Status.Phase)
 	rp.UpdateCondition(
 		v1alpha1.ConditionConnectionDeployed,
 		metav1.ConditionFalse,
@@ -74,30 +123,36 @@ func handleLivenessStatus(rp *v1alpha1.Connection, phase corev1.PodPhase) error
 	return err
 }
 
-func handleReadinessStatus(rp *v1alpha1.Connection, conditions []corev1.PodCondition) error {
-	condition := getCondition(conditions, corev1.PodReady)
-	if condition == nil {
-		//nolint: staticcheck
-		err := fmt.Errorf("Target registry not reachable: no condition found")
-		rp.UpdateCondition(
-			v1alpha1.ConditionConnectionReady,
-			metav1.ConditionFalse,
-			v1alpha1.ConditionReasonNotEstablished,
-			err.Error(),
-		)
-		return err
+// handleReadinessStatus reports the target registry reachable as long as any replica is ready, the Service sends
+// requests only to ready pods
+func handleReadinessStatus(rp *v1alpha1.Connection, pods []corev1.Pod, desired int32) error {
+	ready := 0
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			ready++
+		}
 	}
-	if condition.Status == corev1.ConditionTrue {
+	if ready > 0 {
 		rp.UpdateCondition(
 			v1alpha1.ConditionConnectionReady,
 			metav1.ConditionTrue,
 			v1alpha1.ConditionReasonEstablished,
-			"Target registry reachable",
+			fmt.Sprintf("Target registry reachable: %d/%d replicas ready", min(int32(ready), desired), desired),
 		)
 		return nil
 	}
+
+	reason := "no condition found"
+	pod := GetLatestPod(&corev1.PodList{Items: pods})
+	if condition := getCondition(pod.Status.Conditions, corev1.PodReady); condition != nil {
+		reason = condition.Reason
+	}
+	if upstream := rp.Status.Upstream; upstream != nil && upstream.Message != "" {
+		// the connection knows better why the target registry can't be used than the pod condition
+		reason = fmt.Sprintf("%s: %s", upstream.State, upstream.Message)
+	}
 	//nolint: staticcheck
-	err := fmt.Errorf("Target registry not reachable: %s", condition.Reason)
+	err := fmt.Errorf("Target registry not reachable: 0/%d replicas ready: %s", desired, reason)
 	rp.UpdateCondition(
 		v1alpha1.ConditionConnectionReady,
 		metav1.ConditionFalse,
@@ -107,6 +162,83 @@ func handleReadinessStatus(rp *v1alpha1.Connection, conditions []corev1.PodCondi
 	return err
 }
 
+// handleUpstreamStatus reports how the target registry answers the status endpoint of the pod serving clients,
+// so the reason of failed readiness is visible in the CR; the previous report is kept if the endpoint can't be read.
+// The version of the authorization Secret is reported once all ready pods use its content.
+func handleUpstreamStatus(ctx context.Context, m *fsm.StateMachine, pods []corev1.Pod) {
+	pod := upstreamStatusPod(pods)
+	if m.HTTPClient == nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return
+	}
+	upstream, credentialsDigest, err := fetchUpstreamStatus(ctx, m.HTTPClient, resources.StatusURL(pod))
+	if err != nil {
+		m.Log.Warnf("unable to read status of pod %s: %v", pod.Name, err)
+		return
+	}
+	m.State.Connection.Status.Upstream = upstream
+	if m.State.CredentialsDigest != "" && credentialsDigest == m.State.CredentialsDigest &&
+		credentialsLoaded(ctx, m, pods, pod.Name) {
+		m.State.Connection.Status.CredentialsVersion = m.State.CredentialsVersion
+	}
+}
+
+// credentialsLoaded returns true if ready pods other than the given one use the content of the authorization Secret,
+// replicas load a rotated Secret at different times
+func credentialsLoaded(ctx context.Context, m *fsm.StateMachine, pods []corev1.Pod, checked string) bool {
+	for _, pod := range pods {
+		if pod.Name == checked || !isPodReady(pod) {
+			continue
+		}
+		if pod.Status.PodIP == "" {
+			return false
+		}
+		_, credentialsDigest, err := fetchUpstreamStatus(ctx, m.HTTPClient, resources.StatusURL(&pod))
+		if err != nil {
+			m.Log.Warnf("unable to read status of pod %s: %v", pod.Name, err)
+			return false
+		}
+		if credentialsDigest != m.State.CredentialsDigest {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchUpstreamStatus reads the status endpoint of the connection, it returns the upstream status
+// and the digest of the authorization Secret loaded by the connection
+func fetchUpstreamStatus(ctx context.Context, httpClient *http.Client, statusURL string) (*v1alpha1.ConnectionUpstreamStatus, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status endpoint returned status code %d", resp.StatusCode)
+	}
+
+	var status struct {
+		State             string     `json:"state"`
+		Message           string     `json:"message"`
+		LastContact       *time.Time `json:"lastContact"`
+		LocationID        string     `json:"locationID"`
+		CredentialsDigest string     `json:"credentialsDigest"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, "", fmt.Errorf("invalid status: %w", err)
+	}
+	upstream := &v1alpha1.ConnectionUpstreamStatus{State: status.State, Message: status.Message, LocationID: status.LocationID}
+	if status.LastContact != nil {
+		// the connection is contacted by every probe, minutes are enough to not update the CR all the time
+		lastContact := metav1.NewTime(status.LastContact.Truncate(time.Minute))
+		upstream.LastContact = &lastContact
+	}
+	return upstream, status.CredentialsDigest, nil
+}
+
 func getCondition(conditions []corev1.PodCondition, conditionType corev1.PodConditionType) *corev1.PodCondition {
 	for _, condition := range conditions {
 		if condition.Type == conditionType {
@@ -2,6 +2,8 @@ package state
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -9,8 +11,11 @@ import (
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -107,7 +112,73 @@ func Test_sFnHandlePodStatus(t *testing.T) {
 		require.Nil(t, result)
 		require.NotNil(t, next)
 		requireEqualFunc(t, sFnHandlePeerAuthentication, next)
-		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionTrue, v1alpha1.ConditionReasonResourcesDeployed, "Reverse-proxy ready")
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionTrue, v1alpha1.ConditionReasonResourcesDeployed, "Reverse-proxy ready: 2/2 pods running")
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionReady, metav1.ConditionTrue, v1alpha1.ConditionReasonEstablished, "Target registry reachable: 1/2 replicas ready")
+	})
+	t.Run("emit events when readiness is lost and regained", func(t *testing.T) {
+		pod := minimalPod(false)
+		scheme := minimalScheme(t)
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+		recorder := record.NewFakeRecorder(2)
+		connection := v1alpha1.Connection{ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "maslo"}}
+		connection.UpdateCondition(v1alpha1.ConditionConnectionReady, metav1.ConditionTrue, v1alpha1.ConditionReasonEstablished, "Target registry reachable: 1/1 replicas ready")
+
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{Connection: connection},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+		}
+
+		_, _, err := sFnHandlePodStatus(context.Background(), &m)
+		require.Error(t, err)
+		requireEvents(t, recorder, "Warning NotReady Target registry not reachable: 0/1 replicas ready: ContainersNotReady")
+
+		// readiness is reported once, not in every reconciliation
+		_, _, err = sFnHandlePodStatus(context.Background(), &m)
+		require.Error(t, err)
+		requireEvents(t, recorder)
+
+		require.NoError(t, fakeClient.Delete(context.Background(), pod))
+		require.NoError(t, fakeClient.Create(context.Background(), minimalPod(true)))
+		_, _, err = sFnHandlePodStatus(context.Background(), &m)
+		require.NoError(t, err)
+		requireEvents(t, recorder, "Normal Ready Target registry reachable: 1/1 replicas ready")
+	})
+	t.Run("count replicas of the deployment and skip terminating pods", func(t *testing.T) {
+		readyPod := minimalPod(true)
+		terminatingPod := minimalPod(true)
+		terminatingPod.Name = "rp-pod2"
+		terminatingPod.Finalizers = []string{"test"}
+		terminatingPod.DeletionTimestamp = ptr.To(metav1.Now())
+		otherNamespacePod := minimalPod(true)
+		otherNamespacePod.Namespace = "wherever"
+		scheme := minimalScheme(t)
+
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(readyPod, terminatingPod, otherNamespacePod).Build()
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "connection",
+						Namespace: "maslo",
+					},
+				},
+				Deployment: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](3)}},
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+
+		next, result, err := sFnHandlePodStatus(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandlePeerAuthentication, next)
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionDeployed, metav1.ConditionTrue, v1alpha1.ConditionReasonResourcesDeployed, "Reverse-proxy ready: 1/3 pods running")
+		requireContainsCondition(t, m.State.Connection.Status, v1alpha1.ConditionConnectionReady, metav1.ConditionTrue, v1alpha1.ConditionReasonEstablished, "Target registry reachable: 1/3 replicas ready")
 	})
 }
 
@@ -115,13 +186,13 @@ func TestHandleProbe(t *testing.T) {
 	tests := []struct {
 		name              string
 		rp                *v1alpha1.Connection
-		phase             corev1.PodPhase
+		phases            []corev1.PodPhase
 		expectedCondition metav1.Condition
 	}{
 		{
-			name:  "should return error not ready",
-			rp:    &v1alpha1.Connection{},
-			phase: corev1.PodPending,
+			name:   "should return error not ready",
+			rp:     &v1alpha1.Connection{},
+			phases: []corev1.PodPhase{corev1.PodPending, corev1.PodPending},
 			expectedCondition: metav1.Condition{
 				Type:    string(v1alpha1.ConditionConnectionDeployed),
 				Status:  metav1.ConditionFalse,
@@ -130,21 +201,25 @@ func TestHandleProbe(t *testing.T) {
 			},
 		},
 		{
-			name:  "should return success",
-			rp:    &v1alpha1.Connection{},
-			phase: corev1.PodRunning,
+			name:   "should return success",
+			rp:     &v1alpha1.Connection{},
+			phases: []corev1.PodPhase{corev1.PodRunning, corev1.PodPending},
 			expectedCondition: metav1.Condition{
 				Type:    string(v1alpha1.ConditionConnectionDeployed),
 				Status:  metav1.ConditionTrue,
 				Reason:  string(v1alpha1.ConditionReasonResourcesDeployed),
-				Message: "Reverse-proxy ready",
+				Message: "Reverse-proxy ready: 1/2 pods running",
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_ = handleLivenessStatus(tt.rp, tt.phase)
+			pods := []corev1.Pod{}
+			for _, phase := range tt.phases {
+				pods = append(pods, corev1.Pod{Status: corev1.PodStatus{Phase: phase}})
+			}
+			_ = handleLivenessStatus(tt.rp, pods, int32(len(pods)))
 			requireContainsCondition(t, tt.rp.Status,
 				v1alpha1.ConditionType(tt.expectedCondition.Type),
 				tt.expectedCondition.Status,
@@ -177,7 +252,27 @@ func TestHandleReadinessStatus(t *testing.T) {
 				Type:    string(v1alpha1.ConditionConnectionReady),
 				Status:  metav1.ConditionFalse,
 				Reason:  string(v1alpha1.ConditionReasonNotEstablished),
-				Message: "Target registry not reachable: ContainersNotReady",
+				Message: "Target registry not reachable: 0/1 replicas ready: ContainersNotReady",
+			},
+		},
+		{
+			name: "should return reason reported by the connection",
+			rp: &v1alpha1.Connection{Status: v1alpha1.ConnectionStatus{Upstream: &v1alpha1.ConnectionUpstreamStatus{
+				State:   "AuthFailed",
+				Message: "target registry rejected credentials with status code 401",
+			}}},
+			conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodReady,
+					Status: corev1.ConditionFalse,
+					Reason: "ContainersNotReady",
+				},
+			},
+			expectedCondition: metav1.Condition{
+				Type:    string(v1alpha1.ConditionConnectionReady),
+				Status:  metav1.ConditionFalse,
+				Reason:  string(v1alpha1.ConditionReasonNotEstablished),
+				Message: "Target registry not reachable: 0/1 replicas ready: AuthFailed: target registry rejected credentials with status code 401",
 			},
 		},
 		{
@@ -188,7 +283,7 @@ func TestHandleReadinessStatus(t *testing.T) {
 				Type:    string(v1alpha1.ConditionConnectionReady),
 				Status:  metav1.ConditionFalse,
 				Reason:  string(v1alpha1.ConditionReasonNotEstablished),
-				Message: "Target registry not reachable: no condition found",
+				Message: "Target registry not reachable: 0/1 replicas ready: no condition found",
 			},
 		},
 		{
@@ -206,14 +301,15 @@ func TestHandleReadinessStatus(t *testing.T) {
 				Type:    string(v1alpha1.ConditionConnectionReady),
 				Status:  metav1.ConditionTrue,
 				Reason:  string(v1alpha1.ConditionReasonEstablished),
-				Message: "Target registry reachable",
+				Message: "Target registry reachable: 1/1 replicas ready",
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_ = handleReadinessStatus(tt.rp, tt.conditions)
+			pods := []corev1.Pod{{Status: corev1.PodStatus{Conditions: tt.conditions}}}
+			_ = handleReadinessStatus(tt.rp, pods, 1)
 			requireContainsCondition(t, tt.rp.Status,
 				v1alpha1.ConditionType(tt.expectedCondition.Type),
 				tt.expectedCondition.Status,
@@ -244,7 +340,7 @@ func minimalPod(ready bool) *corev1.Pod {
 	return &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "rp-pod",
-			Namespace: "wherever",
+			Namespace: "maslo",
 			Labels: map[string]string{
 				v1alpha1.LabelApp: "connection",
 			},
@@ -255,3 +351,122 @@ func minimalPod(ready bool) *corev1.Pod {
 		},
 	}
 }
+
+func TestFetchUpstreamStatus(t *testing.T) {
+	t.Run("should read upstream status of the connection", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ready":false,"state":"TunnelDown","message":"tunnel is down","lastContact":"2026-01-02T03:04:05Z","locationID":"backup","config":{}}`))
+		}))
+		defer server.Close()
+
+		upstream, credentialsDigest, err := fetchUpstreamStatus(context.Background(), server.Client(), server.URL)
+		require.NoError(t, err)
+		require.Empty(t, credentialsDigest)
+		require.Equal(t, "TunnelDown", upstream.State)
+		require.Equal(t, "tunnel is down", upstream.Message)
+		require.Equal(t, "backup", upstream.LocationID)
+		require.NotNil(t, upstream.LastContact)
+		require.True(t, upstream.LastContact.Equal(ptr.To(metav1.NewTime(time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)))))
+	})
+
+	t.Run("should return error on unexpected status code", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		_, _, err := fetchUpstreamStatus(context.Background(), server.Client(), server.URL)
+		require.Error(t, err)
+	})
+}
+
+// roundTripFunc sends requests for any pod to a test server
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestUpstreamStatusPod(t *testing.T) {
+	oldPod := minimalPod(true)
+	oldPod.Name = "old"
+	oldPod.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	newPod := minimalPod(false)
+	newPod.Name = "new"
+	newPod.CreationTimestamp = metav1.NewTime(time.Now())
+
+	t.Run("should read status of ready pod during rollout", func(t *testing.T) {
+		require.Equal(t, "old", upstreamStatusPod([]corev1.Pod{*oldPod, *newPod}).Name)
+	})
+
+	t.Run("should read status of newest pod if none is ready", func(t *testing.T) {
+		notReadyPod := minimalPod(false)
+		notReadyPod.CreationTimestamp = oldPod.CreationTimestamp
+		require.Equal(t, "new", upstreamStatusPod([]corev1.Pod{*notReadyPod, *newPod}).Name)
+	})
+}
+
+func TestHandleUpstreamStatus(t *testing.T) {
+	// newStateMachine answers status requests of pods with the body of their IP
+	newStateMachine := func(t *testing.T, bodies map[string]string) *fsm.StateMachine {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(bodies[r.URL.Query().Get("ip")]))
+		}))
+		t.Cleanup(server.Close)
+		m := &fsm.StateMachine{
+			State: fsm.SystemState{CredentialsVersion: "2", CredentialsDigest: "new"},
+			Log:   zap.NewNop().Sugar(),
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return http.Get(server.URL + "?ip=" + r.URL.Hostname())
+			})},
+		}
+		m.State.Connection.Status.CredentialsVersion = "1"
+		return m
+	}
+	pod := minimalPod(true)
+	pod.Status.PodIP = "10.0.0.1"
+
+	t.Run("should report credentials version loaded by the pod", func(t *testing.T) {
+		m := newStateMachine(t, map[string]string{"10.0.0.1": `{"state":"Healthy","credentialsDigest":"new"}`})
+
+		handleUpstreamStatus(context.Background(), m, []corev1.Pod{*pod})
+
+		require.Equal(t, "Healthy", m.State.Connection.Status.Upstream.State)
+		require.Equal(t, "2", m.State.Connection.Status.CredentialsVersion)
+	})
+
+	t.Run("should keep credentials version until all ready pods load the rotated secret", func(t *testing.T) {
+		otherPod := minimalPod(true)
+		otherPod.Name = "other"
+		otherPod.Status.PodIP = "10.0.0.2"
+		notReadyPod := minimalPod(false)
+		notReadyPod.Name = "not-ready"
+		notReadyPod.Status.PodIP = "10.0.0.3"
+		pods := []corev1.Pod{*pod, *otherPod, *notReadyPod}
+		bodies := map[string]string{
+			"10.0.0.1": `{"state":"Healthy","credentialsDigest":"new"}`,
+			"10.0.0.2": `{"state":"Healthy","credentialsDigest":"old"}`,
+			"10.0.0.3": `{"state":"TunnelDown","credentialsDigest":"old"}`,
+		}
+		m := newStateMachine(t, bodies)
+
+		handleUpstreamStatus(context.Background(), m, pods)
+		require.Equal(t, "1", m.State.Connection.Status.CredentialsVersion)
+
+		bodies["10.0.0.2"] = `{"state":"Healthy","credentialsDigest":"new"}`
+		handleUpstreamStatus(context.Background(), m, pods)
+		require.Equal(t, "2", m.State.Connection.Status.CredentialsVersion)
+	})
+
+	t.Run("should keep credentials version until the pod loads the rotated secret", func(t *testing.T) {
+		m := newStateMachine(t, map[string]string{"10.0.0.1": `{"state":"Healthy","credentialsDigest":"old"}`})
+
+		handleUpstreamStatus(context.Background(), m, []corev1.Pod{*pod})
+
+		require.Equal(t, "1", m.State.Connection.Status.CredentialsVersion)
+		next, result, err := sFnHandleStatus(context.Background(), m)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, &ctrl.Result{RequeueAfter: credentialsCheckInterval}, result)
+	})
+}
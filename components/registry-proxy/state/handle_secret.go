@@ -0,0 +1,54 @@
+package state
+
+import (
+	"context"
+
+	"github.com/kyma-project/registry-proxy/components/common/secretdigest"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/validation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sFnHandleSecret checks the authorization secret holds the keys the connection reads and remembers its version,
+// which is reported once the connection loads it. The connection reloads the content on its own, so the deployment
+// doesn't change with it.
+func sFnHandleSecret(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	authorization := m.State.Connection.Spec.Target.Authorization
+	secretName := authorization.SecretName()
+	if secretName == "" {
+		m.State.Connection.Status.CredentialsVersion = ""
+		return nextState(sFnHandleDeployment)
+	}
+
+	// secrets aren't cached by the controller, only their metadata is watched
+	secret := &corev1.Secret{}
+	err := m.Client.Get(ctx, client.ObjectKey{
+		Namespace: m.State.Connection.GetNamespace(),
+		Name:      secretName,
+	}, secret)
+	if errors.IsNotFound(err) {
+		// the secret is watched, reconciliation starts again when it's created
+		m.State.Connection.Status.CredentialsVersion = ""
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonSecretNotFound, validation.SecretNotFound(authorization).Error())
+	}
+	if err != nil {
+		m.Log.Error(err, "unable to fetch authorization Secret for Connection")
+		return stopWithEventualError(err)
+	}
+	if invalid := validation.SecretKeys(authorization, secret); invalid != nil {
+		m.State.Connection.Status.CredentialsVersion = ""
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidSecret, invalid.Error())
+	}
+
+	data := map[string]string{}
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	m.State.CredentialsVersion = secret.GetResourceVersion()
+	m.State.CredentialsDigest = secretdigest.Sum(data)
+	return nextState(sFnHandleDeployment)
+}
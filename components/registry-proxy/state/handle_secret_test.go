@@ -0,0 +1,166 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/common/secretdigest"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func connectionWithHeaderSecret(secretName string) v1alpha1.Connection {
+	return v1alpha1.Connection{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "connection",
+			Namespace: "maslo",
+		},
+		Spec: v1alpha1.ConnectionSpec{
+			Target: v1alpha1.ConnectionSpecTarget{
+				Host: "dummy",
+				Authorization: v1alpha1.ConnectionSpecTargetAuthorization{
+					HeaderSecret: secretName,
+				},
+			},
+		},
+	}
+}
+
+func Test_sFnHandleSecret(t *testing.T) {
+	t.Run("when no secret is used should go to the next state", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connectionWithHeaderSecret(""),
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fake.NewClientBuilder().WithScheme(minimalScheme(t)).Build(),
+		}
+		m.State.Connection.Status.CredentialsVersion = "123"
+
+		next, result, err := sFnHandleSecret(context.Background(), &m)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandleDeployment, next)
+		require.Empty(t, m.State.Connection.Status.CredentialsVersion)
+	})
+
+	t.Run("when secret exists should remember its version and go to the next state", func(t *testing.T) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "auth-secret",
+				Namespace: "maslo",
+			},
+			Data: map[string][]byte{"authorizationHeader": []byte("Basic dXNlcjpwYXNz")},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(minimalScheme(t)).WithObjects(secret).Build()
+		stored := &corev1.Secret{}
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(secret), stored))
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connectionWithHeaderSecret("auth-secret"),
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+		}
+
+		next, result, err := sFnHandleSecret(context.Background(), &m)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHandleDeployment, next)
+		require.NotEmpty(t, m.State.CredentialsVersion)
+		require.Equal(t, stored.ResourceVersion, m.State.CredentialsVersion)
+		require.Equal(t, secretdigest.Sum(map[string]string{"authorizationHeader": "Basic dXNlcjpwYXNz"}), m.State.CredentialsDigest)
+		// the version is reported once the connection loads the secret
+		require.Empty(t, m.State.Connection.Status.CredentialsVersion)
+	})
+
+	t.Run("when secret does not exist should stop and set condition", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(1)
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connectionWithHeaderSecret("auth-secret"),
+			},
+			Log:      zap.NewNop().Sugar(),
+			Client:   fake.NewClientBuilder().WithScheme(minimalScheme(t)).Build(),
+			Recorder: recorder,
+		}
+
+		next, result, err := sFnHandleSecret(context.Background(), &m)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonSecretNotFound,
+			"spec.target.authorization.headerSecret: Not found: \"auth-secret\"")
+		requireEvents(t, recorder, "Warning SecretNotFound spec.target.authorization.headerSecret: Not found: \"auth-secret\"")
+
+		// the missing secret is reported once, until it's created
+		_, _, err = sFnHandleSecret(context.Background(), &m)
+		require.NoError(t, err)
+		requireEvents(t, recorder)
+	})
+
+	t.Run("when secret misses the key read by the connection should stop and set condition", func(t *testing.T) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "auth-secret",
+				Namespace: "maslo",
+			},
+			Data: map[string][]byte{"header": []byte("Basic dXNlcjpwYXNz")},
+		}
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connectionWithHeaderSecret("auth-secret"),
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fake.NewClientBuilder().WithScheme(minimalScheme(t)).WithObjects(secret).Build(),
+		}
+
+		next, result, err := sFnHandleSecret(context.Background(), &m)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		require.Empty(t, m.State.Connection.Status.CredentialsVersion)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidSecret,
+			"spec.target.authorization.headerSecret: Invalid value: \"auth-secret\": Secret must contain the authorizationHeader key")
+	})
+
+	t.Run("when cannot get secret should stop with error", func(t *testing.T) {
+		fakeClient := fake.NewClientBuilder().WithScheme(minimalScheme(t)).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, client client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				return errors.New("typical error message")
+			},
+		}).Build()
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connectionWithHeaderSecret("auth-secret"),
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+		}
+
+		next, result, err := sFnHandleSecret(context.Background(), &m)
+
+		require.EqualError(t, err, "typical error message")
+		require.Nil(t, result)
+		require.Nil(t, next)
+	})
+}
@@ -13,10 +13,12 @@ import (
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func Test_sFnHandleService(t *testing.T) {
@@ -28,6 +30,7 @@ func Test_sFnHandleService(t *testing.T) {
 			},
 		}
 		scheme := minimalScheme(t)
+		recorder := record.NewFakeRecorder(1)
 		updateWasCalled := false
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&someService).WithInterceptorFuncs(interceptor.Funcs{
 			Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
@@ -53,9 +56,10 @@ func Test_sFnHandleService(t *testing.T) {
 					},
 				},
 			},
-			Log:    zap.NewNop().Sugar(),
-			Client: fakeClient,
-			Scheme: scheme,
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
 		}
 		next, result, err := sFnHandleService(context.Background(), &m)
 		require.Nil(t, err)
@@ -63,6 +67,7 @@ func Test_sFnHandleService(t *testing.T) {
 		require.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, *result)
 		require.Nil(t, next)
 		require.False(t, updateWasCalled)
+		require.Equal(t, "Normal ServiceCreated Service connection created", <-recorder.Events)
 	})
 
 	t.Run("when cannot get service from kubernetes should stop processing", func(t *testing.T) {
@@ -111,10 +116,11 @@ func Test_sFnHandleService(t *testing.T) {
 		require.False(t, createOrUpdateWasCalled)
 	})
 
-	t.Run("when service does not exist on kubernetes and create fails should stop processing", func(t *testing.T) {
+	t.Run("when service does not exist on kubernetes and apply fails should stop processing", func(t *testing.T) {
 		scheme := minimalScheme(t)
+		recorder := record.NewFakeRecorder(1)
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
-			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("funny error message")
 			},
 		}).Build()
@@ -136,15 +142,17 @@ func Test_sFnHandleService(t *testing.T) {
 					},
 				},
 			},
-			Log:    zap.NewNop().Sugar(),
-			Client: fakeClient,
-			Scheme: scheme,
+			Log:      zap.NewNop().Sugar(),
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
 		}
 		next, result, err := sFnHandleService(context.Background(), &m)
 		require.NotNil(t, err)
 		require.ErrorContains(t, err, "funny error message")
 		require.Nil(t, result)
 		require.Nil(t, next)
+		require.Equal(t, "Warning ServiceFailed Service connection apply failed: funny error message", <-recorder.Events)
 	})
 
 	t.Run("when deployment exists on kubernetes, no changes in Service needed, and NodePort is empty, requeue", func(t *testing.T) {
@@ -162,8 +170,9 @@ func Test_sFnHandleService(t *testing.T) {
 				},
 			},
 		}
-		service := resources.NewService(&connection)
 		scheme := minimalScheme(t)
+		service := resources.NewService(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, service, scheme))
 		createOrUpdateWasCalled := false
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).WithInterceptorFuncs(interceptor.Funcs{
 			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
@@ -208,9 +217,10 @@ func Test_sFnHandleService(t *testing.T) {
 				},
 			},
 		}
+		scheme := minimalScheme(t)
 		service := resources.NewService(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, service, scheme))
 		service.Spec.Ports[0].NodePort = 1234
-		scheme := minimalScheme(t)
 		createOrUpdateWasCalled := false
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).WithInterceptorFuncs(interceptor.Funcs{
 			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
@@ -235,13 +245,13 @@ func Test_sFnHandleService(t *testing.T) {
 		require.Nil(t, err)
 		require.Nil(t, result)
 		require.NotNil(t, next)
-		requireEqualFunc(t, sFnHandleDeployment, next)
+		requireEqualFunc(t, sFnHandleSecret, next)
 		require.False(t, createOrUpdateWasCalled)
 		require.Empty(t, m.State.Connection.Status.Conditions)
 		require.NotNil(t, m.State.Service)
 		require.Equal(t, int32(1234), m.State.NodePort)
 	})
-	t.Run("when service exists on kubernetes and we need changes should update it requeue", func(t *testing.T) {
+	t.Run("when type of the service was changed by someone else should take it over and requeue", func(t *testing.T) {
 		connection := v1alpha1.Connection{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "connection",
@@ -256,9 +266,10 @@ func Test_sFnHandleService(t *testing.T) {
 				},
 			},
 		}
+		scheme := minimalScheme(t)
 		service := resources.NewService(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, service, scheme))
 		service.Spec.Type = corev1.ServiceTypeClusterIP
-		scheme := minimalScheme(t)
 		createWasCalled := false
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).WithInterceptorFuncs(interceptor.Funcs{
 			Create: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
@@ -288,9 +299,55 @@ func Test_sFnHandleService(t *testing.T) {
 		}, updatedService)
 		require.NoError(t, getErr)
 		require.Equal(t, updatedService.Spec.Type, corev1.ServiceTypeNodePort)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonResourceConflict,
+			"Service connection fields changed by someone else were taken over: .spec.type (conflict with \"before-first-apply\" using v1)")
+	})
+	t.Run("when service exists on kubernetes without ports of the connection should add them", func(t *testing.T) {
+		connection := v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "connection",
+				Namespace: "maslo",
+			},
+			Spec: v1alpha1.ConnectionSpec{
+				Target: v1alpha1.ConnectionSpecTarget{
+					Host: "dummy",
+				},
+			},
+		}
+		scheme := minimalScheme(t)
+		service := resources.NewService(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, service, scheme))
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).Build()
+
+		connection.Spec.NodePort = 30500
+		connection.Spec.Target.Authorization.Host = "auth.dummy"
+
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: connection,
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fakeClient,
+			Scheme: scheme,
+		}
+		next, result, err := sFnHandleService(context.Background(), &m)
+		require.Nil(t, err)
+		require.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, *result)
+		require.Nil(t, next)
+		updatedService := &corev1.Service{}
+		require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKey{Name: "connection", Namespace: "maslo"}, updatedService))
+		require.Equal(t, resources.NewService(&connection).Spec.Ports, updatedService.Spec.Ports)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionDeployed,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonResourceUpdated,
+			"Service connection updated")
 	})
 
-	t.Run("when deployment exists on kubernetes and update fails should stop processing", func(t *testing.T) {
+	t.Run("when service exists on kubernetes and apply fails should stop processing", func(t *testing.T) {
 		connection := v1alpha1.Connection{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "connection",
@@ -305,11 +362,12 @@ func Test_sFnHandleService(t *testing.T) {
 				},
 			},
 		}
+		scheme := minimalScheme(t)
 		service := resources.NewService(&connection)
+		require.NoError(t, controllerutil.SetControllerReference(&connection, service, scheme))
 		service.Spec.Type = corev1.ServiceTypeClusterIP
-		scheme := minimalScheme(t)
 		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).WithInterceptorFuncs(interceptor.Funcs{
-			Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			Patch: func(ctx context.Context, client client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
 				return errors.New("sad error message")
 			},
 		}).Build()
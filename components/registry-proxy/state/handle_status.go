@@ -2,14 +2,26 @@ package state
 
 import (
 	"context"
+	"time"
 
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// credentialsCheckInterval is how often the connection is asked if it loaded the rotated authorization Secret
+const credentialsCheckInterval = 30 * time.Second
+
 func sFnHandleStatus(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	if m.State.NodePort != 0 && m.State.NodePort != m.State.Connection.Status.NodePort {
+		m.Eventf(corev1.EventTypeNormal, eventReasonNodePortAssigned, "Connection exposed on node port %d", m.State.NodePort)
+	}
 	// update ProxyURL & NodePort
 	m.State.Connection.Status.ProxyURL = m.State.ProxyURL
 	m.State.Connection.Status.NodePort = m.State.NodePort
+	if m.HTTPClient != nil && m.State.CredentialsVersion != m.State.Connection.Status.CredentialsVersion {
+		// kubelet updates the mounted Secret with a delay, check again until the connection loads it
+		return requeueAfter(credentialsCheckInterval)
+	}
 	return nextState(nil)
 }
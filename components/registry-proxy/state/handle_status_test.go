@@ -0,0 +1,33 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_sFnHandleStatus(t *testing.T) {
+	t.Run("should emit event when the node port is assigned", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(1)
+		m := fsm.StateMachine{
+			State:    fsm.SystemState{ProxyURL: "http://proxy", NodePort: 32000},
+			Log:      zap.NewNop().Sugar(),
+			Recorder: recorder,
+		}
+
+		next, result, err := sFnHandleStatus(context.Background(), &m)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		require.Equal(t, int32(32000), m.State.Connection.Status.NodePort)
+		requireEvents(t, recorder, "Normal NodePortAssigned Connection exposed on node port 32000")
+
+		_, _, err = sFnHandleStatus(context.Background(), &m)
+		require.NoError(t, err)
+		requireEvents(t, recorder)
+	})
+}
@@ -43,5 +43,5 @@ func stopWithErrorOrRequeue(err error) (fsm.StateFn, *ctrl.Result, error) {
 }
 
 func StartState() fsm.StateFn {
-	return sFnValidateReverseProxyURL
+	return sFnValidate
 }
@@ -2,24 +2,61 @@ package state
 
 import (
 	"context"
-	"fmt"
-	"net/url"
 
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/validation"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-func sFnValidateReverseProxyURL(_ context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
-	_, err := url.Parse(m.State.Connection.Spec.Proxy.URL)
+// sFnValidate checks the configuration of the Connection with the rules of the validating webhook,
+// Connections created while the webhook wasn't available get the same messages in their conditions
+func sFnValidate(ctx context.Context, m *fsm.StateMachine) (fsm.StateFn, *ctrl.Result, error) {
+	if err := validation.ProxyURL(m.State.Connection.Spec.Proxy); err != nil {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidProxyURL, err.Error())
+	}
+
+	if errs := validation.Target(m.State.Connection.Spec.Target); len(errs) != 0 {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidTarget, errs.ToAggregate().Error())
+	}
+
+	if errs := validation.Headers(m.State.Connection.Spec.Headers); len(errs) != 0 {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidHeaders, errs.ToAggregate().Error())
+	}
+
+	if err := validation.Cache(m.State.Connection.Spec); err != nil {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidCache, err.Error())
+	}
+
+	if err := validation.Limits(m.State.Connection.Spec.Limits); err != nil {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidLimits, err.Error())
+	}
+
+	if err := validation.Coalescing(m.State.Connection.Spec.Coalescing); err != nil {
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonInvalidCoalescing, err.Error())
+	}
+
+	conflict, err := validation.NodePort(ctx, m.Client, &m.State.Connection)
 	if err != nil {
-		m.State.Connection.UpdateCondition(
-			v1alpha1.ConditionConnectionReady,
-			metav1.ConditionFalse,
-			v1alpha1.ConditionReasonInvalidProxyURL,
-			fmt.Sprintf("Invalid Connectivity Proxy URL: %s", err.Error()))
-		return stop()
+		m.Log.Error(err, "unable to check node port of Connection")
+		return stopWithEventualError(err)
 	}
+	if conflict != nil {
+		// the Service can't be created, reconciliation starts again when the Connection changes
+		return stopWithInvalidCondition(m, v1alpha1.ConditionReasonNodePortConflict, conflict.Error())
+	}
+
 	return nextState(sFnConnectivityProxyURL)
 }
+
+// stopWithInvalidCondition reports the configuration error in the ConnectionReady condition and a warning event
+func stopWithInvalidCondition(m *fsm.StateMachine, reason v1alpha1.ConditionReason, message string) (fsm.StateFn, *ctrl.Result, error) {
+	updateConditionWithEvent(m, corev1.EventTypeWarning,
+		v1alpha1.ConditionConnectionReady,
+		metav1.ConditionFalse,
+		reason,
+		message)
+	return stop()
+}
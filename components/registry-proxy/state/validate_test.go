@@ -8,10 +8,15 @@ import (
 	"github.com/kyma-project/registry-proxy/components/registry-proxy/fsm"
 
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
-func Test_sFnValidateReverseProxyURL(t *testing.T) {
+func Test_sFnValidate(t *testing.T) {
 	t.Run("when function is valid should go to the next state", func(t *testing.T) {
 		m := fsm.StateMachine{
 			State: fsm.SystemState{
@@ -24,12 +29,15 @@ func Test_sFnValidateReverseProxyURL(t *testing.T) {
 						Proxy: v1alpha1.ConnectionSpecProxy{
 							URL: "http://test-proxy-url",
 						},
+						Target: v1alpha1.ConnectionSpecTarget{
+							Host: "myregistry.example.com:5000",
+						},
 					},
 				},
 			},
 		}
 
-		next, result, err := sFnValidateReverseProxyURL(context.Background(), &m)
+		next, result, err := sFnValidate(context.Background(), &m)
 
 		// Assert
 		// no errors
@@ -59,7 +67,7 @@ func Test_sFnValidateReverseProxyURL(t *testing.T) {
 			},
 		}
 
-		next, result, err := sFnValidateReverseProxyURL(context.Background(), &m)
+		next, result, err := sFnValidate(context.Background(), &m)
 
 		// Assert
 		// no errors
@@ -73,7 +81,188 @@ func Test_sFnValidateReverseProxyURL(t *testing.T) {
 			v1alpha1.ConditionConnectionReady,
 			metav1.ConditionFalse,
 			v1alpha1.ConditionReasonInvalidProxyURL,
-			"Invalid Connectivity Proxy URL: parse \":thisURLisbroken\": missing protocol scheme")
+			"spec.proxy.url: Invalid value: \":thisURLisbroken\": parse \":thisURLisbroken\": missing protocol scheme")
+
+	})
+	t.Run("when scheme doesn't match protocol should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Proxy: v1alpha1.ConnectionSpecProxy{
+							URL:      "http://test-proxy-url",
+							Protocol: "socks5",
+						},
+					},
+				},
+			},
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidProxyURL,
+			"spec.proxy.url: Invalid value: \"http://test-proxy-url\": scheme \"http\" doesn't match protocol \"socks5\"")
+	})
+
+	t.Run("when target host is invalid should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Target: v1alpha1.ConnectionSpecTarget{
+							Host: "https://myregistry.example.com",
+						},
+					},
+				},
+			},
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidTarget,
+			"spec.target.host: Invalid value: \"https://myregistry.example.com\": must have the host[:port] form, e.g. myregistry.example.com:5000")
+	})
+	t.Run("when headers are managed by the connection should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Target: v1alpha1.ConnectionSpecTarget{
+							Host: "myregistry.example.com",
+						},
+						Headers: v1alpha1.ConnectionSpecHeaders{
+							StripRequest: []string{"Authorization"},
+						},
+					},
+				},
+			},
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidHeaders,
+			"spec.headers.stripRequest[0]: Forbidden: Authorization is managed by the connection")
+	})
+
+	t.Run("when cache claim is shared by many replicas should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Target:   v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com"},
+						Cache:    &v1alpha1.ConnectionSpecCache{PersistentVolumeClaim: "layers"},
+						Replicas: 3,
+					},
+				},
+			},
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidCache,
+			"spec.cache.persistentVolumeClaim: Forbidden: can't be shared by more than one replica")
+	})
+
+	t.Run("when request rate isn't positive should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Target: v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com"},
+						Limits: &v1alpha1.ConnectionSpecLimits{RequestsPerSecond: ptr.To(resource.MustParse("-1"))},
+					},
+				},
+			},
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidLimits,
+			"spec.limits.requestsPerSecond: Invalid value: \"-1\": must be greater than 0")
+	})
+
+	t.Run("when spool size is negative should stop processing", func(t *testing.T) {
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					Spec: v1alpha1.ConnectionSpec{
+						Target:     v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com"},
+						Coalescing: &v1alpha1.ConnectionSpecCoalescing{SpoolSize: ptr.To(resource.MustParse("-1"))},
+					},
+				},
+			},
+		}
 
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonInvalidCoalescing,
+			"spec.coalescing.spoolSize: Invalid value: \"-1\": must not be negative")
+	})
+
+	t.Run("when node port is used by another Service should stop processing", func(t *testing.T) {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{NodePort: 30500}}},
+		}
+		m := fsm.StateMachine{
+			State: fsm.SystemState{
+				Connection: v1alpha1.Connection{
+					ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "maslo"},
+					Spec: v1alpha1.ConnectionSpec{
+						Target:   v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com:5000"},
+						NodePort: 30500,
+					},
+				},
+			},
+			Log:    zap.NewNop().Sugar(),
+			Client: fake.NewClientBuilder().WithScheme(minimalScheme(t)).WithObjects(service).Build(),
+		}
+
+		next, result, err := sFnValidate(context.Background(), &m)
+
+		require.Nil(t, err)
+		require.Nil(t, result)
+		require.Nil(t, next)
+		requireContainsCondition(t, m.State.Connection.Status,
+			v1alpha1.ConditionConnectionReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonNodePortConflict,
+			"spec.nodePort: Invalid value: 30500: already used by Service default/other")
 	})
 }
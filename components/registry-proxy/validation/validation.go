@@ -0,0 +1,237 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AuthorizationHeaderKey is the key of the header secret read by the connection
+	AuthorizationHeaderKey = "authorizationHeader"
+	// UsernameKey and PasswordKey are the keys of the credentials secret read by the connection
+	UsernameKey = "username"
+	PasswordKey = "password"
+)
+
+var (
+	proxyPath         = field.NewPath("spec", "proxy")
+	targetPath        = field.NewPath("spec", "target")
+	authorizationPath = targetPath.Child("authorization")
+	nodePortPath      = field.NewPath("spec", "nodePort")
+	headersPath       = field.NewPath("spec", "headers")
+	cachePath         = field.NewPath("spec", "cache")
+	limitsPath        = field.NewPath("spec", "limits")
+	coalescingPath    = field.NewPath("spec", "coalescing")
+)
+
+// managedHeaders are set by the connection on forwarded requests, e.g. the Authorization header with its credentials,
+// so they can't be added or stripped
+var managedHeaders = []string{"Authorization", "Host", "Proxy-Authorization", "SAP-Connectivity-SCC-Location_ID", "X-Forwarded-Host"}
+
+// ProxyURL checks the Connectivity Proxy URL is absolute and its scheme matches the protocol
+func ProxyURL(proxy v1alpha1.ConnectionSpecProxy) *field.Error {
+	if proxy.URL == "" {
+		// the URL is taken from the module configuration or the Connectivity Proxy
+		return nil
+	}
+	path := proxyPath.Child("url")
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		return field.Invalid(path, proxy.URL, err.Error())
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return field.Invalid(path, proxy.URL, "must be an absolute URL with a scheme, e.g. http://connectivity-proxy.kyma-system.svc.cluster.local:20003")
+	}
+	if (proxyURL.Scheme == "socks5") != (proxy.Protocol == "socks5") {
+		return field.Invalid(path, proxy.URL, fmt.Sprintf("scheme %q doesn't match protocol %q", proxyURL.Scheme, proxy.Protocol))
+	}
+	return nil
+}
+
+// Target checks hosts of the target registry and that only one authorization method is used
+func Target(target v1alpha1.ConnectionSpecTarget) field.ErrorList {
+	var errs field.ErrorList
+	if err := hostPort(target.Host); err != nil {
+		errs = append(errs, field.Invalid(targetPath.Child("host"), target.Host, err.Error()))
+	}
+	authorization := target.Authorization
+	if authorization.Host != "" {
+		if err := hostPort(authorization.Host); err != nil {
+			errs = append(errs, field.Invalid(authorizationPath.Child("host"), authorization.Host, err.Error()))
+		}
+		// the connection gets tokens from the authorization host, a static header would replace them
+		if authorization.HeaderSecret != "" {
+			errs = append(errs, field.Forbidden(authorizationPath.Child("headerSecret"), "can't be used together with host"))
+		}
+		if authorization.CredentialsSecret != "" {
+			errs = append(errs, field.Forbidden(authorizationPath.Child("credentialsSecret"), "can't be used together with host"))
+		}
+	}
+	if authorization.HeaderSecret != "" && authorization.CredentialsSecret != "" {
+		errs = append(errs, field.Forbidden(authorizationPath.Child("credentialsSecret"), "can't be used together with headerSecret"))
+	}
+	return errs
+}
+
+// Headers checks headers added to or stripped from requests aren't managed by the connection
+func Headers(headers v1alpha1.ConnectionSpecHeaders) field.ErrorList {
+	var errs field.ErrorList
+	for i, header := range headers.Add {
+		if isManagedHeader(header.Name) {
+			errs = append(errs, field.Forbidden(headersPath.Child("add").Index(i).Child("name"),
+				fmt.Sprintf("%s is managed by the connection", header.Name)))
+		}
+	}
+	for i, name := range headers.StripRequest {
+		if isManagedHeader(name) {
+			errs = append(errs, field.Forbidden(headersPath.Child("stripRequest").Index(i),
+				fmt.Sprintf("%s is managed by the connection", name)))
+		}
+	}
+	return errs
+}
+
+// Cache checks a cache in a PersistentVolumeClaim isn't shared by many replicas, they would remove each other's
+// downloads and evict layers other replicas still index
+func Cache(spec v1alpha1.ConnectionSpec) *field.Error {
+	if spec.Cache == nil || spec.Cache.PersistentVolumeClaim == "" {
+		return nil
+	}
+	if spec.Autoscaling != nil || spec.DesiredReplicas() > 1 {
+		return field.Forbidden(cachePath.Child("persistentVolumeClaim"), "can't be shared by more than one replica")
+	}
+	return nil
+}
+
+// Limits checks the request rate is positive, the CRD has no minimum for quantities
+func Limits(limits *v1alpha1.ConnectionSpecLimits) *field.Error {
+	if limits == nil || limits.RequestsPerSecond == nil || limits.RequestsPerSecond.Sign() > 0 {
+		return nil
+	}
+	return field.Invalid(limitsPath.Child("requestsPerSecond"), limits.RequestsPerSecond.String(), "must be greater than 0")
+}
+
+// Coalescing checks the spool size isn't negative, the CRD has no minimum for quantities
+func Coalescing(coalescing *v1alpha1.ConnectionSpecCoalescing) *field.Error {
+	if coalescing == nil || coalescing.SpoolSize == nil || coalescing.SpoolSize.Sign() >= 0 {
+		return nil
+	}
+	return field.Invalid(coalescingPath.Child("spoolSize"), coalescing.SpoolSize.String(), "must not be negative")
+}
+
+func isManagedHeader(name string) bool {
+	for _, managed := range managedHeaders {
+		if strings.EqualFold(name, managed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort checks the value has the host[:port] form without a scheme, path or user
+func hostPort(value string) error {
+	u, err := url.Parse("//" + value)
+	if err != nil || u.Host != value || u.User != nil || u.Hostname() == "" {
+		return fmt.Errorf("must have the host[:port] form, e.g. myregistry.example.com:5000")
+	}
+	if _, portValue, err := net.SplitHostPort(value); err == nil {
+		if port, err := strconv.Atoi(portValue); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("port must be a number between 1 and 65535")
+		}
+	}
+	return nil
+}
+
+// Secret checks the authorization Secret of the Connection exists and holds the keys the connection reads,
+// an error is returned only if the Secret can't be read
+func Secret(ctx context.Context, r client.Reader, connection *v1alpha1.Connection) (*field.Error, error) {
+	authorization := connection.Spec.Target.Authorization
+	if authorization.SecretName() == "" {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: connection.GetNamespace(), Name: authorization.SecretName()}, secret)
+	if errors.IsNotFound(err) {
+		return SecretNotFound(authorization), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return SecretKeys(authorization, secret), nil
+}
+
+// SecretNotFound is the error for a missing authorization Secret
+func SecretNotFound(authorization v1alpha1.ConnectionSpecTargetAuthorization) *field.Error {
+	path, _ := secretField(authorization)
+	return field.NotFound(path, authorization.SecretName())
+}
+
+// SecretKeys checks the authorization Secret holds the keys the connection reads
+func SecretKeys(authorization v1alpha1.ConnectionSpecTargetAuthorization, secret *corev1.Secret) *field.Error {
+	path, keys := secretField(authorization)
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			return field.Invalid(path, authorization.SecretName(), fmt.Sprintf("Secret must contain the %s key", key))
+		}
+	}
+	return nil
+}
+
+// secretField returns the path of the field referencing the authorization Secret and the keys it must contain
+func secretField(authorization v1alpha1.ConnectionSpecTargetAuthorization) (*field.Path, []string) {
+	if authorization.HeaderSecret != "" {
+		return authorizationPath.Child("headerSecret"), []string{AuthorizationHeaderKey}
+	}
+	return authorizationPath.Child("credentialsSecret"), []string{UsernameKey, PasswordKey}
+}
+
+// NodePort checks the node port requested by the Connection isn't used by other Connections or Services,
+// an error is returned only if they can't be listed
+func NodePort(ctx context.Context, r client.Reader, connection *v1alpha1.Connection) (*field.Error, error) {
+	nodePort := connection.Spec.NodePort
+	if nodePort == 0 {
+		return nil, nil
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services); err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		own := service.Namespace == connection.Namespace && service.Name == connection.Name
+		for _, port := range service.Spec.Ports {
+			if port.NodePort != nodePort {
+				continue
+			}
+			if own {
+				// the port is already allocated for the Connection
+				return nil, nil
+			}
+			return field.Invalid(nodePortPath, nodePort, fmt.Sprintf("already used by Service %s/%s", service.Namespace, service.Name)), nil
+		}
+	}
+
+	connections := &v1alpha1.ConnectionList{}
+	if err := r.List(ctx, connections); err != nil {
+		return nil, err
+	}
+	for _, other := range connections.Items {
+		if other.Namespace == connection.Namespace && other.Name == connection.Name {
+			continue
+		}
+		if other.Spec.NodePort == nodePort || other.Status.NodePort == nodePort {
+			return field.Invalid(nodePortPath, nodePort, fmt.Sprintf("already used by Connection %s/%s", other.Namespace, other.Name)), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,329 @@
+package validation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestProxyURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   v1alpha1.ConnectionSpecProxy
+		wantErr string
+	}{
+		{name: "empty", proxy: v1alpha1.ConnectionSpecProxy{}},
+		{name: "http", proxy: v1alpha1.ConnectionSpecProxy{URL: "http://connectivity-proxy.kyma-system.svc.cluster.local:20003"}},
+		{name: "socks5", proxy: v1alpha1.ConnectionSpecProxy{URL: "socks5://connectivity-proxy.kyma-system.svc.cluster.local:20004", Protocol: "socks5"}},
+		{
+			name:    "without scheme",
+			proxy:   v1alpha1.ConnectionSpecProxy{URL: "connectivity-proxy.kyma-system.svc.cluster.local:20003"},
+			wantErr: "spec.proxy.url: Invalid value: \"connectivity-proxy.kyma-system.svc.cluster.local:20003\": must be an absolute URL with a scheme, e.g. http://connectivity-proxy.kyma-system.svc.cluster.local:20003",
+		},
+		{
+			name:    "scheme not matching protocol",
+			proxy:   v1alpha1.ConnectionSpecProxy{URL: "socks5://connectivity-proxy:20004"},
+			wantErr: "spec.proxy.url: Invalid value: \"socks5://connectivity-proxy:20004\": scheme \"socks5\" doesn't match protocol \"\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ProxyURL(tt.proxy)
+			if tt.wantErr == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   v1alpha1.ConnectionSpecTarget
+		wantErrs []string
+	}{
+		{name: "host", target: v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com"}},
+		{name: "host with port", target: v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com:5000"}},
+		{name: "IPv6 host with port", target: v1alpha1.ConnectionSpecTarget{Host: "[fd00::1]:5000"}},
+		{
+			name:     "host with scheme",
+			target:   v1alpha1.ConnectionSpecTarget{Host: "http://myregistry.example.com"},
+			wantErrs: []string{"spec.target.host: Invalid value: \"http://myregistry.example.com\": must have the host[:port] form, e.g. myregistry.example.com:5000"},
+		},
+		{
+			name:     "host with path",
+			target:   v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com/v2"},
+			wantErrs: []string{"spec.target.host: Invalid value: \"myregistry.example.com/v2\": must have the host[:port] form, e.g. myregistry.example.com:5000"},
+		},
+		{
+			name:     "port out of range",
+			target:   v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com:70000"},
+			wantErrs: []string{"spec.target.host: Invalid value: \"myregistry.example.com:70000\": port must be a number between 1 and 65535"},
+		},
+		{
+			name: "authorization host and header secret",
+			target: v1alpha1.ConnectionSpecTarget{
+				Host:          "myregistry.example.com",
+				Authorization: v1alpha1.ConnectionSpecTargetAuthorization{Host: "auth.example.com", HeaderSecret: "auth-secret"},
+			},
+			wantErrs: []string{"spec.target.authorization.headerSecret: Forbidden: can't be used together with host"},
+		},
+		{
+			name: "invalid authorization host",
+			target: v1alpha1.ConnectionSpecTarget{
+				Host:          "myregistry.example.com",
+				Authorization: v1alpha1.ConnectionSpecTargetAuthorization{Host: "https://auth.example.com/token"},
+			},
+			wantErrs: []string{"spec.target.authorization.host: Invalid value: \"https://auth.example.com/token\": must have the host[:port] form, e.g. myregistry.example.com:5000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Target(tt.target)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			require.Equal(t, tt.wantErrs, got)
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  v1alpha1.ConnectionSpecHeaders
+		wantErrs []string
+	}{
+		{name: "empty", headers: v1alpha1.ConnectionSpecHeaders{}},
+		{
+			name: "custom headers",
+			headers: v1alpha1.ConnectionSpecHeaders{
+				Add:           []v1alpha1.ConnectionSpecHeader{{Name: "X-Tenant", Value: "acme"}},
+				StripRequest:  []string{"Cookie"},
+				StripResponse: []string{"Server"},
+			},
+		},
+		{
+			name: "managed headers",
+			headers: v1alpha1.ConnectionSpecHeaders{
+				Add:          []v1alpha1.ConnectionSpecHeader{{Name: "X-Tenant", Value: "acme"}, {Name: "authorization", Value: "Basic Zm9vOmJhcg=="}},
+				StripRequest: []string{"Host"},
+			},
+			wantErrs: []string{
+				"spec.headers.add[1].name: Forbidden: authorization is managed by the connection",
+				"spec.headers.stripRequest[0]: Forbidden: Host is managed by the connection",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Headers(tt.headers)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			require.Equal(t, tt.wantErrs, got)
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := &v1alpha1.ConnectionSpecCache{Size: resource.MustParse("10Gi"), PersistentVolumeClaim: "layers"}
+	tests := []struct {
+		name    string
+		spec    v1alpha1.ConnectionSpec
+		wantErr string
+	}{
+		{name: "no cache", spec: v1alpha1.ConnectionSpec{Replicas: 3}},
+		{name: "emptyDir cache", spec: v1alpha1.ConnectionSpec{Replicas: 3, Cache: &v1alpha1.ConnectionSpecCache{Size: resource.MustParse("10Gi")}}},
+		{name: "claim of a single replica", spec: v1alpha1.ConnectionSpec{Replicas: 1, Cache: cache}},
+		{
+			name:    "claim of many replicas",
+			spec:    v1alpha1.ConnectionSpec{Replicas: 2, Cache: cache},
+			wantErr: "spec.cache.persistentVolumeClaim: Forbidden: can't be shared by more than one replica",
+		},
+		{
+			name:    "claim with autoscaling",
+			spec:    v1alpha1.ConnectionSpec{Cache: cache, Autoscaling: &v1alpha1.ConnectionSpecAutoscaling{MaxReplicas: 1}},
+			wantErr: "spec.cache.persistentVolumeClaim: Forbidden: can't be shared by more than one replica",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Cache(tt.spec)
+			if tt.wantErr == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  *v1alpha1.ConnectionSpecLimits
+		wantErr string
+	}{
+		{name: "no limits"},
+		{name: "no request rate", limits: &v1alpha1.ConnectionSpecLimits{MaxConcurrentRequests: 10}},
+		{name: "fractional request rate", limits: &v1alpha1.ConnectionSpecLimits{RequestsPerSecond: ptr.To(resource.MustParse("500m"))}},
+		{
+			name:    "zero request rate",
+			limits:  &v1alpha1.ConnectionSpecLimits{RequestsPerSecond: ptr.To(resource.MustParse("0"))},
+			wantErr: "spec.limits.requestsPerSecond: Invalid value: \"0\": must be greater than 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Limits(tt.limits)
+			if tt.wantErr == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	tests := []struct {
+		name       string
+		coalescing *v1alpha1.ConnectionSpecCoalescing
+		wantErr    string
+	}{
+		{name: "no coalescing"},
+		{name: "no spool size", coalescing: &v1alpha1.ConnectionSpecCoalescing{}},
+		{name: "disabled spooling", coalescing: &v1alpha1.ConnectionSpecCoalescing{SpoolSize: ptr.To(resource.MustParse("0"))}},
+		{
+			name:       "negative spool size",
+			coalescing: &v1alpha1.ConnectionSpecCoalescing{SpoolSize: ptr.To(resource.MustParse("-1Gi"))},
+			wantErr:    "spec.coalescing.spoolSize: Invalid value: \"-1Gi\": must not be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Coalescing(tt.coalescing)
+			if tt.wantErr == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSecret(t *testing.T) {
+	connection := func(authorization v1alpha1.ConnectionSpecTargetAuthorization) *v1alpha1.Connection {
+		return &v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "team"},
+			Spec:       v1alpha1.ConnectionSpec{Target: v1alpha1.ConnectionSpecTarget{Host: "myregistry.example.com", Authorization: authorization}},
+		}
+	}
+	secret := func(data map[string]string) client.Object {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "auth-secret", Namespace: "team"}, Data: map[string][]byte{}}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+
+	t.Run("should accept secrets with the keys read by the connection", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret(map[string]string{"username": "user", "password": "pass"})).Build()
+
+		invalid, err := Secret(context.Background(), c, connection(v1alpha1.ConnectionSpecTargetAuthorization{CredentialsSecret: "auth-secret"}))
+
+		require.NoError(t, err)
+		require.Nil(t, invalid)
+	})
+
+	t.Run("should reject missing secrets", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+
+		invalid, err := Secret(context.Background(), c, connection(v1alpha1.ConnectionSpecTargetAuthorization{HeaderSecret: "auth-secret"}))
+
+		require.NoError(t, err)
+		require.EqualError(t, invalid, "spec.target.authorization.headerSecret: Not found: \"auth-secret\"")
+	})
+
+	t.Run("should reject secrets without the keys read by the connection", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret(map[string]string{"username": "user"})).Build()
+
+		invalid, err := Secret(context.Background(), c, connection(v1alpha1.ConnectionSpecTargetAuthorization{CredentialsSecret: "auth-secret"}))
+
+		require.NoError(t, err)
+		require.EqualError(t, invalid, "spec.target.authorization.credentialsSecret: Invalid value: \"auth-secret\": Secret must contain the password key")
+	})
+}
+
+func TestNodePort(t *testing.T) {
+	connection := &v1alpha1.Connection{
+		ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "team"},
+		Spec:       v1alpha1.ConnectionSpec{NodePort: 30500},
+	}
+	service := func(namespace, name string, nodePort int32) client.Object {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "registry", NodePort: nodePort}}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		wantErr string
+	}{
+		{name: "free", objs: []client.Object{service("team", "other", 30501)}},
+		{name: "allocated for the Connection", objs: []client.Object{connection, service("team", "connection", 30500)}},
+		{
+			name:    "used by a Service",
+			objs:    []client.Object{service("default", "other", 30500)},
+			wantErr: "spec.nodePort: Invalid value: 30500: already used by Service default/other",
+		},
+		{
+			name: "requested by another Connection",
+			objs: []client.Object{&v1alpha1.Connection{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+				Spec:       v1alpha1.ConnectionSpec{NodePort: 30500},
+			}},
+			wantErr: "spec.nodePort: Invalid value: 30500: already used by Connection default/other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(tt.objs...).Build()
+
+			invalid, err := NodePort(context.Background(), c, connection)
+
+			require.NoError(t, err)
+			if tt.wantErr == "" {
+				require.Nil(t, invalid)
+				return
+			}
+			require.EqualError(t, invalid, tt.wantErr)
+		})
+	}
+}
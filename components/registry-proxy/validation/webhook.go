@@ -0,0 +1,91 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-registry-proxy-kyma-project-io-v1alpha1-connection,mutating=false,failurePolicy=ignore,sideEffects=None,groups=registry-proxy.kyma-project.io,resources=connections,verbs=create;update,versions=v1alpha1,name=connections.registry-proxy.kyma-project.io,admissionReviewVersions=v1
+
+// ConnectionValidator rejects Connections which the controller would report as not ready for their configuration,
+// so the problems are visible when the Connection is applied
+type ConnectionValidator struct {
+	// Client reads Secrets, Services and Connections of all namespaces
+	Client client.Reader
+}
+
+var _ admission.CustomValidator = &ConnectionValidator{}
+
+func (v *ConnectionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	connection, ok := obj.(*v1alpha1.Connection)
+	if !ok {
+		return nil, fmt.Errorf("expected a Connection but got %T", obj)
+	}
+	return nil, v.validate(ctx, connection)
+}
+
+func (v *ConnectionValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldConnection, ok := oldObj.(*v1alpha1.Connection)
+	if !ok {
+		return nil, fmt.Errorf("expected a Connection but got %T", oldObj)
+	}
+	connection, ok := newObj.(*v1alpha1.Connection)
+	if !ok {
+		return nil, fmt.Errorf("expected a Connection but got %T", newObj)
+	}
+	if connection.DeletionTimestamp != nil || equality.Semantic.DeepEqual(oldConnection.Spec, connection.Spec) {
+		// metadata changes, e.g. removal of finalizers, aren't blocked by resources the Connection depends on
+		return nil, nil
+	}
+	return nil, v.validate(ctx, connection)
+}
+
+func (v *ConnectionValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *ConnectionValidator) validate(ctx context.Context, connection *v1alpha1.Connection) error {
+	var errs field.ErrorList
+	if err := ProxyURL(connection.Spec.Proxy); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, Target(connection.Spec.Target)...)
+	errs = append(errs, Headers(connection.Spec.Headers)...)
+	if err := Cache(connection.Spec); err != nil {
+		errs = append(errs, err)
+	}
+	if err := Limits(connection.Spec.Limits); err != nil {
+		errs = append(errs, err)
+	}
+	if err := Coalescing(connection.Spec.Coalescing); err != nil {
+		errs = append(errs, err)
+	}
+
+	secretErr, err := Secret(ctx, v.Client, connection)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if secretErr != nil {
+		errs = append(errs, secretErr)
+	}
+	nodePortErr, err := NodePort(ctx, v.Client, connection)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if nodePortErr != nil {
+		errs = append(errs, nodePortErr)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(v1alpha1.GroupVersion.WithKind("Connection").GroupKind(), connection.Name, errs)
+}
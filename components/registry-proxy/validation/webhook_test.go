@@ -0,0 +1,56 @@
+package validation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConnectionValidator(t *testing.T) {
+	newConnection := func() *v1alpha1.Connection {
+		return &v1alpha1.Connection{
+			ObjectMeta: metav1.ObjectMeta{Name: "connection", Namespace: "team"},
+			Spec: v1alpha1.ConnectionSpec{
+				Proxy: v1alpha1.ConnectionSpecProxy{URL: "connectivity-proxy:20003"},
+				Target: v1alpha1.ConnectionSpecTarget{
+					Host:          "myregistry.example.com:5000",
+					Authorization: v1alpha1.ConnectionSpecTargetAuthorization{HeaderSecret: "auth-secret"},
+				},
+			},
+		}
+	}
+	v := &ConnectionValidator{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).Build()}
+
+	t.Run("should reject invalid Connections with all problems", func(t *testing.T) {
+		_, err := v.ValidateCreate(context.Background(), newConnection())
+
+		require.True(t, apierrors.IsInvalid(err))
+		require.ErrorContains(t, err, "spec.proxy.url: Invalid value")
+		require.ErrorContains(t, err, "spec.target.authorization.headerSecret: Not found: \"auth-secret\"")
+	})
+
+	t.Run("should validate changes of the spec", func(t *testing.T) {
+		oldConnection := newConnection()
+		connection := newConnection()
+		connection.Spec.Target.Host = "other.example.com"
+
+		_, err := v.ValidateUpdate(context.Background(), oldConnection, connection)
+
+		require.True(t, apierrors.IsInvalid(err))
+	})
+
+	t.Run("should allow changes of metadata", func(t *testing.T) {
+		oldConnection := newConnection()
+		connection := newConnection()
+		connection.Finalizers = []string{"registry-proxy.kyma-project.io/finalizer"}
+
+		_, err := v.ValidateUpdate(context.Background(), oldConnection, connection)
+
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,172 @@
+package webhookcert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kyma-project/registry-proxy/components/registry-proxy/api/v1alpha1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ServiceName is the name of the Service of the webhook server in the namespace of the controller
+	ServiceName = "registry-proxy-webhook"
+	// SecretName is the name of the Secret holding the serving certificate of the webhook
+	SecretName = "registry-proxy-webhook-cert"
+	// MutatingConfigurationName is the name of the MutatingWebhookConfiguration rewriting images of Pods
+	MutatingConfigurationName = "registry-proxy-image-rewrite"
+	// ValidatingConfigurationName is the name of the ValidatingWebhookConfiguration validating Connections
+	ValidatingConfigurationName = "registry-proxy-connection-validation"
+
+	certificateValidity = 10 * 365 * 24 * time.Hour
+	// the certificate is checked when the controller starts, a new one is created if it expires soon
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// Ensure makes sure a valid serving certificate of the webhook server is stored in the Secret,
+// written to certDir for the webhook server and trusted by the webhook configurations.
+// The certificate is self-signed, it's trusted only through the CA bundles of the configurations.
+func Ensure(ctx context.Context, c client.Client, namespace, certDir string) error {
+	dnsNames := []string{
+		fmt.Sprintf("%s.%s.svc", ServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", ServiceName, namespace),
+	}
+
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: SecretName}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get Secret %s/%s: %w", namespace, SecretName, err)
+	}
+	exists := err == nil
+
+	if !validCertificate(secret.Data, dnsNames[0], time.Now()) {
+		cert, key, err := newCertificate(dnsNames, time.Now())
+		if err != nil {
+			return fmt.Errorf("unable to create certificate: %w", err)
+		}
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Name:            SecretName,
+			Namespace:       namespace,
+			ResourceVersion: secret.ResourceVersion,
+			Labels: map[string]string{
+				v1alpha1.LabelModuleName: "registry-proxy",
+				v1alpha1.LabelPartOf:     "registry-proxy",
+			},
+		}
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+		}
+		if exists {
+			err = c.Update(ctx, secret)
+		} else {
+			err = c.Create(ctx, secret)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to save Secret %s/%s: %w", namespace, SecretName, err)
+		}
+	}
+
+	if err := os.MkdirAll(certDir, 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(certDir, corev1.TLSCertKey), secret.Data[corev1.TLSCertKey], 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(certDir, corev1.TLSPrivateKeyKey), secret.Data[corev1.TLSPrivateKeyKey], 0o600); err != nil {
+		return err
+	}
+
+	cert := secret.Data[corev1.TLSCertKey]
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	err = trustCertificate(ctx, c, "MutatingWebhookConfiguration", MutatingConfigurationName, mutating, func() {
+		for i := range mutating.Webhooks {
+			mutating.Webhooks[i].ClientConfig.CABundle = cert
+		}
+	})
+	if err != nil {
+		return err
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	return trustCertificate(ctx, c, "ValidatingWebhookConfiguration", ValidatingConfigurationName, validating, func() {
+		for i := range validating.Webhooks {
+			validating.Webhooks[i].ClientConfig.CABundle = cert
+		}
+	})
+}
+
+// trustCertificate sets the CA bundle of all webhooks of the configuration with setCABundle,
+// the bundle isn't part of the chart so it isn't overwritten when the chart is applied
+func trustCertificate(ctx context.Context, c client.Client, kind, name string, configuration client.Object, setCABundle func()) error {
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, configuration); err != nil {
+		return fmt.Errorf("unable to get %s %s: %w", kind, name, err)
+	}
+	patch := client.StrategicMergeFrom(configuration.DeepCopyObject().(client.Object))
+	setCABundle()
+	if err := c.Patch(ctx, configuration, patch); err != nil {
+		return fmt.Errorf("unable to patch %s %s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// validCertificate checks the certificate and key of the secret are a pair, valid for the host and not expiring soon
+func validCertificate(data map[string][]byte, host string, now time.Time) bool {
+	pair, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	return cert.VerifyHostname(host) == nil && now.Add(renewBefore).Before(cert.NotAfter)
+}
+
+// newCertificate returns a PEM encoded self-signed certificate for the DNS names and its key
+func newCertificate(dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:              dnsNames,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,97 @@
+package webhookcert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEnsure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	configurations := func() []client.Object {
+		return []client.Object{
+			&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: MutatingConfigurationName},
+				Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "pods.registry-proxy.kyma-project.io"}},
+			},
+			&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: ValidatingConfigurationName},
+				Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "connections.registry-proxy.kyma-project.io"}},
+			},
+		}
+	}
+
+	t.Run("should create the certificate and trust it", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configurations()...).Build()
+		certDir := t.TempDir()
+
+		require.NoError(t, Ensure(context.Background(), c, "kyma-system", certDir))
+
+		secret := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "kyma-system", Name: SecretName}, secret))
+		require.True(t, validCertificate(secret.Data, "registry-proxy-webhook.kyma-system.svc", time.Now()))
+		cert, err := os.ReadFile(filepath.Join(certDir, corev1.TLSCertKey))
+		require.NoError(t, err)
+		require.Equal(t, secret.Data[corev1.TLSCertKey], cert)
+
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: MutatingConfigurationName}, mutating))
+		require.Equal(t, cert, mutating.Webhooks[0].ClientConfig.CABundle)
+		validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: ValidatingConfigurationName}, validating))
+		require.Equal(t, cert, validating.Webhooks[0].ClientConfig.CABundle)
+	})
+
+	t.Run("should keep a valid certificate", func(t *testing.T) {
+		cert, key, err := newCertificate([]string{"registry-proxy-webhook.kyma-system.svc"}, time.Now())
+		require.NoError(t, err)
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: SecretName},
+			Data:       map[string][]byte{corev1.TLSCertKey: cert, corev1.TLSPrivateKeyKey: key},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(configurations(), secret)...).Build()
+
+		require.NoError(t, Ensure(context.Background(), c, "kyma-system", t.TempDir()))
+
+		updated := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "kyma-system", Name: SecretName}, updated))
+		require.Equal(t, cert, updated.Data[corev1.TLSCertKey])
+	})
+
+	t.Run("should replace a certificate expiring soon", func(t *testing.T) {
+		cert, key, err := newCertificate([]string{"registry-proxy-webhook.kyma-system.svc"}, time.Now().Add(-certificateValidity+24*time.Hour))
+		require.NoError(t, err)
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: SecretName},
+			Data:       map[string][]byte{corev1.TLSCertKey: cert, corev1.TLSPrivateKeyKey: key},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(configurations(), secret)...).Build()
+
+		require.NoError(t, Ensure(context.Background(), c, "kyma-system", t.TempDir()))
+
+		updated := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "kyma-system", Name: SecretName}, updated))
+		require.NotEqual(t, cert, updated.Data[corev1.TLSCertKey])
+		require.True(t, validCertificate(updated.Data, "registry-proxy-webhook.kyma-system.svc", time.Now()))
+	})
+
+	t.Run("should fail without the webhook configurations", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		err := Ensure(context.Background(), c, "kyma-system", t.TempDir())
+
+		require.ErrorContains(t, err, "unable to get MutatingWebhookConfiguration")
+	})
+}